@@ -10,6 +10,7 @@ import (
 	"github.com/sagarmaheshwary/kind-microservices-demo/api-gateway/internal/config"
 	"github.com/sagarmaheshwary/kind-microservices-demo/api-gateway/internal/logger"
 	"github.com/sagarmaheshwary/kind-microservices-demo/api-gateway/internal/service"
+	"github.com/sagarmaheshwary/kind-microservices-demo/api-gateway/internal/tracing"
 	"github.com/sagarmaheshwary/kind-microservices-demo/api-gateway/internal/transports/http/client"
 	"github.com/sagarmaheshwary/kind-microservices-demo/api-gateway/internal/transports/http/server"
 )
@@ -25,6 +26,19 @@ func main() {
 		log.Fatal(err.Error())
 	}
 
+	tp, err := tracing.NewTracerProvider(ctx, &tracing.TracerProviderOpts{
+		ServiceName:  "api-gateway",
+		OTLPEndpoint: cfg.Tracing.OTLPEndpoint,
+	})
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	defer func() {
+		if err := tp.Shutdown(context.Background()); err != nil {
+			log.Error("failed to shut down tracer provider", logger.Field{Key: "error", Value: err.Error()})
+		}
+	}()
+
 	httpClient := client.NewUserClient(client.NewHTTPClient(cfg.UserClient.URL, cfg.UserClient.Timeout))
 
 	healthService := service.NewHealthService(&service.HealthServiceOpts{
@@ -19,6 +19,7 @@ type LoaderOptions struct {
 
 type Config struct {
 	HTTPServer *HTTPServer
+	Tracing    *Tracing
 	UserClient *UserClient
 }
 
@@ -26,6 +27,13 @@ type HTTPServer struct {
 	URL             string
 	ShutdownTimeout time.Duration
 }
+
+// Tracing exports spans over OTLP/HTTP to OTLPEndpoint, the base URL of a
+// collector such as http://otel-collector:4318. Empty exports nothing.
+type Tracing struct {
+	OTLPEndpoint string
+}
+
 type UserClient struct {
 	URL     string
 	Timeout time.Duration
@@ -63,6 +71,9 @@ func NewConfigWithOptions(opts LoaderOptions) (*Config, error) {
 			URL:             getEnv("HTTP_SERVER_URL", ":4000"),
 			ShutdownTimeout: getEnvDuration("HTTP_SERVER_SHUTDOWN_TIMEOUT", 5*time.Second),
 		},
+		Tracing: &Tracing{
+			OTLPEndpoint: getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", ""),
+		},
 		UserClient: &UserClient{
 			URL:     getEnv("USER_SERVICE_CLIENT_URL", "http://user-service:4000"),
 			Timeout: getEnvDuration("USER_SERVICE_CLIENT_TIMEOUT", 2*time.Second),
@@ -8,6 +8,8 @@ import (
 	"net/http"
 
 	"github.com/sagarmaheshwary/kind-microservices-demo/api-gateway/internal/constant"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 type UserClient struct {
@@ -35,6 +37,7 @@ func (c *UserClient) CreateUser(ctx context.Context, req *CreateUserRequest) (*C
 	}
 
 	httpReq.Header.Set("Content-Type", "application/json")
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(httpReq.Header))
 
 	resp, err := c.http.Client.Do(httpReq)
 	if err != nil {
@@ -31,6 +31,7 @@ func NewServer(opts *Opts) *HTTPServer {
 	r.Use(
 		gin.Recovery(),
 		middleware.ZerologMiddleware(),
+		middleware.TracingMiddleware(),
 	)
 
 	healthHandler := handler.NewHealthHandler(&handler.HealthHandlerOpts{
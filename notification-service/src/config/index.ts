@@ -6,11 +6,22 @@ export default () => ({
   amqp: {
     host: <string>getEnv('AMQP_HOST', 'rabbitmq'),
     port: <number>getEnv('AMQP_PORT', 5672),
+    hosts: (<string>getEnv('AMQP_HOSTS', ''))
+      .split(',')
+      .map((host) => host.trim())
+      .filter(Boolean),
+    queueType: <string>getEnv('AMQP_QUEUE_TYPE', 'classic'),
     username: <string>getEnv('AMQP_USERNAME', 'default'),
     password: <string>getEnv('AMQP_PASSWORD', 'default'),
     queue: <string>getEnv('AMQP_QUEUE', 'notification-service'),
     maxConnectionAttempts: <number>getEnv('AMQP_MAX_CONNECTION_ATTEMPTS', 10),
   },
+  // Must match user-service, whose data keys are read from the shared keyDir.
+  pii: {
+    enabled: <string>getEnv('PII_ENCRYPTION_ENABLED', 'false') === 'true',
+    masterKey: <string>getEnv('PII_MASTER_KEY', ''),
+    keyDir: <string>getEnv('PII_KEY_DIR', '/var/lib/pii-keys'),
+  },
 });
 
 const getEnv = (key: string, defaultVal: any = null) => {
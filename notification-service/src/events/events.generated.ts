@@ -0,0 +1,15 @@
+// Code generated by user-service/cmd/eventgen from user-service/events.yaml. DO NOT EDIT.
+
+export const USER_CREATED_EVENT = 'user.created';
+
+/**
+ * Payload of user.created v1. Published when a user signs up. Consumed by notification-service to send the welcome email.
+ */
+export interface UserCreated {
+  id: number;
+  uuid: string;
+  /** Encrypted on the wire when PII encryption is enabled. */
+  name: string;
+  /** Encrypted on the wire when PII encryption is enabled. */
+  email: string;
+}
@@ -19,16 +19,23 @@ async function bootstrap() {
   const config = app.get<ConfigService>(ConfigService);
 
   const amqpConfig = config.get('amqp');
+  const hosts: string[] = amqpConfig.hosts.length
+    ? amqpConfig.hosts
+    : [`${amqpConfig.host}:${amqpConfig.port}`];
+  const quorum = amqpConfig.queueType === 'quorum';
   const rmq = app.connectMicroservice<MicroserviceOptions>({
     transport: Transport.RMQ,
     options: {
-      urls: [
-        `amqp://${amqpConfig.username}:${amqpConfig.password}@${amqpConfig.host}:${amqpConfig.port}`,
-      ],
+      urls: hosts.map(
+        (host) =>
+          `amqp://${amqpConfig.username}:${amqpConfig.password}@${host}`,
+      ),
       queue: amqpConfig.queue,
-      queueOptions: {
-        durable: false,
-      },
+      // Must match how user-service declares the queue, or the broker
+      // rejects the declaration with PRECONDITION_FAILED.
+      queueOptions: quorum
+        ? { durable: true, arguments: { 'x-queue-type': 'quorum' } }
+        : { durable: false },
       noAck: false,
       maxConnectionAttempts: amqpConfig.maxConnectionAttempts,
     },
@@ -1,14 +1,20 @@
-import { Controller, Logger } from '@nestjs/common';
+import { Controller, Logger, UseInterceptors } from '@nestjs/common';
 import { Ctx, EventPattern, Payload, RmqContext } from '@nestjs/microservices';
+import { USER_CREATED_EVENT, UserCreated } from '../../events/events.generated';
+import { PiiDecryptInterceptor } from '../../pii/pii-decrypt.interceptor';
 
 @Controller()
+@UseInterceptors(PiiDecryptInterceptor)
 export class NotificationController {
   private readonly logger = new Logger(NotificationController.name, {
     timestamp: true,
   });
 
-  @EventPattern('user.created')
-  async handleUserCreated(@Payload() data: any, @Ctx() context: RmqContext) {
+  @EventPattern(USER_CREATED_EVENT)
+  async handleUserCreated(
+    @Payload() data: UserCreated,
+    @Ctx() context: RmqContext,
+  ) {
     this.logger.log(`Sending welcome email to ${data.name}`);
 
     // Acknowledge message manually
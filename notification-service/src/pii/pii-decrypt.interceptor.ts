@@ -0,0 +1,68 @@
+import {
+  CallHandler,
+  ExecutionContext,
+  Injectable,
+  Logger,
+  NestInterceptor,
+} from '@nestjs/common';
+import { ConfigService } from '@nestjs/config';
+import { RmqContext } from '@nestjs/microservices';
+import { from, Observable, of, switchMap } from 'rxjs';
+import { decryptPayload, LocalKms, SubjectForgottenError } from './pii';
+
+/**
+ * Decrypts the PII fields user-service encrypted before the handler sees the
+ * payload. Events about a subject whose key was shredded are acknowledged and
+ * dropped, since there is nobody left to notify; events that fail to decrypt
+ * otherwise are rejected without requeueing.
+ */
+@Injectable()
+export class PiiDecryptInterceptor implements NestInterceptor {
+  private readonly logger = new Logger(PiiDecryptInterceptor.name, {
+    timestamp: true,
+  });
+
+  private readonly kms?: LocalKms;
+
+  constructor(config: ConfigService) {
+    if (config.get<boolean>('pii.enabled')) {
+      this.kms = new LocalKms(
+        config.get<string>('pii.masterKey', ''),
+        config.get<string>('pii.keyDir', ''),
+      );
+    }
+  }
+
+  intercept(context: ExecutionContext, next: CallHandler): Observable<unknown> {
+    if (!this.kms || context.getType() !== 'rpc') {
+      return next.handle();
+    }
+
+    const rpc = context.switchToRpc();
+    const rmq = rpc.getContext<RmqContext>();
+
+    const decrypted = decryptPayload(this.kms, rpc.getData()).then(
+      () => true,
+      (err: Error) => {
+        const channel = rmq.getChannelRef();
+        const message = rmq.getMessage();
+
+        if (err instanceof SubjectForgottenError) {
+          this.logger.warn(`Dropping ${rmq.getPattern()}: ${err.message}`);
+          channel.ack(message);
+        } else {
+          this.logger.error(
+            `Failed to decrypt ${rmq.getPattern()}: ${err.message}`,
+          );
+          channel.nack(message, false, false);
+        }
+
+        return false;
+      },
+    );
+
+    return from(decrypted).pipe(
+      switchMap((ok) => (ok ? next.handle() : of(undefined))),
+    );
+  }
+}
@@ -0,0 +1,130 @@
+import { createDecipheriv, createHash } from 'crypto';
+import { readFile } from 'fs/promises';
+import { join } from 'path';
+
+// Mirrors user-service/internal/pii. Encrypted values look like
+// pii:v1:<subject>:<ciphertext>, with the subject base64url and the
+// ciphertext base64 encoded, sealed with AES-256-GCM under the subject's data
+// key and bound to the subject and field name.
+const CIPHERTEXT_PREFIX = 'pii:v1:';
+const NONCE_SIZE = 12;
+const TAG_SIZE = 16;
+
+/**
+ * Thrown for data of a subject whose key was deleted. The data can never be
+ * decrypted again.
+ */
+export class SubjectForgottenError extends Error {
+  constructor(subject: string) {
+    super(`pii data key of subject was deleted: ${subject}`);
+  }
+}
+
+/**
+ * Reads the data keys user-service stores in a shared directory, wrapped with
+ * the master key. It only looks keys up, it never creates or deletes them.
+ */
+export class LocalKms {
+  private readonly masterKey: Buffer;
+
+  constructor(
+    masterKey: string,
+    private readonly dir: string,
+  ) {
+    this.masterKey = Buffer.from(masterKey, 'base64');
+    if (this.masterKey.length !== 32) {
+      throw new Error('pii master key must be 32 bytes, base64 encoded');
+    }
+  }
+
+  async lookupDataKey(subject: string): Promise<Buffer> {
+    let raw: string;
+    try {
+      raw = await readFile(this.path(subject), 'utf8');
+    } catch (err) {
+      if ((err as NodeJS.ErrnoException).code === 'ENOENT') {
+        throw new SubjectForgottenError(subject);
+      }
+      throw err;
+    }
+
+    return open(this.masterKey, Buffer.from(raw, 'base64'), subject);
+  }
+
+  private path(subject: string): string {
+    const sum = createHash('sha256').update(subject).digest('hex');
+    return join(this.dir, `${sum}.key`);
+  }
+}
+
+/**
+ * Decrypts in place the encrypted values of a payload decoded from JSON.
+ * Rejects with SubjectForgottenError when a subject's key was shredded.
+ */
+export async function decryptPayload(
+  kms: LocalKms,
+  payload: unknown,
+): Promise<void> {
+  const keys = new Map<string, Buffer>();
+
+  const walk = async (value: unknown, name: string): Promise<unknown> => {
+    if (typeof value === 'string') {
+      return value.startsWith(CIPHERTEXT_PREFIX)
+        ? decryptValue(kms, keys, value, name)
+        : value;
+    }
+    if (Array.isArray(value)) {
+      for (let i = 0; i < value.length; i++) {
+        value[i] = await walk(value[i], name);
+      }
+    } else if (value !== null && typeof value === 'object') {
+      const record = value as Record<string, unknown>;
+      for (const key of Object.keys(record)) {
+        record[key] = await walk(record[key], key);
+      }
+    }
+    return value;
+  };
+
+  await walk(payload, '');
+}
+
+async function decryptValue(
+  kms: LocalKms,
+  keys: Map<string, Buffer>,
+  value: string,
+  name: string,
+): Promise<string> {
+  const rest = value.slice(CIPHERTEXT_PREFIX.length);
+  const sep = rest.indexOf(':');
+  if (sep < 0) {
+    throw new Error('pii ciphertext is malformed');
+  }
+
+  const subject = Buffer.from(rest.slice(0, sep), 'base64url').toString();
+  const sealed = Buffer.from(rest.slice(sep + 1), 'base64');
+
+  let key = keys.get(subject);
+  if (!key) {
+    key = await kms.lookupDataKey(subject);
+    keys.set(subject, key);
+  }
+
+  return open(key, sealed, `${subject}\x00${name}`).toString();
+}
+
+function open(key: Buffer, sealed: Buffer, additionalData: string): Buffer {
+  if (sealed.length < NONCE_SIZE + TAG_SIZE) {
+    throw new Error('pii ciphertext is too short');
+  }
+
+  const nonce = sealed.subarray(0, NONCE_SIZE);
+  const ciphertext = sealed.subarray(NONCE_SIZE, sealed.length - TAG_SIZE);
+  const tag = sealed.subarray(sealed.length - TAG_SIZE);
+
+  const decipher = createDecipheriv('aes-256-gcm', key, nonce);
+  decipher.setAAD(Buffer.from(additionalData));
+  decipher.setAuthTag(tag);
+
+  return Buffer.concat([decipher.update(ciphertext), decipher.final()]);
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/eventcatalog"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+)
+
+// eventgen generates the event publishers, constants, schemas, TypeScript
+// interfaces and AsyncAPI documents from the event catalog. With --check it
+// writes nothing and fails if any generated file is out of date.
+func main() {
+	root := flag.String("root", ".", "user-service directory")
+	tsOut := flag.String("ts", "../notification-service/src/events/events.generated.ts", "TypeScript output, relative to --root")
+	check := flag.Bool("check", false, "fail if generated files are out of date instead of writing them")
+	flag.Parse()
+
+	log := logger.NewZerologLogger("info", os.Stderr)
+
+	catalog, err := eventcatalog.Load(filepath.Join(*root, "events.yaml"))
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	module, err := modulePath(filepath.Join(*root, "go.mod"))
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	files := map[string]func() ([]byte, error){
+		"internal/events/events_gen.go":   func() ([]byte, error) { return catalog.Publishers(module) },
+		"internal/constant/events_gen.go": catalog.Constants,
+		"docs/asyncapi-2.yaml":            func() ([]byte, error) { return catalog.AsyncAPI(2) },
+		"docs/asyncapi-3.yaml":            func() ([]byte, error) { return catalog.AsyncAPI(3) },
+		*tsOut:                            catalog.TypeScript,
+	}
+	for _, e := range catalog.Events {
+		files[filepath.Join("internal/eventschema/schemas", e.SchemaPath())] = e.Schema
+	}
+
+	stale := false
+	for name, generate := range files {
+		content, err := generate()
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+
+		path := filepath.Join(*root, name)
+
+		if *check {
+			current, err := os.ReadFile(path)
+			if err != nil || !bytes.Equal(current, content) {
+				fmt.Printf("%s is out of date\n", name)
+				stale = true
+			}
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			log.Fatal(err.Error())
+		}
+		if err := os.WriteFile(path, content, 0o644); err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+
+	if stale {
+		fmt.Println("run go generate ./internal/events")
+		os.Exit(1)
+	}
+}
+
+func modulePath(goMod string) (string, error) {
+	f, err := os.Open(goMod)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if module, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "module "); ok {
+			return strings.TrimSpace(module), nil
+		}
+	}
+
+	return "", errors.Join(scanner.Err(), fmt.Errorf("no module directive in %s", goMod))
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"time"
+
+	amqplib "github.com/rabbitmq/amqp091-go"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/config"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/rabbitmq"
+)
+
+// replay republishes archived messages, optionally filtered by time range and
+// event pattern, to an exchange. Messages keep their ids and headers, so
+// consumers that deduplicate will skip those they already handled. With
+// --dry-run it only lists what would be sent.
+func main() {
+	dir := flag.String("dir", "", "archive directory (defaults to AMQP_ARCHIVE_DIR)")
+	from := flag.String("from", "", "only messages archived at or after this RFC 3339 time")
+	to := flag.String("to", "", "only messages archived before this RFC 3339 time")
+	pattern := flag.String("pattern", "", "only events whose pattern matches this glob, e.g. user.*")
+	exchange := flag.String("exchange", "", "exchange to publish to (defaults to the default exchange)")
+	routingKey := flag.String("routing-key", "", "routing key to publish with (defaults to the original one)")
+	rate := flag.Float64("rate", 10, "messages per second, 0 for no limit")
+	dryRun := flag.Bool("dry-run", false, "list the matching messages instead of publishing them")
+	flag.Parse()
+
+	log := logger.NewZerologLogger("info", os.Stderr)
+
+	cfg, err := config.NewConfig(log)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	if *dir == "" {
+		*dir = cfg.AMQP.Archive.Dir
+	}
+
+	start, err := parseTime(*from)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	end, err := parseTime(*to)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if _, err := path.Match(*pattern, ""); err != nil {
+		log.Fatal("invalid --pattern: " + err.Error())
+	}
+
+	filter := &rabbitmq.ArchiveFilter{From: start, To: end, Pattern: *pattern}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var channel *amqplib.Channel
+	if !*dryRun {
+		conn, err := rabbitmq.Dial(cfg.AMQP, cfg.App)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		defer conn.Close()
+
+		channel, err = conn.Channel()
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		if err := channel.Confirm(false); err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+
+	var limiter <-chan time.Time
+	if *rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / *rate))
+		defer ticker.Stop()
+		limiter = ticker.C
+	}
+
+	sent := 0
+	err = rabbitmq.ReadArchive(*dir, func(rec *rabbitmq.ArchiveRecord) error {
+		if !filter.Match(rec) {
+			return nil
+		}
+
+		key := rec.RoutingKey
+		if *routingKey != "" {
+			key = *routingKey
+		}
+
+		if *dryRun {
+			fmt.Printf("%s %s %s -> exchange=%q routing_key=%q\n",
+				rec.ArchivedAt.Format(time.RFC3339), rec.Publishing.Type, rec.Publishing.MessageId, *exchange, key)
+			sent++
+			return nil
+		}
+
+		if limiter != nil {
+			select {
+			case <-ctx.Done():
+				return rabbitmq.ErrStopReading
+			case <-limiter:
+			}
+		}
+		if ctx.Err() != nil {
+			return rabbitmq.ErrStopReading
+		}
+
+		msg := rec.Publishing
+		if msg.Headers == nil {
+			msg.Headers = amqplib.Table{}
+		}
+		msg.Headers[rabbitmq.HeaderReplayedAt] = time.Now().UTC()
+
+		confirm, err := channel.PublishWithDeferredConfirmWithContext(ctx, *exchange, key, false, false, msg)
+		if err != nil {
+			return err
+		}
+		if acked, err := confirm.WaitContext(ctx); err != nil {
+			return err
+		} else if !acked {
+			return fmt.Errorf("message %s was not confirmed by the broker", msg.MessageId)
+		}
+
+		sent++
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err.Error(), logger.Field{Key: "replayed", Value: sent})
+	}
+
+	if *dryRun {
+		log.Info("Dry run, nothing published", logger.Field{Key: "matched", Value: sent})
+		return
+	}
+
+	log.Info("Replay finished", logger.Field{Key: "replayed", Value: sent})
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	return time.Parse(time.RFC3339, s)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/constant"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/eventschema"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+)
+
+// schemacheck fails when the event schemas are not backward compatible with
+// those in --base, typically the schemas directory of the main branch:
+//
+//	git worktree add /tmp/base main
+//	go run ./cmd/schemacheck --base /tmp/base/user-service/internal/eventschema/schemas
+func main() {
+	base := flag.String("base", "", "schemas directory to compare against")
+	current := flag.String("current", "", "schemas directory to check (defaults to the embedded schemas)")
+	flag.Parse()
+
+	log := logger.NewZerologLogger("info", os.Stderr)
+
+	if *base == "" {
+		log.Fatal("no base schemas, set --base")
+	}
+
+	schemas := eventschema.Schemas()
+	if *current != "" {
+		schemas = os.DirFS(*current)
+	}
+
+	registry, err := eventschema.Load(schemas)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	failed := false
+	for _, ref := range registry.Missing(constant.EVENT_SCHEMA_VERSIONS) {
+		fmt.Printf("%s: no schema for published event\n", ref)
+		failed = true
+	}
+
+	changes, err := eventschema.Compare(os.DirFS(*base), schemas)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	for _, change := range changes {
+		fmt.Println(change)
+		failed = true
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+
+	log.Info("Event schemas are backward compatible")
+}
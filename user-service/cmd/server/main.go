@@ -3,19 +3,27 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 
 	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/config"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/constant"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/eventbus"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/eventschema"
 	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/natsbus"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/pii"
 	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/rabbitmq"
 	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/service"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/tracing"
 	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/transports/http/server"
 )
 
 func main() {
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
 	log := logger.NewZerologLogger("info", os.Stderr)
@@ -25,28 +33,64 @@ func main() {
 		log.Fatal(err.Error())
 	}
 
-	rmq := rabbitmq.NewRabbitMQ(ctx, &rabbitmq.Opts{
-		Logger: log,
-		Config: cfg.AMQP,
+	tp, err := tracing.NewTracerProvider(ctx, &tracing.TracerProviderOpts{
+		ServiceName:    cfg.App.Name,
+		ServiceVersion: cfg.App.Version,
+		OTLPEndpoint:   cfg.Tracing.OTLPEndpoint,
+	})
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	defer func() {
+		if err := tp.Shutdown(context.Background()); err != nil {
+			log.Error("failed to shut down tracer provider", logger.Field{Key: "error", Value: err.Error()})
+		}
+	}()
+
+	bus, checks, quarantineService := newEventBus(ctx, cfg, log, stop)
+
+	if cfg.PII.Enabled {
+		kms, err := pii.NewLocalKMS(&pii.LocalKMSOpts{
+			MasterKey: cfg.PII.MasterKey,
+			Dir:       cfg.PII.KeyDir,
+		})
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		bus = pii.NewEncryptingEventBus(&pii.EncryptingEventBusOpts{
+			EventBus: bus,
+			KMS:      kms,
+		})
+	}
+
+	schemas, err := eventschema.NewRegistry()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	for _, ref := range schemas.Missing(constant.EVENT_SCHEMA_VERSIONS) {
+		log.Fatal("no schema for published event " + ref.String())
+	}
+	bus = eventschema.NewValidatingEventBus(&eventschema.ValidatingEventBusOpts{
+		EventBus: bus,
+		Registry: schemas,
+		Mode:     cfg.EventBus.SchemaValidation,
+		Logger:   log,
 	})
 
 	healthService := service.NewHealthService(&service.HealthServiceOpts{
-		Checks: map[string]service.DependencyHealthCheck{
-			"rabbitmq": func(ctx context.Context) error {
-				return rmq.Health()
-			},
-		},
+		Checks: checks,
 	})
 	userService := service.NewUserService(&service.UserServiceOpts{
-		Rabbitmq: rmq,
+		EventBus: bus,
 		Logger:   log,
 	})
 
 	httpServer := server.NewServer(&server.Opts{
-		Config:        cfg.HTTPServer,
-		Logger:        log,
-		HealthService: healthService,
-		UserService:   userService,
+		Config:            cfg.HTTPServer,
+		Logger:            log,
+		HealthService:     healthService,
+		UserService:       userService,
+		QuarantineService: quarantineService,
 	})
 	go func() {
 		err = httpServer.Serve()
@@ -67,5 +111,113 @@ func main() {
 	}
 	httpCancel()
 
+	busCtx, busCancel := context.WithTimeout(context.Background(), cfg.EventBus.ShutdownTimeout)
+	if err := bus.Close(busCtx); err != nil {
+		log.Error("failed to close event bus", logger.Field{Key: "error", Value: err.Error()})
+	}
+	busCancel()
+
 	log.Info("Shutdown complete!")
 }
+
+// newEventBus connects the event bus selected by EVENT_BUS_DRIVER and returns
+// its readiness checks, and the quarantine service when quarantined messages
+// are collected.
+func newEventBus(ctx context.Context, cfg *config.Config, log logger.Logger, stop context.CancelFunc) (eventbus.EventBus, map[string]service.DependencyHealthCheck, service.QuarantineService) {
+	switch cfg.EventBus.Driver {
+	case "nats":
+		bus, err := natsbus.New(&natsbus.Opts{
+			Logger: log,
+			Config: cfg.NATS,
+			App:    cfg.App,
+		})
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+
+		checks := map[string]service.DependencyHealthCheck{
+			"nats": func(ctx context.Context) error {
+				return bus.Health()
+			},
+		}
+
+		return bus, checks, nil
+	}
+
+	rmq := rabbitmq.New(ctx, &rabbitmq.Opts{
+		Logger: log,
+		Config: cfg.AMQP,
+		App:    cfg.App,
+	})
+
+	go func() {
+		states, unsubscribe := rmq.SubscribeState()
+		defer unsubscribe()
+
+		for state := range states {
+			if state == rabbitmq.StateFailed {
+				log.Error("AMQP connection could not be re-established, shutting down")
+				stop()
+				return
+			}
+		}
+	}()
+
+	checks := map[string]service.DependencyHealthCheck{
+		"rabbitmq": func(ctx context.Context) error {
+			return rmq.Health()
+		},
+	}
+
+	if len(cfg.AMQP.Monitor.Queues) > 0 {
+		monitor := rabbitmq.NewQueueMonitor(&rabbitmq.QueueMonitorOpts{
+			RabbitMQ: rmq,
+			Config:   cfg.AMQP.Monitor,
+			Logger:   log,
+		})
+		go monitor.Run(ctx)
+
+		checks["rabbitmq_flow_control"] = func(ctx context.Context) error {
+			return degraded(monitor.FlowControl())
+		}
+		for _, queue := range cfg.AMQP.Monitor.Queues {
+			checks["rabbitmq_queue_"+queue] = func(ctx context.Context) error {
+				return degraded(monitor.QueueHealth(queue))
+			}
+		}
+	}
+
+	var quarantineService service.QuarantineService
+	if cfg.AMQP.Quarantine.StoreDir != "" {
+		store, err := rabbitmq.NewFileQuarantineStore(&rabbitmq.FileQuarantineStoreOpts{Dir: cfg.AMQP.Quarantine.StoreDir})
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+
+		go func() {
+			if err := rmq.CollectQuarantine(ctx, store); err != nil && !errors.Is(err, context.Canceled) {
+				log.Error("AMQP quarantine collector stopped", logger.Field{Key: "error", Value: err.Error()})
+			}
+		}()
+
+		quarantineService = service.NewQuarantineService(&service.QuarantineServiceOpts{
+			Store:    store,
+			RabbitMQ: rmq,
+			Logger:   log,
+		})
+	}
+
+	return rabbitmq.NewEventBus(&rabbitmq.EventBusOpts{
+		RabbitMQ: rmq,
+		Logger:   log,
+	}), checks, quarantineService
+}
+
+// degraded marks err as impairing the service without making it unready.
+func degraded(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %w", service.ErrDegraded, err)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/config"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/pii"
+)
+
+// shred deletes a subject's PII data key, so that the personal data in every
+// event published about them, including archived and dead-lettered copies,
+// can no longer be decrypted. It cannot be undone.
+func main() {
+	subject := flag.String("subject", "", "subject whose data key is deleted, e.g. a user's uuid")
+	flag.Parse()
+
+	log := logger.NewZerologLogger("info", os.Stderr)
+
+	if *subject == "" {
+		log.Fatal("no subject, set --subject")
+	}
+
+	cfg, err := config.NewConfig(log)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	kms, err := pii.NewLocalKMS(&pii.LocalKMSOpts{
+		MasterKey: cfg.PII.MasterKey,
+		Dir:       cfg.PII.KeyDir,
+	})
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	if err := kms.Shred(context.Background(), *subject); err != nil {
+		log.Fatal(err.Error())
+	}
+
+	log.Info("Subject data key deleted", logger.Field{Key: "subject", Value: *subject})
+}
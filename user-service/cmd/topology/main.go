@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/config"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/rabbitmq"
+)
+
+// topology applies the broker topology file, or with --dry-run prints how the
+// live broker differs from it without changing anything.
+func main() {
+	file := flag.String("file", "", "topology file (defaults to AMQP_TOPOLOGY_FILE)")
+	dryRun := flag.Bool("dry-run", false, "print the changes instead of applying them")
+	flag.Parse()
+
+	log := logger.NewZerologLogger("info", os.Stderr)
+
+	cfg, err := config.NewConfig(log)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	path := *file
+	if path == "" {
+		path = cfg.AMQP.TopologyFile
+	}
+	if path == "" {
+		log.Fatal("no topology file, set --file or AMQP_TOPOLOGY_FILE")
+	}
+
+	topology, err := rabbitmq.LoadTopology(path)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	cfg.AMQP, err = rabbitmq.ResolveCredentials(context.Background(), cfg.AMQP)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	conn, err := rabbitmq.Dial(cfg.AMQP, cfg.App)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if *dryRun {
+		changes, err := topology.Diff(ctx, conn, cfg.AMQP)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+
+		for _, change := range changes {
+			fmt.Println(change)
+		}
+		return
+	}
+
+	if err := topology.Apply(conn, cfg.AMQP); err != nil {
+		log.Fatal(err.Error())
+	}
+	if len(topology.Policies) > 0 {
+		if err := topology.ApplyPolicies(ctx, cfg.AMQP); err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+
+	log.Info("Topology applied", logger.Field{Key: "file", Value: path})
+}
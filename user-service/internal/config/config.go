@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofor-little/env"
@@ -19,23 +20,176 @@ type LoaderOptions struct {
 }
 
 type Config struct {
+	App        *App
 	HTTPServer *HTTPServer
+	Tracing    *Tracing
+	EventBus   *EventBus
 	AMQP       *AMQP
+	NATS       *NATS
+	PII        *PII
+}
+
+type App struct {
+	Name    string
+	Version string
+	// Env is "development" or "production".
+	Env string
+}
+
+// Tracing exports spans over OTLP/HTTP to OTLPEndpoint, the base URL of a
+// collector such as http://otel-collector:4318. Empty exports nothing.
+type Tracing struct {
+	OTLPEndpoint string
 }
 
 type HTTPServer struct {
 	URL             string
 	ShutdownTimeout time.Duration
+	// AdminToken is the bearer token of the admin API; empty disables it.
+	AdminToken string
+}
+
+type EventBus struct {
+	Driver          string
+	ShutdownTimeout time.Duration
+	// SchemaValidation is "strict", "log" or "off"; it defaults to strict in
+	// development and log in production.
+	SchemaValidation string
+}
+
+type NATS struct {
+	URL            string
+	PublishTimeout time.Duration
+	AckWait        time.Duration
+	MaxDeliver     int
+}
+
+// PII configures encryption of personal data in event payloads. MasterKey is
+// the base64 encoded 32 byte key the per-subject data keys in KeyDir are
+// wrapped with.
+type PII struct {
+	Enabled   bool
+	MasterKey string
+	KeyDir    string
 }
 
 type AMQP struct {
-	Host                    string
-	Port                    int
-	Username                string
-	Password                string
-	PublishTimeout          time.Duration
-	ConnectionRetryInterval time.Duration
-	ConnectionRetryAttempts int
+	Driver                     string
+	URI                        string
+	Host                       string
+	Port                       int
+	Hosts                      []string
+	NodeSelection              string
+	QueueType                  string
+	Username                   string
+	Password                   string
+	CredentialsDir             string
+	CredentialsPollInterval    time.Duration
+	VHost                      string
+	AuthMechanism              string
+	ConnectionName             string
+	Heartbeat                  time.Duration
+	Locale                     string
+	FrameSize                  int
+	ChannelMax                 int
+	TLS                        *AMQPTLS
+	PublishTimeout             time.Duration
+	ConnectionRetryInterval    time.Duration
+	ConnectionRetryMaxInterval time.Duration
+	ConnectionRetryAttempts    int
+	ChannelPoolSize            int
+	EventFormat                string
+	DefaultCodec               string
+	EventCodecs                map[string]string
+	Compression                string
+	CompressionMinBytes        int
+	ConsumerPrefetch           int
+	RPCQueue                   string
+	RPCTimeout                 time.Duration
+	ManagementURL              string
+	TopologyFile               string
+	Spool                      *AMQPSpool
+	Monitor                    *AMQPMonitor
+	Signing                    *AMQPSigning
+	Quarantine                 *AMQPQuarantine
+	Archive                    *AMQPArchive
+	Delay                      *AMQPDelay
+	// PublishBatchWindow is how long Publish waits to coalesce messages to
+	// the same queue into one batch; 0 publishes each message on its own.
+	PublishBatchWindow  time.Duration
+	PublishBatchMaxSize int
+}
+
+type AMQPTLS struct {
+	Enabled            bool
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+type AMQPArchive struct {
+	Enabled        bool
+	Dir            string
+	MaxFileBytes   int64
+	RotateInterval time.Duration
+	// MaxFiles is how many files are kept; 0 keeps them all.
+	MaxFiles int
+}
+
+type AMQPDelay struct {
+	// Mode is "plugin" to delay messages with the delayed message exchange
+	// plugin, "ttl" to park them in per-delay queues that dead-letter to
+	// their destination, or "auto" to use the plugin when the broker has it.
+	Mode     string
+	Exchange string
+}
+
+type AMQPSigning struct {
+	// KeyID is the key published messages are signed with; empty disables
+	// signing.
+	KeyID string
+	// Keys maps key ids to "hmac-sha256:<secret>", "ed25519:<seed>" or
+	// "ed25519-public:<public key>", base64 encoded.
+	Keys map[string]string
+	// Verify is "off", "reject" or "quarantine".
+	Verify string
+}
+
+type AMQPQuarantine struct {
+	Queue string
+	// MaxAttempts is how many times a failing message is handled before it
+	// is quarantined; 0 rejects it on the first failure instead.
+	MaxAttempts int
+	// RetryDelay is how long a failed message waits before its first retry,
+	// doubling for every further attempt up to MaxRetryDelay.
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+	// DeadLetterQueues are collected into the store alongside the quarantine
+	// queue, for messages other services rejected.
+	DeadLetterQueues []string
+	// StoreDir keeps the quarantined messages served by the admin API; empty
+	// leaves them in the queue. Each replica collects into its own directory
+	// unless it is shared.
+	StoreDir string
+}
+
+type AMQPMonitor struct {
+	Queues        []string
+	Interval      time.Duration
+	MaxMessages   int
+	MinConsumers  int
+	MaxMessageAge time.Duration
+}
+
+type AMQPSpool struct {
+	Enabled       bool
+	Dir           string
+	MaxBytes      int64
+	SegmentBytes  int64
+	FsyncPolicy   string
+	FsyncInterval time.Duration
 }
 
 func NewConfig(log logger.Logger) (*Config, error) {
@@ -70,21 +224,126 @@ func NewConfigWithOptions(opts LoaderOptions) (*Config, error) {
 	}
 
 	cfg := &Config{
+		App: &App{
+			Name:    getEnv("APP_NAME", "user-service"),
+			Version: getEnv("APP_VERSION", "1.0"),
+			Env:     getEnv("APP_ENV", "development"),
+		},
 		HTTPServer: &HTTPServer{
 			URL:             getEnv("HTTP_SERVER_URL", ":4000"),
 			ShutdownTimeout: getEnvDuration("HTTP_SHUTDOWN_TIMEOUT", 5*time.Second),
+			AdminToken:      getEnv("ADMIN_API_TOKEN", ""),
+		},
+		Tracing: &Tracing{
+			OTLPEndpoint: getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", ""),
+		},
+		EventBus: &EventBus{
+			Driver:          getEnv("EVENT_BUS_DRIVER", "rabbitmq"),
+			ShutdownTimeout: getEnvDuration("EVENT_BUS_SHUTDOWN_TIMEOUT", 10*time.Second),
+		},
+		NATS: &NATS{
+			URL:            getEnv("NATS_URL", "nats://nats:4222"),
+			PublishTimeout: getEnvDuration("NATS_PUBLISH_TIMEOUT", 5*time.Second),
+			AckWait:        getEnvDuration("NATS_ACK_WAIT", 30*time.Second),
+			MaxDeliver:     getEnvInt("NATS_MAX_DELIVER", 5),
+		},
+		PII: &PII{
+			Enabled:   getEnvBool("PII_ENCRYPTION_ENABLED", false),
+			MasterKey: getEnv("PII_MASTER_KEY", ""),
+			KeyDir:    getEnv("PII_KEY_DIR", "/tmp/user-service/pii-keys"),
 		},
 		AMQP: &AMQP{
-			Host:                    getEnv("AMQP_HOST", "rabbitmq"),
-			Port:                    getEnvInt("AMQP_PORT", 5672),
-			Username:                getEnv("AMQP_USERNAME", "default"),
-			Password:                getEnv("AMQP_PASSWORD", "default"),
-			PublishTimeout:          getEnvDuration("AMQP_PUBLISH_TIMEOUT_SECONDS", time.Second*5),
-			ConnectionRetryInterval: getEnvDuration("AMQP_CONNECTION_RETRY_INTERVAL_SECONDS", time.Second*5),
-			ConnectionRetryAttempts: getEnvInt("AMQP_CONNECTION_RETRY_ATTEMPTS", 10),
+			Driver:         getEnv("AMQP_DRIVER", "amqp"),
+			Host:           getEnv("AMQP_HOST", "rabbitmq"),
+			Port:           getEnvInt("AMQP_PORT", 5672),
+			Hosts:          getEnvList("AMQP_HOSTS"),
+			NodeSelection:  getEnv("AMQP_NODE_SELECTION", "round-robin"),
+			QueueType:      getEnv("AMQP_QUEUE_TYPE", "classic"),
+			Username:       getEnv("AMQP_USERNAME", "default"),
+			Password:       getEnv("AMQP_PASSWORD", "default"),
+			CredentialsDir: getEnv("AMQP_CREDENTIALS_DIR", ""),
+			URI:            getEnv("AMQP_URI", ""),
+			VHost:          getEnv("AMQP_VHOST", "/"),
+			AuthMechanism:  getEnv("AMQP_AUTH_MECHANISM", "plain"),
+			ConnectionName: getEnv("AMQP_CONNECTION_NAME", ""),
+			Heartbeat:      getEnvDuration("AMQP_HEARTBEAT", 10*time.Second),
+			Locale:         getEnv("AMQP_LOCALE", "en_US"),
+			FrameSize:      getEnvInt("AMQP_FRAME_SIZE", 0),
+			ChannelMax:     getEnvInt("AMQP_CHANNEL_MAX", 0),
+			TLS: &AMQPTLS{
+				Enabled:            getEnvBool("AMQP_TLS_ENABLED", false),
+				CAFile:             getEnv("AMQP_TLS_CA_FILE", ""),
+				CertFile:           getEnv("AMQP_TLS_CERT_FILE", ""),
+				KeyFile:            getEnv("AMQP_TLS_KEY_FILE", ""),
+				ServerName:         getEnv("AMQP_TLS_SERVER_NAME", ""),
+				InsecureSkipVerify: getEnvBool("AMQP_TLS_INSECURE_SKIP_VERIFY", false),
+			},
+			CredentialsPollInterval:    getEnvDuration("AMQP_CREDENTIALS_POLL_INTERVAL", 10*time.Second),
+			PublishTimeout:             getEnvDuration("AMQP_PUBLISH_TIMEOUT_SECONDS", time.Second*5),
+			PublishBatchWindow:         getEnvDuration("AMQP_PUBLISH_BATCH_WINDOW", 0),
+			PublishBatchMaxSize:        getEnvInt("AMQP_PUBLISH_BATCH_MAX_SIZE", 100),
+			ConnectionRetryInterval:    getEnvDuration("AMQP_CONNECTION_RETRY_INTERVAL_SECONDS", time.Second*5),
+			ConnectionRetryMaxInterval: getEnvDuration("AMQP_CONNECTION_RETRY_MAX_INTERVAL", time.Minute),
+			ConnectionRetryAttempts:    getEnvInt("AMQP_CONNECTION_RETRY_ATTEMPTS", 10),
+			ChannelPoolSize:            getEnvInt("AMQP_CHANNEL_POOL_SIZE", 8),
+			EventFormat:                getEnv("AMQP_EVENT_FORMAT", "nest"),
+			DefaultCodec:               getEnv("AMQP_DEFAULT_CODEC", "json"),
+			EventCodecs:                getEnvMap("AMQP_EVENT_CODECS"),
+			Compression:                getEnv("AMQP_COMPRESSION", ""),
+			CompressionMinBytes:        getEnvInt("AMQP_COMPRESSION_MIN_BYTES", 1024),
+			ConsumerPrefetch:           getEnvInt("AMQP_CONSUMER_PREFETCH", 10),
+			RPCQueue:                   getEnv("AMQP_RPC_QUEUE", "user-service"),
+			RPCTimeout:                 getEnvDuration("AMQP_RPC_TIMEOUT", 10*time.Second),
+			ManagementURL:              getEnv("AMQP_MANAGEMENT_URL", ""),
+			TopologyFile:               getEnv("AMQP_TOPOLOGY_FILE", ""),
+			Spool: &AMQPSpool{
+				Enabled:       getEnvBool("AMQP_SPOOL_ENABLED", false),
+				Dir:           getEnv("AMQP_SPOOL_DIR", "/tmp/user-service/spool"),
+				MaxBytes:      getEnvInt64("AMQP_SPOOL_MAX_BYTES", 64<<20),
+				SegmentBytes:  getEnvInt64("AMQP_SPOOL_SEGMENT_BYTES", 4<<20),
+				FsyncPolicy:   getEnv("AMQP_SPOOL_FSYNC", "interval"),
+				FsyncInterval: getEnvDuration("AMQP_SPOOL_FSYNC_INTERVAL", time.Second),
+			},
+			Monitor: &AMQPMonitor{
+				Queues:        getEnvList("AMQP_MONITOR_QUEUES"),
+				Interval:      getEnvDuration("AMQP_MONITOR_INTERVAL", 15*time.Second),
+				MaxMessages:   getEnvInt("AMQP_MONITOR_MAX_MESSAGES", 1000),
+				MinConsumers:  getEnvInt("AMQP_MONITOR_MIN_CONSUMERS", 1),
+				MaxMessageAge: getEnvDuration("AMQP_MONITOR_MAX_MESSAGE_AGE", 5*time.Minute),
+			},
+			Signing: &AMQPSigning{
+				KeyID:  getEnv("AMQP_SIGNING_KEY_ID", ""),
+				Keys:   getEnvMap("AMQP_SIGNING_KEYS"),
+				Verify: getEnv("AMQP_SIGNATURE_VERIFY", "off"),
+			},
+			Quarantine: &AMQPQuarantine{
+				Queue:            getEnv("AMQP_QUARANTINE_QUEUE", "user-service.quarantine"),
+				MaxAttempts:      getEnvInt("AMQP_MAX_DELIVERY_ATTEMPTS", 0),
+				RetryDelay:       getEnvDuration("AMQP_RETRY_DELAY", time.Second),
+				MaxRetryDelay:    getEnvDuration("AMQP_MAX_RETRY_DELAY", 5*time.Minute),
+				DeadLetterQueues: getEnvList("AMQP_QUARANTINE_DEAD_LETTER_QUEUES"),
+				StoreDir:         getEnv("AMQP_QUARANTINE_STORE_DIR", ""),
+			},
+			Archive: &AMQPArchive{
+				Enabled:        getEnvBool("AMQP_ARCHIVE_ENABLED", false),
+				Dir:            getEnv("AMQP_ARCHIVE_DIR", "/tmp/user-service/archive"),
+				MaxFileBytes:   getEnvInt64("AMQP_ARCHIVE_MAX_FILE_BYTES", 64<<20),
+				RotateInterval: getEnvDuration("AMQP_ARCHIVE_ROTATE_INTERVAL", 24*time.Hour),
+				MaxFiles:       getEnvInt("AMQP_ARCHIVE_MAX_FILES", 30),
+			},
+			Delay: &AMQPDelay{
+				Mode:     getEnv("AMQP_DELAY_MODE", "auto"),
+				Exchange: getEnv("AMQP_DELAY_EXCHANGE", "user-service.delayed"),
+			},
 		},
 	}
 
+	schemaValidation := "log"
+	if cfg.App.Env == "development" {
+		schemaValidation = "strict"
+	}
+	cfg.EventBus.SchemaValidation = getEnv("EVENT_SCHEMA_VALIDATION", schemaValidation)
+
 	return cfg, nil
 }
 
@@ -111,6 +370,48 @@ func getEnvInt(key string, defaultVal int) int {
 	return defaultVal
 }
 
+func getEnvInt64(key string, defaultVal int64) int64 {
+	if val, err := strconv.ParseInt(os.Getenv(key), 10, 64); err == nil {
+		return val
+	}
+
+	return defaultVal
+}
+
+func getEnvBool(key string, defaultVal bool) bool {
+	if val, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return val
+	}
+
+	return defaultVal
+}
+
+// getEnvMap parses a comma separated list of key=value pairs.
+func getEnvMap(key string) map[string]string {
+	m := map[string]string{}
+
+	for _, pair := range strings.Split(os.Getenv(key), ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && k != "" {
+			m[k] = v
+		}
+	}
+
+	return m
+}
+
+func getEnvList(key string) []string {
+	var list []string
+
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
 func getEnvDuration(key string, defaultVal time.Duration) time.Duration {
 	if val, err := time.ParseDuration(os.Getenv(key)); err == nil {
 		return val
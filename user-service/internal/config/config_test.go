@@ -0,0 +1,60 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+)
+
+func loadTestConfig(t *testing.T) *Config {
+	t.Helper()
+
+	cfg, err := NewConfigWithOptions(LoaderOptions{
+		EnvLoader: func(string) error { return errors.New("no .env file") },
+		Logger:    logger.NewZerologLogger("error", nil),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cfg
+}
+
+func TestAMQPClusterSettings(t *testing.T) {
+	t.Setenv("AMQP_HOSTS", "rabbitmq-0:5672, rabbitmq-1:5672,,rabbitmq-2:5672")
+	t.Setenv("AMQP_NODE_SELECTION", "priority")
+	t.Setenv("AMQP_QUEUE_TYPE", "quorum")
+
+	cfg := loadTestConfig(t)
+
+	want := []string{"rabbitmq-0:5672", "rabbitmq-1:5672", "rabbitmq-2:5672"}
+	if !reflect.DeepEqual(cfg.AMQP.Hosts, want) {
+		t.Errorf("hosts = %q, want %q", cfg.AMQP.Hosts, want)
+	}
+	if cfg.AMQP.NodeSelection != "priority" {
+		t.Errorf("node selection = %q, want priority", cfg.AMQP.NodeSelection)
+	}
+	if cfg.AMQP.QueueType != "quorum" {
+		t.Errorf("queue type = %q, want quorum", cfg.AMQP.QueueType)
+	}
+}
+
+func TestAMQPClusterDefaults(t *testing.T) {
+	for _, key := range []string{"AMQP_HOSTS", "AMQP_NODE_SELECTION", "AMQP_QUEUE_TYPE"} {
+		t.Setenv(key, "")
+	}
+
+	cfg := loadTestConfig(t)
+
+	if len(cfg.AMQP.Hosts) != 0 {
+		t.Errorf("hosts = %q, want none", cfg.AMQP.Hosts)
+	}
+	if cfg.AMQP.NodeSelection != "round-robin" {
+		t.Errorf("node selection = %q, want round-robin", cfg.AMQP.NodeSelection)
+	}
+	if cfg.AMQP.QueueType != "classic" {
+		t.Errorf("queue type = %q, want classic", cfg.AMQP.QueueType)
+	}
+}
@@ -3,7 +3,3 @@ package constant
 var (
 	QUEUE_NOTIFICATION_SERVICE = "notification-service"
 )
-
-var (
-	EVENT_USER_CREATED = "user.created"
-)
@@ -0,0 +1,54 @@
+package eventbus
+
+import "context"
+
+// BatchPublisher is implemented by buses that can publish several events in
+// one round trip.
+type BatchPublisher interface {
+	// PublishBatch returns the error of each event, nil for those published.
+	PublishBatch(ctx context.Context, topic string, events []*Event) []error
+}
+
+// PublishBatch publishes events to topic, as one batch when bus supports it
+// and one by one otherwise, and returns the error of each event.
+func PublishBatch(ctx context.Context, bus EventBus, topic string, events []*Event) []error {
+	if b, ok := bus.(BatchPublisher); ok {
+		return b.PublishBatch(ctx, topic, events)
+	}
+
+	errs := make([]error, len(events))
+	for i, event := range events {
+		errs[i] = bus.Publish(ctx, topic, event)
+	}
+
+	return errs
+}
+
+// PublishPrepared runs prepare on every event and publishes the events it
+// returns as one batch to bus. An event prepare fails on is not published and
+// gets its error. It lets bus decorators pass batches through.
+func PublishPrepared(ctx context.Context, bus EventBus, topic string, events []*Event, prepare func(event *Event) (*Event, error)) []error {
+	errs := make([]error, len(events))
+
+	prepared := make([]*Event, 0, len(events))
+	index := make([]int, 0, len(events))
+	for i, event := range events {
+		p, err := prepare(event)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		prepared = append(prepared, p)
+		index = append(index, i)
+	}
+
+	if len(prepared) == 0 {
+		return errs
+	}
+
+	for j, err := range PublishBatch(ctx, bus, topic, prepared) {
+		errs[index[j]] = err
+	}
+
+	return errs
+}
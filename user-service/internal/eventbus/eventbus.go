@@ -0,0 +1,75 @@
+package eventbus
+
+import (
+	"context"
+	"time"
+)
+
+// EventBus is the transport-agnostic view of the message broker used by the
+// services. Topics are point-to-point destinations: a queue on RabbitMQ, a
+// subject on NATS.
+type EventBus interface {
+	Health() error
+	Publish(ctx context.Context, topic string, event *Event) error
+	Subscribe(ctx context.Context, topic string, handler Handler) error
+	// Close stops accepting publishes and waits, up to ctx's deadline, for
+	// pending ones to reach the broker before disconnecting.
+	Close(ctx context.Context) error
+}
+
+type Event struct {
+	Pattern       string
+	Data          any
+	Subject       string
+	SchemaVersion int
+
+	// TTL, Priority, Headers and Delay are set by PublishOptions. A bus that
+	// cannot honour one returns ErrOptionUnsupported.
+	TTL      time.Duration
+	Priority uint8
+	Headers  map[string]any
+	Delay    time.Duration
+}
+
+// Message is a received event. Handlers usually just return: nil acknowledges
+// the message and an error rejects it. A handler may settle the message itself
+// instead, for example to request redelivery with Nack(true), in which case
+// the bus leaves it alone.
+type Message interface {
+	ID() string
+	Pattern() string
+	Decode(v any) error
+	Ack() error
+	Nack(requeue bool) error
+}
+
+type Handler func(ctx context.Context, msg Message) error
+
+type contextKey int
+
+const (
+	correlationIDKey contextKey = iota
+	causationIDKey
+)
+
+// WithCorrelationID stores the id shared by every message caused by the same
+// originating request.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey, id)
+}
+
+func CorrelationIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDKey).(string)
+	return id
+}
+
+// WithCausationID stores the id of the message or request that directly caused
+// the messages published with ctx.
+func WithCausationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, causationIDKey, id)
+}
+
+func CausationIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(causationIDKey).(string)
+	return id
+}
@@ -0,0 +1,55 @@
+package eventbus
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrOptionUnsupported = errors.New("publish option is not supported by the event bus")
+
+// PublishOption sets a delivery option of an event.
+type PublishOption func(*Event)
+
+// WithTTL drops the event if it has not been consumed within ttl.
+func WithTTL(ttl time.Duration) PublishOption {
+	return func(e *Event) {
+		e.TTL = ttl
+	}
+}
+
+// WithPriority delivers the event ahead of lower priority ones, on topics that
+// support priorities.
+func WithPriority(priority uint8) PublishOption {
+	return func(e *Event) {
+		e.Priority = priority
+	}
+}
+
+// WithHeaders adds transport headers to the event.
+func WithHeaders(headers map[string]any) PublishOption {
+	return func(e *Event) {
+		if e.Headers == nil {
+			e.Headers = map[string]any{}
+		}
+		for k, v := range headers {
+			e.Headers[k] = v
+		}
+	}
+}
+
+// WithDelay delivers the event once delay has passed, such as a reminder some
+// time after the action it follows up on.
+func WithDelay(delay time.Duration) PublishOption {
+	return func(e *Event) {
+		e.Delay = delay
+	}
+}
+
+// Apply sets opts on e and returns it.
+func (e *Event) Apply(opts ...PublishOption) *Event {
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	return e
+}
@@ -0,0 +1,180 @@
+package eventcatalog
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/goccy/go-yaml"
+)
+
+type ms = yaml.MapSlice
+
+type mi = yaml.MapItem
+
+// AsyncAPI renders the AsyncAPI document of the catalog, in version 2.6 or
+// 3.0 of the specification. Each queue is a channel; messages are documented
+// in the NestJS envelope they travel in.
+func (c *Catalog) AsyncAPI(specVersion int) ([]byte, error) {
+	components, err := c.asyncAPIComponents()
+	if err != nil {
+		return nil, err
+	}
+
+	info := ms{
+		{Key: "title", Value: c.Info.Title},
+		{Key: "version", Value: c.Info.Version},
+		{Key: "description", Value: c.Info.Description},
+	}
+
+	var doc ms
+	switch specVersion {
+	case 2:
+		doc = ms{
+			{Key: "asyncapi", Value: "2.6.0"},
+			{Key: "info", Value: info},
+			{Key: "defaultContentType", Value: "application/json"},
+			{Key: "channels", Value: c.asyncAPI2Channels()},
+			{Key: "components", Value: components},
+		}
+	case 3:
+		channels, operations := c.asyncAPI3Channels()
+		doc = ms{
+			{Key: "asyncapi", Value: "3.0.0"},
+			{Key: "info", Value: info},
+			{Key: "defaultContentType", Value: "application/json"},
+			{Key: "channels", Value: channels},
+			{Key: "operations", Value: operations},
+			{Key: "components", Value: components},
+		}
+	default:
+		return nil, fmt.Errorf("unsupported AsyncAPI version %d", specVersion)
+	}
+
+	raw, err := yaml.MarshalWithOptions(doc, yaml.Indent(2), yaml.IndentSequence(true))
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte("# "+generatedHeader+"\n"), raw...), nil
+}
+
+// queues returns the queues events are published to, in catalog order, with
+// their events.
+func (c *Catalog) queues() ([]string, map[string][]Event) {
+	var order []string
+	events := map[string][]Event{}
+	for _, e := range c.Events {
+		if _, ok := events[e.Queue]; !ok {
+			order = append(order, e.Queue)
+		}
+		events[e.Queue] = append(events[e.Queue], e)
+	}
+
+	return order, events
+}
+
+func amqpQueueBinding(queue, bindingVersion string) ms {
+	return ms{{Key: "amqp", Value: ms{
+		{Key: "is", Value: "queue"},
+		{Key: "queue", Value: ms{{Key: "name", Value: queue}}},
+		{Key: "bindingVersion", Value: bindingVersion},
+	}}}
+}
+
+func (c *Catalog) asyncAPI2Channels() ms {
+	queues, events := c.queues()
+
+	var channels ms
+	for _, queue := range queues {
+		var messages []any
+		for _, e := range events[queue] {
+			messages = append(messages, ms{{Key: "$ref", Value: "#/components/messages/" + e.Name}})
+		}
+
+		// In AsyncAPI 2, subscribe describes what the application sends.
+		channels = append(channels, mi{Key: queue, Value: ms{
+			{Key: "subscribe", Value: ms{
+				{Key: "operationId", Value: "publish-" + queue},
+				{Key: "message", Value: ms{{Key: "oneOf", Value: messages}}},
+			}},
+			{Key: "bindings", Value: amqpQueueBinding(queue, "0.2.0")},
+		}})
+	}
+
+	return channels
+}
+
+func (c *Catalog) asyncAPI3Channels() (ms, ms) {
+	queues, events := c.queues()
+
+	var channels, operations ms
+	for _, queue := range queues {
+		var messages ms
+		for _, e := range events[queue] {
+			messages = append(messages, mi{Key: e.Name, Value: ms{{Key: "$ref", Value: "#/components/messages/" + e.Name}}})
+
+			operations = append(operations, mi{Key: "publish" + e.Name, Value: ms{
+				{Key: "action", Value: "send"},
+				{Key: "channel", Value: ms{{Key: "$ref", Value: "#/channels/" + queue}}},
+				{Key: "summary", Value: e.Description},
+				{Key: "messages", Value: []any{ms{{Key: "$ref", Value: "#/channels/" + queue + "/messages/" + e.Name}}}},
+			}})
+		}
+
+		channels = append(channels, mi{Key: queue, Value: ms{
+			{Key: "address", Value: queue},
+			{Key: "messages", Value: messages},
+			{Key: "bindings", Value: amqpQueueBinding(queue, "0.3.0")},
+		}})
+	}
+
+	return channels, operations
+}
+
+func (c *Catalog) asyncAPIComponents() (ms, error) {
+	var messages, schemas ms
+
+	for _, e := range c.Events {
+		payload, err := toYAML(e.payloadSchema())
+		if err != nil {
+			return nil, fmt.Errorf("schema %s: %w", e.Pattern, err)
+		}
+		schemas = append(schemas, mi{Key: e.Name, Value: payload})
+
+		messages = append(messages, mi{Key: e.Name, Value: ms{
+			{Key: "name", Value: e.Pattern},
+			{Key: "title", Value: e.Name},
+			{Key: "summary", Value: e.Description},
+			{Key: "contentType", Value: "application/json"},
+			{Key: "payload", Value: ms{
+				{Key: "type", Value: "object"},
+				{Key: "properties", Value: ms{
+					{Key: "pattern", Value: ms{{Key: "type", Value: "string"}, {Key: "const", Value: e.Pattern}}},
+					{Key: "data", Value: ms{{Key: "$ref", Value: "#/components/schemas/" + e.Name}}},
+				}},
+				{Key: "required", Value: []string{"pattern", "data"}},
+			}},
+		}})
+	}
+
+	return ms{
+		{Key: "messages", Value: messages},
+		{Key: "schemas", Value: schemas},
+	}, nil
+}
+
+// toYAML converts v to an ordered YAML value through its JSON encoding, so
+// schemas keep the key order of the generated JSON files.
+func toYAML(v any) (any, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var out any
+	if err := yaml.UnmarshalWithOptions(raw, &out, yaml.UseOrderedMap()); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
@@ -0,0 +1,162 @@
+// Package eventcatalog generates the code and documentation describing the
+// events user-service publishes from a single catalog file.
+package eventcatalog
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+)
+
+var ErrInvalidCatalog = errors.New("event catalog is invalid")
+
+type Catalog struct {
+	Info   Info    `yaml:"info"`
+	Events []Event `yaml:"events"`
+}
+
+type Info struct {
+	Title       string `yaml:"title"`
+	Version     string `yaml:"version"`
+	Description string `yaml:"description"`
+}
+
+// Event is one published event. Name is the Go and TypeScript type of the
+// payload, Subject the payload field identifying the entity the event is
+// about.
+type Event struct {
+	Name        string  `yaml:"name"`
+	Pattern     string  `yaml:"pattern"`
+	Version     int     `yaml:"version"`
+	Queue       string  `yaml:"queue"`
+	Subject     string  `yaml:"subject"`
+	Description string  `yaml:"description"`
+	Payload     []Field `yaml:"payload"`
+	// Forbidden lists properties the payload must never contain, such as
+	// secrets of the model the event is built from.
+	Forbidden []string `yaml:"forbidden"`
+}
+
+// Field is a payload property. Type is a JSON Schema type; the remaining
+// constraints are copied into the schema as is. PII fields are encrypted with
+// the subject's data key when PII encryption is enabled.
+type Field struct {
+	Name        string   `yaml:"name"`
+	Type        string   `yaml:"type"`
+	Format      string   `yaml:"format"`
+	Description string   `yaml:"description"`
+	Required    bool     `yaml:"required"`
+	PII         bool     `yaml:"pii"`
+	Enum        []string `yaml:"enum"`
+	Minimum     *float64 `yaml:"minimum"`
+	Maximum     *float64 `yaml:"maximum"`
+	MinLength   *int     `yaml:"minLength"`
+	MaxLength   *int     `yaml:"maxLength"`
+}
+
+var (
+	typeName  = regexp.MustCompile(`^[A-Z][A-Za-z0-9]*$`)
+	fieldName = regexp.MustCompile(`^[a-z][A-Za-z0-9_]*$`)
+)
+
+var fieldTypes = []string{"string", "integer", "number", "boolean"}
+
+func Load(path string) (*Catalog, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var c Catalog
+	if err := yaml.UnmarshalWithOptions(raw, &c, yaml.Strict()); err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrInvalidCatalog, path, err)
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrInvalidCatalog, path, err)
+	}
+
+	return &c, nil
+}
+
+func (c *Catalog) validate() error {
+	names := map[string]bool{}
+	patterns := map[string]bool{}
+
+	for _, e := range c.Events {
+		switch {
+		case !typeName.MatchString(e.Name):
+			return fmt.Errorf("event name %q must be an exported Go identifier", e.Name)
+		case names[e.Name]:
+			return fmt.Errorf("event name %q is declared twice", e.Name)
+		case e.Pattern == "" || strings.ContainsAny(e.Pattern, "/ "):
+			return fmt.Errorf("event %s: pattern %q is invalid", e.Name, e.Pattern)
+		case patterns[e.Pattern]:
+			return fmt.Errorf("event pattern %q is declared twice", e.Pattern)
+		case e.Version < 1:
+			return fmt.Errorf("event %s: version must be at least 1", e.Name)
+		case e.Queue == "":
+			return fmt.Errorf("event %s: queue is required", e.Name)
+		}
+		names[e.Name] = true
+		patterns[e.Pattern] = true
+
+		fields := map[string]bool{}
+		for _, f := range e.Payload {
+			switch {
+			case !fieldName.MatchString(f.Name):
+				return fmt.Errorf("event %s: field name %q is invalid", e.Name, f.Name)
+			case fields[f.Name]:
+				return fmt.Errorf("event %s: field %q is declared twice", e.Name, f.Name)
+			case !slices.Contains(fieldTypes, f.Type):
+				return fmt.Errorf("event %s: field %s has type %q, want one of %v", e.Name, f.Name, f.Type, fieldTypes)
+			case f.PII && f.Type != "string":
+				return fmt.Errorf("event %s: pii field %s must be a string", e.Name, f.Name)
+			case f.PII && e.Subject == "":
+				return fmt.Errorf("event %s: pii field %s needs the event to have a subject", e.Name, f.Name)
+			}
+			fields[f.Name] = true
+		}
+
+		if e.Subject != "" && !fields[e.Subject] {
+			return fmt.Errorf("event %s: subject %q is not a payload field", e.Name, e.Subject)
+		}
+		for _, name := range e.Forbidden {
+			if fields[name] {
+				return fmt.Errorf("event %s: field %q is both declared and forbidden", e.Name, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// constantName is the name of the pattern constant in the constant package,
+// user.created becoming EVENT_USER_CREATED.
+func (e *Event) constantName() string {
+	return "EVENT_" + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(e.Pattern))
+}
+
+// goName turns a payload field name into an exported Go identifier, keeping
+// common initialisms upper case.
+func (f *Field) goName() string {
+	var b strings.Builder
+	for _, part := range strings.Split(f.Name, "_") {
+		if part == "" {
+			continue
+		}
+		switch upper := strings.ToUpper(part); upper {
+		case "ID", "URL", "URI", "IP", "HTTP", "JSON", "UUID":
+			b.WriteString(upper)
+		default:
+			b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,150 @@
+package eventcatalog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testModule = "github.com/sagarmaheshwary/kind-microservices-demo/user-service"
+
+func loadTestCatalog(t *testing.T, events string) (*Catalog, error) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "events.yaml")
+	if err := os.WriteFile(path, []byte("info:\n  title: test\n  version: 1.0.0\nevents:\n"+events), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return Load(path)
+}
+
+const orderCreated = `
+  - name: OrderCreated
+    pattern: order.created
+    version: 1
+    queue: billing
+    subject: uuid
+    payload:
+      - name: uuid
+        type: string
+        required: true
+      - name: email
+        type: string
+        pii: true
+      - name: total
+        type: number
+        minimum: 0
+    forbidden:
+      - card
+`
+
+func TestLoadRejectsInvalidCatalogs(t *testing.T) {
+	invalid := map[string]string{
+		"unexported name":     strings.Replace(orderCreated, "name: OrderCreated", "name: orderCreated", 1),
+		"duplicate name":      orderCreated + strings.Replace(orderCreated, "order.created", "order.placed", 1),
+		"duplicate pattern":   orderCreated + strings.Replace(orderCreated, "OrderCreated", "OrderPlaced", 1),
+		"pattern with space":  strings.Replace(orderCreated, "order.created", "order created", 1),
+		"version zero":        strings.Replace(orderCreated, "version: 1", "version: 0", 1),
+		"no queue":            strings.Replace(orderCreated, "queue: billing\n", "", 1),
+		"unknown type":        strings.Replace(orderCreated, "type: number", "type: decimal", 1),
+		"pii without string":  strings.Replace(orderCreated, "type: string\n        pii: true", "type: integer\n        pii: true", 1),
+		"pii without subject": strings.Replace(orderCreated, "subject: uuid\n", "", 1),
+		"unknown subject":     strings.Replace(orderCreated, "subject: uuid", "subject: id", 1),
+		"forbidden declared":  strings.Replace(orderCreated, "- card", "- total", 1),
+		"unknown key":         strings.Replace(orderCreated, "queue: billing", "queue: billing\n    exchange: orders", 1),
+	}
+
+	for name, events := range invalid {
+		t.Run(name, func(t *testing.T) {
+			if _, err := loadTestCatalog(t, events); !errors.Is(err, ErrInvalidCatalog) {
+				t.Errorf("got error %v, want %v", err, ErrInvalidCatalog)
+			}
+		})
+	}
+}
+
+func TestSchema(t *testing.T) {
+	c, err := loadTestCatalog(t, orderCreated)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if path := c.Events[0].SchemaPath(); path != "order.created/v1.json" {
+		t.Errorf("schema path %q", path)
+	}
+
+	raw, err := c.Events[0].Schema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var schema struct {
+		Title      string                    `json:"title"`
+		Properties map[string]map[string]any `json:"properties"`
+		Required   []string                  `json:"required"`
+		Not        struct {
+			Required []string `json:"required"`
+		} `json:"not"`
+	}
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	if schema.Title != "order.created" {
+		t.Errorf("title %q", schema.Title)
+	}
+	if strings.Join(schema.Required, ",") != "uuid" {
+		t.Errorf("required %v, want only uuid", schema.Required)
+	}
+	if strings.Join(schema.Not.Required, ",") != "card" {
+		t.Errorf("forbidden %v, want card", schema.Not.Required)
+	}
+	if schema.Properties["email"]["x-pii"] != true {
+		t.Errorf("email %v is not marked as pii", schema.Properties["email"])
+	}
+	if schema.Properties["total"]["minimum"] != float64(0) {
+		t.Errorf("total %v lost its minimum", schema.Properties["total"])
+	}
+}
+
+// Generated files are committed, so a catalog change without go generate
+// would ship stale publishers, schemas and docs.
+func TestGeneratedFilesAreUpToDate(t *testing.T) {
+	root := filepath.Join("..", "..")
+
+	c, err := Load(filepath.Join(root, "events.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]func() ([]byte, error){
+		"internal/events/events_gen.go":                          func() ([]byte, error) { return c.Publishers(testModule) },
+		"internal/constant/events_gen.go":                        c.Constants,
+		"docs/asyncapi-2.yaml":                                   func() ([]byte, error) { return c.AsyncAPI(2) },
+		"docs/asyncapi-3.yaml":                                   func() ([]byte, error) { return c.AsyncAPI(3) },
+		"../notification-service/src/events/events.generated.ts": c.TypeScript,
+	}
+	for _, e := range c.Events {
+		files[filepath.Join("internal/eventschema/schemas", e.SchemaPath())] = e.Schema
+	}
+
+	for name, generate := range files {
+		want, err := generate()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		got, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s is out of date, run go generate ./internal/events", name)
+		}
+	}
+}
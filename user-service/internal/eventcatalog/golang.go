@@ -0,0 +1,153 @@
+package eventcatalog
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"text/template"
+)
+
+const generatedHeader = "Code generated by cmd/eventgen from events.yaml. DO NOT EDIT."
+
+var goTypes = map[string]string{
+	"string":  "string",
+	"integer": "int",
+	"number":  "float64",
+	"boolean": "bool",
+}
+
+var publishersTemplate = template.Must(template.New("events").Funcs(template.FuncMap{
+	"goType": func(f Field) string { return goTypes[f.Type] },
+	"goName": func(f Field) string { return f.goName() },
+	"tags": func(f Field) string {
+		json := f.Name
+		if !f.Required {
+			json += ",omitempty"
+		}
+		if f.PII {
+			return fmt.Sprintf("`json:%q pii:\"true\"`", json)
+		}
+		return fmt.Sprintf("`json:%q`", json)
+	},
+	"constant": func(e Event) string { return e.constantName() },
+	"subject": func(e Event) string {
+		for _, f := range e.Payload {
+			if f.Name == e.Subject {
+				return f.goName()
+			}
+		}
+		return ""
+	},
+	"hasSubject": func(c *Catalog) bool {
+		for _, e := range c.Events {
+			if e.Subject != "" {
+				return true
+			}
+		}
+		return false
+	},
+}).Parse(`// {{ .Header }}
+
+package events
+
+import (
+	"context"
+	{{- if hasSubject .Catalog }}
+	"fmt"
+	{{- end }}
+
+	"{{ .Module }}/internal/constant"
+	"{{ .Module }}/internal/eventbus"
+)
+{{ range .Catalog.Events }}
+// {{ .Name }} is the payload of {{ .Pattern }} v{{ .Version }}.{{ with .Description }} {{ . }}{{ end }}
+type {{ .Name }} struct {
+	{{- range .Payload }}
+	{{ goName . }} {{ goType . }} {{ tags . }}
+	{{- end }}
+}
+
+// Publish{{ .Name }} publishes {{ .Pattern }} v{{ .Version }} to the {{ .Queue }} queue.
+func (p *Publisher) Publish{{ .Name }}(ctx context.Context, payload {{ .Name }}, opts ...eventbus.PublishOption) error {
+	return p.bus.Publish(ctx, {{ printf "%q" .Queue }}, new{{ .Name }}Event(payload).Apply(opts...))
+}
+
+// Publish{{ .Name }}Batch publishes {{ .Pattern }} v{{ .Version }} for every payload as one batch to the {{ .Queue }} queue, and returns the error of each.
+func (p *Publisher) Publish{{ .Name }}Batch(ctx context.Context, payloads []{{ .Name }}, opts ...eventbus.PublishOption) []error {
+	events := make([]*eventbus.Event, len(payloads))
+	for i, payload := range payloads {
+		events[i] = new{{ .Name }}Event(payload).Apply(opts...)
+	}
+
+	return eventbus.PublishBatch(ctx, p.bus, {{ printf "%q" .Queue }}, events)
+}
+
+func new{{ .Name }}Event(payload {{ .Name }}) *eventbus.Event {
+	return &eventbus.Event{
+		Pattern:       constant.{{ constant . }},
+		Data:          payload,
+		{{- if .Subject }}
+		Subject:       fmt.Sprint(payload.{{ subject . }}),
+		{{- end }}
+		SchemaVersion: constant.{{ constant . }}_SCHEMA_VERSION,
+	}
+}
+{{ end }}`))
+
+var constantsTemplate = template.Must(template.New("constant").Funcs(template.FuncMap{
+	"constant": func(e Event) string { return e.constantName() },
+}).Parse(`// {{ .Header }}
+
+package constant
+
+var (
+	{{- range .Catalog.Events }}
+	{{ constant . }} = {{ printf "%q" .Pattern }}
+	{{- end }}
+)
+
+var (
+	{{- range .Catalog.Events }}
+	{{ constant . }}_SCHEMA_VERSION = {{ .Version }}
+	{{- end }}
+)
+
+// EVENT_SCHEMA_VERSIONS lists every published event with the schema version
+// it is published at.
+var EVENT_SCHEMA_VERSIONS = map[string]int{
+	{{- range .Catalog.Events }}
+	{{ constant . }}: {{ constant . }}_SCHEMA_VERSION,
+	{{- end }}
+}
+`))
+
+// Publishers renders the typed publish helpers of the events package. module
+// is the Go module path of user-service.
+func (c *Catalog) Publishers(module string) ([]byte, error) {
+	return renderGo(publishersTemplate, c, module)
+}
+
+// Constants renders the pattern and schema version constants of the constant
+// package.
+func (c *Catalog) Constants() ([]byte, error) {
+	return renderGo(constantsTemplate, c, "")
+}
+
+func renderGo(t *template.Template, c *Catalog, module string) ([]byte, error) {
+	var b bytes.Buffer
+	err := t.Execute(&b, map[string]any{
+		"Header":  generatedHeader,
+		"Catalog": c,
+		"Module":  module,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("%s: generated invalid Go: %w", t.Name(), err)
+	}
+
+	return src, nil
+}
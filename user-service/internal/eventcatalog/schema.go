@@ -0,0 +1,111 @@
+package eventcatalog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+)
+
+// schema is the subset of JSON Schema the catalog produces, with the keys in
+// a stable order.
+type schema struct {
+	Schema      string     `json:"$schema,omitempty"`
+	Title       string     `json:"title,omitempty"`
+	Description string     `json:"description,omitempty"`
+	Type        string     `json:"type,omitempty"`
+	Format      string     `json:"format,omitempty"`
+	Enum        []string   `json:"enum,omitempty"`
+	Minimum     *float64   `json:"minimum,omitempty"`
+	Maximum     *float64   `json:"maximum,omitempty"`
+	MinLength   *int       `json:"minLength,omitempty"`
+	MaxLength   *int       `json:"maxLength,omitempty"`
+	Properties  properties `json:"properties,omitempty"`
+	Required    []string   `json:"required,omitempty"`
+	Not         *schema    `json:"not,omitempty"`
+	PII         bool       `json:"x-pii,omitempty"`
+}
+
+type property struct {
+	name   string
+	schema *schema
+}
+
+type properties []property
+
+func (p properties) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, prop := range p {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+
+		name, _ := json.Marshal(prop.name)
+		value, err := json.Marshal(prop.schema)
+		if err != nil {
+			return nil, err
+		}
+
+		b.Write(name)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+
+	return b.Bytes(), nil
+}
+
+// payloadSchema is the JSON Schema of the event's payload.
+func (e *Event) payloadSchema() *schema {
+	s := &schema{
+		Title:       e.Pattern,
+		Description: e.Description,
+		Type:        "object",
+	}
+
+	for _, f := range e.Payload {
+		s.Properties = append(s.Properties, property{name: f.Name, schema: &schema{
+			Description: f.Description,
+			Type:        f.Type,
+			Format:      f.Format,
+			Enum:        f.Enum,
+			Minimum:     f.Minimum,
+			Maximum:     f.Maximum,
+			MinLength:   f.MinLength,
+			MaxLength:   f.MaxLength,
+			PII:         f.PII,
+		}})
+
+		if f.Required {
+			s.Required = append(s.Required, f.Name)
+		}
+	}
+
+	if len(e.Forbidden) > 0 {
+		s.Not = &schema{Required: e.Forbidden}
+	}
+
+	return s
+}
+
+// SchemaPath is where the schema of the event's current version lives below
+// the eventschema schemas directory.
+func (e *Event) SchemaPath() string {
+	return path.Join(e.Pattern, "v"+strconv.Itoa(e.Version)+".json")
+}
+
+// Schema renders the JSON Schema file of the event's current version, as
+// embedded by the eventschema package.
+func (e *Event) Schema() ([]byte, error) {
+	s := e.payloadSchema()
+	s.Schema = "https://json-schema.org/draft/2020-12/schema"
+
+	raw, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("schema %s: %w", e.Pattern, err)
+	}
+
+	return append(raw, '\n'), nil
+}
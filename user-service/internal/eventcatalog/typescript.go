@@ -0,0 +1,64 @@
+package eventcatalog
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+)
+
+var tsTypes = map[string]string{
+	"string":  "string",
+	"integer": "number",
+	"number":  "number",
+	"boolean": "boolean",
+}
+
+var typescriptTemplate = template.Must(template.New("typescript").Funcs(template.FuncMap{
+	"constant": func(e Event) string { return strings.TrimPrefix(e.constantName(), "EVENT_") + "_EVENT" },
+	"optional": func(f Field) string {
+		if f.Required {
+			return ""
+		}
+		return "?"
+	},
+	"tsType": func(f Field) string {
+		if len(f.Enum) == 0 {
+			return tsTypes[f.Type]
+		}
+		values := make([]string, len(f.Enum))
+		for i, v := range f.Enum {
+			values[i] = "'" + strings.ReplaceAll(v, "'", "\\'") + "'"
+		}
+		return strings.Join(values, " | ")
+	},
+}).Parse(`// Code generated by user-service/cmd/eventgen from user-service/events.yaml. DO NOT EDIT.
+{{ range .Events }}
+export const {{ constant . }} = '{{ .Pattern }}';
+
+/**
+ * Payload of {{ .Pattern }} v{{ .Version }}.{{ with .Description }} {{ . }}{{ end }}
+ */
+export interface {{ .Name }} {
+{{- range .Payload }}
+  {{- if and .Description .PII }}
+  /** {{ .Description }} Encrypted on the wire when PII encryption is enabled. */
+  {{- else if .PII }}
+  /** Encrypted on the wire when PII encryption is enabled. */
+  {{- else if .Description }}
+  /** {{ .Description }} */
+  {{- end }}
+  {{ .Name }}{{ optional . }}: {{ tsType . }};
+{{- end }}
+}
+{{ end }}`))
+
+// TypeScript renders the payload interfaces and pattern constants for the
+// NestJS consumers.
+func (c *Catalog) TypeScript() ([]byte, error) {
+	var b bytes.Buffer
+	if err := typescriptTemplate.Execute(&b, c); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
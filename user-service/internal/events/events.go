@@ -0,0 +1,15 @@
+// Package events publishes the events declared in events.yaml with typed
+// payloads. The payload types and Publish methods are generated.
+package events
+
+//go:generate go run ../../cmd/eventgen -root ../..
+
+import "github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/eventbus"
+
+type Publisher struct {
+	bus eventbus.EventBus
+}
+
+func NewPublisher(bus eventbus.EventBus) *Publisher {
+	return &Publisher{bus: bus}
+}
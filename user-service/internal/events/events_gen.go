@@ -0,0 +1,43 @@
+// Code generated by cmd/eventgen from events.yaml. DO NOT EDIT.
+
+package events
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/constant"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/eventbus"
+)
+
+// UserCreated is the payload of user.created v1. Published when a user signs up. Consumed by notification-service to send the welcome email.
+type UserCreated struct {
+	ID    int    `json:"id"`
+	UUID  string `json:"uuid"`
+	Name  string `json:"name" pii:"true"`
+	Email string `json:"email" pii:"true"`
+}
+
+// PublishUserCreated publishes user.created v1 to the notification-service queue.
+func (p *Publisher) PublishUserCreated(ctx context.Context, payload UserCreated, opts ...eventbus.PublishOption) error {
+	return p.bus.Publish(ctx, "notification-service", newUserCreatedEvent(payload).Apply(opts...))
+}
+
+// PublishUserCreatedBatch publishes user.created v1 for every payload as one batch to the notification-service queue, and returns the error of each.
+func (p *Publisher) PublishUserCreatedBatch(ctx context.Context, payloads []UserCreated, opts ...eventbus.PublishOption) []error {
+	events := make([]*eventbus.Event, len(payloads))
+	for i, payload := range payloads {
+		events[i] = newUserCreatedEvent(payload).Apply(opts...)
+	}
+
+	return eventbus.PublishBatch(ctx, p.bus, "notification-service", events)
+}
+
+func newUserCreatedEvent(payload UserCreated) *eventbus.Event {
+	return &eventbus.Event{
+		Pattern:       constant.EVENT_USER_CREATED,
+		Data:          payload,
+		Subject:       fmt.Sprint(payload.UUID),
+		SchemaVersion: constant.EVENT_USER_CREATED_SCHEMA_VERSION,
+	}
+}
@@ -0,0 +1,216 @@
+package eventschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"reflect"
+	"slices"
+	"sort"
+)
+
+// Incompatibility is a schema change that could break existing consumers.
+type Incompatibility struct {
+	Ref    Ref
+	Path   string
+	Reason string
+}
+
+func (i Incompatibility) String() string {
+	return fmt.Sprintf("%s %s: %s", i.Ref, i.Path, i.Reason)
+}
+
+// annotations do not constrain payloads, so changing them is always safe.
+var annotations = map[string]bool{
+	"$schema": true, "$id": true, "$comment": true, "title": true, "description": true,
+	"examples": true, "default": true, "deprecated": true, "x-pii": true,
+}
+
+// Compare reports the changes from base to current that are not backward
+// compatible. An event version's schema may only change so that every payload
+// it accepts was already accepted before, because consumers were written
+// against the old one. Removing a version is incompatible; breaking changes
+// go into a new version instead.
+func Compare(base, current fs.FS) ([]Incompatibility, error) {
+	before, err := ReadSchemas(base)
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := ReadSchemas(current)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []Incompatibility
+	for ref, old := range before {
+		doc, ok := after[ref]
+		if !ok {
+			found = append(found, Incompatibility{Ref: ref, Path: "/", Reason: "schema removed"})
+			continue
+		}
+
+		for _, c := range compareSchema("/", old, doc) {
+			c.Ref = ref
+			found = append(found, c)
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].String() < found[j].String()
+	})
+
+	return found, nil
+}
+
+func compareSchema(path string, old, cur any) []Incompatibility {
+	if reflect.DeepEqual(old, cur) || old == true || cur == false {
+		return nil
+	}
+
+	o, ok := old.(map[string]any)
+	if !ok {
+		return []Incompatibility{{Path: path, Reason: "schema no longer rejects every payload"}}
+	}
+	n, ok := cur.(map[string]any)
+	if !ok {
+		return []Incompatibility{{Path: path, Reason: "schema no longer constrains the payload"}}
+	}
+
+	var found []Incompatibility
+	report := func(format string, args ...any) {
+		found = append(found, Incompatibility{Path: path, Reason: fmt.Sprintf(format, args...)})
+	}
+
+	for keyword, was := range o {
+		is, present := n[keyword]
+		if annotations[keyword] || (present && reflect.DeepEqual(was, is)) {
+			continue
+		}
+
+		switch keyword {
+		case "type":
+			if !present {
+				report("type constraint removed")
+			} else if widened := widenedTypes(was, is); len(widened) > 0 {
+				report("type now allows %v", widened)
+			}
+		case "required":
+			for _, name := range stringList(was) {
+				if !slices.Contains(stringList(is), name) {
+					report("property %q is no longer required", name)
+				}
+			}
+		case "properties":
+			props, _ := is.(map[string]any)
+			for name, schema := range asMap(was) {
+				if s, ok := props[name]; ok {
+					found = append(found, compareSchema(path+"properties/"+name+"/", schema, s)...)
+				}
+			}
+		case "additionalProperties":
+			if !present {
+				report("additional properties are now allowed")
+			} else {
+				found = append(found, compareSchema(path+"additionalProperties/", was, is)...)
+			}
+		case "items":
+			if !present {
+				report("items constraint removed")
+			} else {
+				found = append(found, compareSchema(path+"items/", was, is)...)
+			}
+		case "enum":
+			for _, v := range anyList(is) {
+				if !slices.ContainsFunc(anyList(was), func(w any) bool { return reflect.DeepEqual(v, w) }) {
+					report("enum now allows %v", v)
+				}
+			}
+			if !present {
+				report("enum constraint removed")
+			}
+		case "minimum", "exclusiveMinimum", "minLength", "minItems", "minProperties":
+			if !present || number(is) < number(was) {
+				report("%s lowered", keyword)
+			}
+		case "maximum", "exclusiveMaximum", "maxLength", "maxItems", "maxProperties":
+			if !present || number(is) > number(was) {
+				report("%s raised", keyword)
+			}
+		default:
+			report("%s changed and cannot be checked automatically", keyword)
+		}
+	}
+
+	// New properties are only a problem when the old schema rejected unknown
+	// ones or constrained them.
+	if props, ok := n["properties"].(map[string]any); ok {
+		known := asMap(o["properties"])
+		for name, schema := range props {
+			if _, ok := known[name]; ok {
+				continue
+			}
+			if extra, ok := o["additionalProperties"]; extra == false {
+				report("property %q added to an object that rejected unknown properties", name)
+			} else if ok {
+				found = append(found, compareSchema(path+"properties/"+name+"/", extra, schema)...)
+			}
+		}
+	}
+
+	return found
+}
+
+// widenedTypes returns the types cur allows that old did not.
+func widenedTypes(old, cur any) []string {
+	allowed := stringList(old)
+	if s, ok := old.(string); ok {
+		allowed = []string{s}
+	}
+	types := stringList(cur)
+	if s, ok := cur.(string); ok {
+		types = []string{s}
+	}
+
+	var widened []string
+	for _, t := range types {
+		if slices.Contains(allowed, t) || (t == "integer" && slices.Contains(allowed, "number")) {
+			continue
+		}
+		widened = append(widened, t)
+	}
+
+	return widened
+}
+
+func asMap(v any) map[string]any {
+	m, _ := v.(map[string]any)
+	return m
+}
+
+func anyList(v any) []any {
+	l, _ := v.([]any)
+	return l
+}
+
+func stringList(v any) []string {
+	var out []string
+	for _, item := range anyList(v) {
+		if s, ok := item.(string); ok {
+			out = append(out, s)
+		}
+	}
+
+	return out
+}
+
+func number(v any) float64 {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0
+	}
+
+	f, _ := n.Float64()
+
+	return f
+}
@@ -0,0 +1,170 @@
+package eventschema
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func compareDocs(t *testing.T, base, current string) []Incompatibility {
+	t.Helper()
+
+	before := fstest.MapFS{"order.created/v1.json": {Data: []byte(base)}}
+	after := fstest.MapFS{"order.created/v1.json": {Data: []byte(current)}}
+
+	found, err := Compare(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return found
+}
+
+const baseSchema = `{
+	"type": "object",
+	"description": "An order was placed.",
+	"properties": {
+		"id": {"type": "integer", "minimum": 1},
+		"status": {"type": "string", "enum": ["new", "paid"]},
+		"note": {"type": "string", "minLength": 1, "maxLength": 100}
+	},
+	"required": ["id", "status"]
+}`
+
+func TestCompareCompatibleChanges(t *testing.T) {
+	changes := map[string]string{
+		"unchanged":             baseSchema,
+		"annotation changed":    strings.Replace(baseSchema, "An order was placed.", "Published when an order is placed.", 1),
+		"optional property":     strings.Replace(baseSchema, `"properties": {`, `"properties": {"total": {"type": "number"},`, 1),
+		"new required property": strings.Replace(strings.Replace(baseSchema, `"properties": {`, `"properties": {"total": {"type": "number"},`, 1), `"required": ["id", "status"]`, `"required": ["id", "status", "total"]`, 1),
+		"enum narrowed":         strings.Replace(baseSchema, `["new", "paid"]`, `["new"]`, 1),
+		"length tightened":      strings.Replace(baseSchema, `"minLength": 1, "maxLength": 100`, `"minLength": 2, "maxLength": 50`, 1),
+		"minimum raised":        strings.Replace(baseSchema, `"minimum": 1`, `"minimum": 10`, 1),
+		"keyword added":         strings.Replace(baseSchema, `"minLength": 1,`, `"minLength": 1, "pattern": "^[a-z]+$",`, 1),
+	}
+
+	for name, current := range changes {
+		t.Run(name, func(t *testing.T) {
+			if found := compareDocs(t, baseSchema, current); len(found) != 0 {
+				t.Errorf("got incompatibilities %v", found)
+			}
+		})
+	}
+}
+
+func TestCompareIncompatibleChanges(t *testing.T) {
+	changes := []struct {
+		name    string
+		current string
+		path    string
+		reason  string
+	}{
+		{
+			name:    "no longer required",
+			current: strings.Replace(baseSchema, `"required": ["id", "status"]`, `"required": ["id"]`, 1),
+			path:    "/",
+			reason:  `property "status" is no longer required`,
+		},
+		{
+			name:    "type widened",
+			current: strings.Replace(baseSchema, `"id": {"type": "integer"`, `"id": {"type": ["integer", "string"]`, 1),
+			path:    "/properties/id/",
+			reason:  "type now allows [string]",
+		},
+		{
+			name:    "enum widened",
+			current: strings.Replace(baseSchema, `["new", "paid"]`, `["new", "paid", "refunded"]`, 1),
+			path:    "/properties/status/",
+			reason:  "enum now allows refunded",
+		},
+		{
+			name:    "minimum lowered",
+			current: strings.Replace(baseSchema, `"minimum": 1`, `"minimum": 0`, 1),
+			path:    "/properties/id/",
+			reason:  "minimum lowered",
+		},
+		{
+			name:    "max length removed",
+			current: strings.Replace(baseSchema, `, "maxLength": 100`, ``, 1),
+			path:    "/properties/note/",
+			reason:  "maxLength raised",
+		},
+		{
+			name:    "type removed",
+			current: strings.Replace(baseSchema, `"type": "object",`, ``, 1),
+			path:    "/",
+			reason:  "type constraint removed",
+		},
+	}
+
+	for _, tc := range changes {
+		t.Run(tc.name, func(t *testing.T) {
+			found := compareDocs(t, baseSchema, tc.current)
+			if len(found) != 1 || found[0].Path != tc.path || found[0].Reason != tc.reason {
+				t.Fatalf("got %v, want one incompatibility at %s: %s", found, tc.path, tc.reason)
+			}
+			if want := (Ref{Pattern: "order.created", Version: 1}); found[0].Ref != want {
+				t.Errorf("got ref %s, want %s", found[0].Ref, want)
+			}
+		})
+	}
+}
+
+func TestCompareChangedKeywordCannotBeChecked(t *testing.T) {
+	base := `{"type": "string", "pattern": "^[a-z]+$"}`
+	found := compareDocs(t, base, `{"type": "string", "pattern": "^[a-z0-9]+$"}`)
+	if len(found) != 1 || found[0].Reason != "pattern changed and cannot be checked automatically" {
+		t.Errorf("got %v, want the pattern change reported", found)
+	}
+}
+
+func TestCompareAdditionalProperties(t *testing.T) {
+	closed := `{"type": "object", "properties": {"id": {"type": "integer"}}, "additionalProperties": false}`
+
+	found := compareDocs(t, closed, `{"type": "object", "properties": {"id": {"type": "integer"}, "total": {"type": "number"}}, "additionalProperties": false}`)
+	if len(found) != 1 || found[0].Reason != `property "total" added to an object that rejected unknown properties` {
+		t.Errorf("adding to a closed object: got %v", found)
+	}
+
+	found = compareDocs(t, closed, `{"type": "object", "properties": {"id": {"type": "integer"}}}`)
+	if len(found) != 1 || found[0].Reason != "additional properties are now allowed" {
+		t.Errorf("opening a closed object: got %v", found)
+	}
+
+	// Properties the old schema constrained through additionalProperties
+	// must keep within that constraint.
+	typed := `{"type": "object", "additionalProperties": {"type": "string"}}`
+	found = compareDocs(t, typed, `{"type": "object", "properties": {"total": {"type": "number"}}, "additionalProperties": {"type": "string"}}`)
+	if len(found) != 1 || found[0].Path != "/properties/total/" {
+		t.Errorf("retyping an additional property: got %v", found)
+	}
+}
+
+func TestCompareRemovedSchema(t *testing.T) {
+	before := fstest.MapFS{
+		"order.created/v1.json": {Data: []byte(baseSchema)},
+		"order.created/v2.json": {Data: []byte(baseSchema)},
+	}
+	after := fstest.MapFS{
+		"order.created/v2.json": {Data: []byte(baseSchema)},
+		"order.paid/v1.json":    {Data: []byte(baseSchema)},
+	}
+
+	found, err := Compare(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].Ref != (Ref{Pattern: "order.created", Version: 1}) || found[0].Reason != "schema removed" {
+		t.Errorf("got %v, want only v1's removal reported", found)
+	}
+}
+
+func TestEmbeddedSchemasAreCompatibleWithThemselves(t *testing.T) {
+	found, err := Compare(Schemas(), Schemas())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 0 {
+		t.Errorf("got incompatibilities %v", found)
+	}
+}
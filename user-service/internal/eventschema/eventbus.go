@@ -0,0 +1,89 @@
+package eventschema
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/eventbus"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+)
+
+const (
+	// ValidationStrict rejects publishes whose payload does not match.
+	ValidationStrict = "strict"
+	// ValidationLog publishes anyway and logs the mismatch.
+	ValidationLog = "log"
+	ValidationOff = "off"
+)
+
+var validationFailuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "event_schema_validation_failures_total",
+	Help: "Published events whose payload did not match the event schema.",
+}, []string{"event"})
+
+type ValidatingEventBusOpts struct {
+	EventBus eventbus.EventBus
+	Registry *Registry
+	Mode     string
+	Logger   logger.Logger
+}
+
+type validatingEventBus struct {
+	eventbus.EventBus
+	registry *Registry
+	mode     string
+	logger   logger.Logger
+}
+
+// NewValidatingEventBus checks every published payload against its schema
+// before handing it to the wrapped bus.
+func NewValidatingEventBus(opts *ValidatingEventBusOpts) eventbus.EventBus {
+	if opts.Mode == ValidationOff {
+		return opts.EventBus
+	}
+
+	return &validatingEventBus{
+		EventBus: opts.EventBus,
+		registry: opts.Registry,
+		mode:     opts.Mode,
+		logger:   opts.Logger,
+	}
+}
+
+func (b *validatingEventBus) Publish(ctx context.Context, topic string, event *eventbus.Event) error {
+	if err := b.validate(topic, event); err != nil {
+		return err
+	}
+
+	return b.EventBus.Publish(ctx, topic, event)
+}
+
+// PublishBatch validates every event and publishes the valid ones as a batch.
+func (b *validatingEventBus) PublishBatch(ctx context.Context, topic string, events []*eventbus.Event) []error {
+	return eventbus.PublishPrepared(ctx, b.EventBus, topic, events, func(event *eventbus.Event) (*eventbus.Event, error) {
+		return event, b.validate(topic, event)
+	})
+}
+
+// validate returns the validation error in strict mode, and only logs it
+// otherwise.
+func (b *validatingEventBus) validate(topic string, event *eventbus.Event) error {
+	err := b.registry.Validate(event.Pattern, event.SchemaVersion, event.Data)
+	if err == nil {
+		return nil
+	}
+
+	validationFailuresTotal.WithLabelValues(event.Pattern).Inc()
+	if b.mode == ValidationStrict {
+		return err
+	}
+
+	b.logger.Warn("Event payload failed schema validation",
+		logger.Field{Key: "event", Value: event.Pattern},
+		logger.Field{Key: "topic", Value: topic},
+		logger.Field{Key: "error", Value: err.Error()},
+	)
+
+	return nil
+}
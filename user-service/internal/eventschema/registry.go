@@ -0,0 +1,184 @@
+package eventschema
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// schemas holds one JSON Schema per published event version, at
+// schemas/<pattern>/v<version>.json.
+//
+//go:embed schemas
+var schemas embed.FS
+
+var (
+	ErrSchemaNotFound = errors.New("no schema registered for event")
+	ErrInvalidPayload = errors.New("event payload does not match its schema")
+)
+
+// Ref identifies the schema of one version of an event.
+type Ref struct {
+	Pattern string
+	Version int
+}
+
+func (r Ref) String() string {
+	return fmt.Sprintf("%s v%d", r.Pattern, r.Version)
+}
+
+// Schemas returns the embedded schema files, laid out as
+// <pattern>/v<version>.json.
+func Schemas() fs.FS {
+	sub, err := fs.Sub(schemas, "schemas")
+	if err != nil {
+		panic(err)
+	}
+
+	return sub
+}
+
+// Registry validates event payloads against their schemas.
+type Registry struct {
+	schemas map[Ref]*jsonschema.Schema
+}
+
+// NewRegistry compiles the embedded schemas.
+func NewRegistry() (*Registry, error) {
+	return Load(Schemas())
+}
+
+// Load compiles the schemas in fsys, laid out like Schemas.
+func Load(fsys fs.FS) (*Registry, error) {
+	docs, err := ReadSchemas(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	compiler := jsonschema.NewCompiler()
+	compiler.AssertFormat()
+
+	r := &Registry{schemas: map[Ref]*jsonschema.Schema{}}
+
+	for ref, doc := range docs {
+		url := "event:///" + ref.Pattern + "/v" + strconv.Itoa(ref.Version) + ".json"
+		if err := compiler.AddResource(url, doc); err != nil {
+			return nil, fmt.Errorf("schema %s: %w", ref, err)
+		}
+
+		schema, err := compiler.Compile(url)
+		if err != nil {
+			return nil, fmt.Errorf("schema %s: %w", ref, err)
+		}
+		r.schemas[ref] = schema
+	}
+
+	return r, nil
+}
+
+// Validate checks data, as it would be encoded to JSON, against the schema
+// of the given event version. Version 0 means 1, as on the wire.
+func (r *Registry) Validate(pattern string, version int, data any) error {
+	if version == 0 {
+		version = 1
+	}
+	ref := Ref{Pattern: pattern, Version: version}
+
+	schema, ok := r.schemas[ref]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrSchemaNotFound, ref)
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	instance, err := jsonschema.UnmarshalJSON(bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+
+	if err := schema.Validate(instance); err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrInvalidPayload, ref, err)
+	}
+
+	return nil
+}
+
+// Missing returns the published event versions that have no schema.
+func (r *Registry) Missing(published map[string]int) []Ref {
+	var missing []Ref
+	for pattern, version := range published {
+		ref := Ref{Pattern: pattern, Version: version}
+		if _, ok := r.schemas[ref]; !ok {
+			missing = append(missing, ref)
+		}
+	}
+
+	sort.Slice(missing, func(i, j int) bool {
+		return missing[i].String() < missing[j].String()
+	})
+
+	return missing
+}
+
+// ReadSchemas parses every schema document in fsys without compiling it.
+func ReadSchemas(fsys fs.FS) (map[Ref]any, error) {
+	docs := map[Ref]any{}
+
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		ref, ok := parseSchemaPath(name)
+		if !ok {
+			return fmt.Errorf("unexpected schema file %s, want <pattern>/v<version>.json", name)
+		}
+
+		raw, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+
+		doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(raw))
+		if err != nil {
+			return fmt.Errorf("schema %s: %w", name, err)
+		}
+		docs[ref] = doc
+
+		return nil
+	})
+
+	return docs, err
+}
+
+func parseSchemaPath(name string) (Ref, bool) {
+	pattern, file := path.Split(name)
+	pattern = strings.TrimSuffix(pattern, "/")
+	if pattern == "" || strings.Contains(pattern, "/") {
+		return Ref{}, false
+	}
+
+	v, ok := strings.CutSuffix(strings.TrimPrefix(file, "v"), ".json")
+	if !ok || !strings.HasPrefix(file, "v") {
+		return Ref{}, false
+	}
+
+	version, err := strconv.Atoi(v)
+	if err != nil || version < 1 {
+		return Ref{}, false
+	}
+
+	return Ref{Pattern: pattern, Version: version}, true
+}
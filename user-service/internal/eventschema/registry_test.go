@@ -0,0 +1,61 @@
+package eventschema
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestValidate(t *testing.T) {
+	r, err := NewRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := map[string]any{"id": 1, "uuid": "4f9c7a52-5b3e-4c1d-9a8e-2f6d0b7c1e34", "name": "Jane", "email": "jane@example.com"}
+	if err := r.Validate("user.created", 1, valid); err != nil {
+		t.Errorf("valid payload: %v", err)
+	}
+	// Unversioned messages are version 1.
+	if err := r.Validate("user.created", 0, valid); err != nil {
+		t.Errorf("version 0: %v", err)
+	}
+
+	invalid := map[string]map[string]any{
+		"missing field":  {"id": 1, "uuid": valid["uuid"], "name": "Jane"},
+		"bad format":     {"id": 1, "uuid": valid["uuid"], "name": "Jane", "email": "not an email"},
+		"below minimum":  {"id": 0, "uuid": valid["uuid"], "name": "Jane", "email": "jane@example.com"},
+		"leaks password": {"id": 1, "uuid": valid["uuid"], "name": "Jane", "email": "jane@example.com", "password": "secret"},
+	}
+	for name, data := range invalid {
+		if err := r.Validate("user.created", 1, data); !errors.Is(err, ErrInvalidPayload) {
+			t.Errorf("%s: got error %v, want %v", name, err, ErrInvalidPayload)
+		}
+	}
+
+	if err := r.Validate("user.created", 2, valid); !errors.Is(err, ErrSchemaNotFound) {
+		t.Errorf("unknown version: got error %v, want %v", err, ErrSchemaNotFound)
+	}
+}
+
+func TestMissing(t *testing.T) {
+	r, err := Load(fstest.MapFS{"order.created/v1.json": {Data: []byte(`{"type": "object"}`)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := r.Missing(map[string]int{"order.created": 1, "order.paid": 1, "order.created.v2": 2})
+	want := []Ref{{Pattern: "order.created.v2", Version: 2}, {Pattern: "order.paid", Version: 1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestLoadRejectsMisplacedSchemas(t *testing.T) {
+	for _, name := range []string{"v1.json", "order.created/1.json", "order.created/v0.json", "order/created/v1.json"} {
+		if _, err := Load(fstest.MapFS{name: {Data: []byte(`{}`)}}); err == nil {
+			t.Errorf("%s: loaded a schema outside <pattern>/v<version>.json", name)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package natsbus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/config"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/eventbus"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+const (
+	headerContentType = "Content-Type"
+	contentTypeJSON   = "application/json"
+)
+
+// envelope has the same JSON shape as the envelope published on RabbitMQ, so
+// consumers can move between transports without changing their decoding.
+type envelope struct {
+	Pattern         string    `json:"pattern"`
+	Data            any       `json:"data"`
+	MessageID       string    `json:"messageId,omitempty"`
+	CorrelationID   string    `json:"correlationId,omitempty"`
+	CausationID     string    `json:"causationId,omitempty"`
+	Subject         string    `json:"subject,omitempty"`
+	Producer        string    `json:"producer,omitempty"`
+	ProducerVersion string    `json:"producerVersion,omitempty"`
+	SchemaVersion   int       `json:"schemaVersion,omitempty"`
+	OccurredAt      time.Time `json:"occurredAt"`
+}
+
+type Opts struct {
+	Logger logger.Logger
+	Config *config.NATS
+	App    *config.App
+}
+
+// Bus is an eventbus.EventBus on NATS JetStream. Every topic is a subject
+// backed by its own work-queue stream, and every subscriber of a topic shares
+// one durable consumer, which gives the competing-consumer semantics of a
+// RabbitMQ queue.
+type Bus struct {
+	config *config.NATS
+	app    *config.App
+	conn   *nats.Conn
+	js     jetstream.JetStream
+	logger logger.Logger
+
+	// streams caches the streams created so far by topic.
+	mu      sync.Mutex
+	streams map[string]jetstream.Stream
+}
+
+func New(opts *Opts) (*Bus, error) {
+	conn, err := nats.Connect(opts.Config.URL,
+		nats.Name(opts.App.Name),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				opts.Logger.Warn("NATS disconnected", logger.Field{Key: "error", Value: err.Error()})
+			}
+		}),
+		nats.ReconnectHandler(func(c *nats.Conn) {
+			opts.Logger.Info("NATS reconnected", logger.Field{Key: "url", Value: c.ConnectedUrlRedacted()})
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	opts.Logger.Info("NATS connected", logger.Field{Key: "url", Value: conn.ConnectedUrlRedacted()})
+
+	return &Bus{
+		config:  opts.Config,
+		app:     opts.App,
+		conn:    conn,
+		js:      js,
+		logger:  opts.Logger,
+		streams: map[string]jetstream.Stream{},
+	}, nil
+}
+
+func (b *Bus) Health() error {
+	if b.conn.Status() != nats.CONNECTED {
+		return errors.New("nats healthcheck failed")
+	}
+
+	return nil
+}
+
+// Publish stores the event in the topic's stream and waits for the stream to
+// acknowledge it. The message id doubles as the JetStream deduplication id.
+// Of the publish options only headers are supported, since JetStream has no
+// per-message TTL, priority or delay.
+func (b *Bus) Publish(ctx context.Context, topic string, event *eventbus.Event) error {
+	if event.TTL > 0 || event.Priority > 0 || event.Delay > 0 {
+		return fmt.Errorf("%w: nats supports headers only", eventbus.ErrOptionUnsupported)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, b.config.PublishTimeout)
+	defer cancel()
+
+	if _, err := b.ensureStream(ctx, topic); err != nil {
+		return err
+	}
+
+	env := &envelope{
+		Pattern:         event.Pattern,
+		Data:            event.Data,
+		MessageID:       uuid.NewString(),
+		CorrelationID:   eventbus.CorrelationIDFromContext(ctx),
+		CausationID:     eventbus.CausationIDFromContext(ctx),
+		Subject:         event.Subject,
+		Producer:        b.app.Name,
+		ProducerVersion: b.app.Version,
+		SchemaVersion:   event.SchemaVersion,
+		OccurredAt:      time.Now().UTC(),
+	}
+	if env.CorrelationID == "" {
+		env.CorrelationID = env.MessageID
+	}
+
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+
+	msg := nats.NewMsg(topic)
+	msg.Data = data
+	for k, v := range event.Headers {
+		msg.Header.Set(k, fmt.Sprint(v))
+	}
+	msg.Header.Set(headerContentType, contentTypeJSON)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(http.Header(msg.Header)))
+
+	if _, err := b.js.PublishMsg(ctx, msg, jetstream.WithMsgID(env.MessageID)); err != nil {
+		return err
+	}
+
+	b.logger.Info("NATS message sent",
+		logger.Field{Key: "event", Value: env.Pattern},
+		logger.Field{Key: "message_id", Value: env.MessageID},
+		logger.Field{Key: "correlation_id", Value: env.CorrelationID},
+	)
+
+	return nil
+}
+
+// Subscribe delivers messages from topic to handler until ctx is cancelled.
+// A nil handler error acknowledges the message. Any other error naks it for
+// redelivery after a backoff, until it has been delivered MaxDeliver times,
+// after which it is terminated.
+func (b *Bus) Subscribe(ctx context.Context, topic string, handler eventbus.Handler) error {
+	stream, err := b.ensureStream(ctx, topic)
+	if err != nil {
+		return err
+	}
+
+	consumer, err := stream.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{
+		Durable:    streamName(topic),
+		AckPolicy:  jetstream.AckExplicitPolicy,
+		AckWait:    b.config.AckWait,
+		MaxDeliver: b.config.MaxDeliver,
+	})
+	if err != nil {
+		return err
+	}
+
+	consumeCtx, err := consumer.Consume(func(msg jetstream.Msg) {
+		b.handle(ctx, msg, handler)
+	})
+	if err != nil {
+		return err
+	}
+	defer consumeCtx.Stop()
+
+	b.logger.Info("NATS consumer started", logger.Field{Key: "topic", Value: topic})
+
+	<-ctx.Done()
+
+	return ctx.Err()
+}
+
+// Close drains subscriptions and buffered publishes, then closes the
+// connection. If ctx expires first the connection is closed immediately.
+func (b *Bus) Close(ctx context.Context) error {
+	if err := b.conn.Drain(); err != nil {
+		return err
+	}
+
+	t := time.NewTicker(50 * time.Millisecond)
+	defer t.Stop()
+
+	for !b.conn.IsClosed() {
+		select {
+		case <-ctx.Done():
+			b.conn.Close()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+
+	return nil
+}
+
+func (b *Bus) handle(ctx context.Context, msg jetstream.Msg, handler eventbus.Handler) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(http.Header(msg.Headers())))
+
+	m := &message{msg: msg}
+	if env, err := m.envelope(); err == nil {
+		if env.CorrelationID != "" {
+			ctx = eventbus.WithCorrelationID(ctx, env.CorrelationID)
+		}
+		if env.MessageID != "" {
+			ctx = eventbus.WithCausationID(ctx, env.MessageID)
+		}
+	}
+
+	err := handler(ctx, m)
+	if m.settled {
+		return
+	}
+
+	if err != nil {
+		delivered := uint64(1)
+		if meta, err := msg.Metadata(); err == nil {
+			delivered = meta.NumDelivered
+		}
+
+		b.logger.Error("NATS message handler failed",
+			logger.Field{Key: "event", Value: m.Pattern()},
+			logger.Field{Key: "message_id", Value: m.ID()},
+			logger.Field{Key: "delivered", Value: delivered},
+			logger.Field{Key: "error", Value: err.Error()},
+		)
+
+		if b.config.MaxDeliver > 0 && delivered >= uint64(b.config.MaxDeliver) {
+			if err := msg.Term(); err != nil {
+				b.logger.Error("NATS failed to terminate message", logger.Field{Key: "error", Value: err.Error()})
+			}
+			return
+		}
+
+		if err := msg.NakWithDelay(b.redeliveryDelay(delivered)); err != nil {
+			b.logger.Error("NATS failed to nak message", logger.Field{Key: "error", Value: err.Error()})
+		}
+		return
+	}
+
+	if err := msg.Ack(); err != nil {
+		b.logger.Error("NATS failed to acknowledge message", logger.Field{Key: "error", Value: err.Error()})
+	}
+}
+
+// redeliveryDelay doubles from a second with every delivery, up to AckWait.
+func (b *Bus) redeliveryDelay(delivered uint64) time.Duration {
+	delay := b.config.AckWait
+	if delivered <= 16 {
+		delay = min(delay, time.Second<<(delivered-1))
+	}
+
+	return delay
+}
+
+// ensureStream creates the topic's stream the first time it is used.
+func (b *Bus) ensureStream(ctx context.Context, topic string) (jetstream.Stream, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if stream, ok := b.streams[topic]; ok {
+		return stream, nil
+	}
+
+	stream, err := b.js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:      streamName(topic),
+		Subjects:  []string{topic},
+		Retention: jetstream.WorkQueuePolicy,
+		Storage:   jetstream.FileStorage,
+	})
+	if err != nil {
+		return nil, err
+	}
+	b.streams[topic] = stream
+
+	return stream, nil
+}
+
+// streamName derives a valid stream and consumer name from a subject.
+func streamName(topic string) string {
+	return strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_", "/", "_", "\\", "_").Replace(topic)
+}
+
+type message struct {
+	msg     jetstream.Msg
+	env     *envelope
+	settled bool
+}
+
+func (m *message) ID() string {
+	if env, err := m.envelope(); err == nil {
+		return env.MessageID
+	}
+
+	return m.msg.Headers().Get(jetstream.MsgIDHeader)
+}
+
+func (m *message) Pattern() string {
+	if env, err := m.envelope(); err == nil {
+		return env.Pattern
+	}
+
+	return ""
+}
+
+func (m *message) Decode(v any) error {
+	return json.Unmarshal(m.msg.Data(), &envelope{Data: v})
+}
+
+func (m *message) Ack() error {
+	m.settled = true
+	return m.msg.Ack()
+}
+
+func (m *message) Nack(requeue bool) error {
+	m.settled = true
+	if requeue {
+		return m.msg.Nak()
+	}
+
+	return m.msg.Term()
+}
+
+func (m *message) envelope() (*envelope, error) {
+	if m.env != nil {
+		return m.env, nil
+	}
+
+	var env envelope
+	if err := json.Unmarshal(m.msg.Data(), &env); err != nil {
+		return nil, err
+	}
+	m.env = &env
+
+	return m.env, nil
+}
@@ -0,0 +1,224 @@
+package natsbus
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/config"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/eventbus"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+)
+
+// runEmbeddedServer starts an in-process NATS server with JetStream enabled on
+// a random local port, shut down when the test ends.
+func runEmbeddedServer(t *testing.T) *server.Server {
+	t.Helper()
+
+	s, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go s.Start()
+	t.Cleanup(s.Shutdown)
+
+	if !s.ReadyForConnections(10 * time.Second) {
+		t.Fatal("embedded nats server did not become ready")
+	}
+
+	return s
+}
+
+func newTestBus(t *testing.T, cfg *config.NATS) *Bus {
+	t.Helper()
+
+	cfg.URL = runEmbeddedServer(t).ClientURL()
+	if cfg.PublishTimeout == 0 {
+		cfg.PublishTimeout = 5 * time.Second
+	}
+	if cfg.AckWait == 0 {
+		cfg.AckWait = 30 * time.Second
+	}
+
+	b, err := New(&Opts{
+		Logger: logger.NewZerologLogger("error", nil),
+		Config: cfg,
+		App:    &config.App{Name: "user-service", Version: "test"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = b.Close(ctx)
+	})
+
+	return b
+}
+
+// subscribe runs handler on topic until the test ends.
+func subscribe(t *testing.T, b *Bus, topic string, handler eventbus.Handler) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := b.Subscribe(ctx, topic, handler); err != nil && !errors.Is(err, context.Canceled) {
+			t.Errorf("Subscribe: %v", err)
+		}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+type payload struct {
+	ID    int    `json:"id"`
+	Email string `json:"email"`
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	b := newTestBus(t, &config.NATS{MaxDeliver: 5})
+
+	received := make(chan payload, 1)
+	var correlationID atomic.Value
+	subscribe(t, b, "test.users", func(ctx context.Context, msg eventbus.Message) error {
+		if msg.Pattern() != "user.created" {
+			t.Errorf("pattern = %q, want user.created", msg.Pattern())
+		}
+		correlationID.Store(eventbus.CorrelationIDFromContext(ctx))
+
+		var p payload
+		if err := msg.Decode(&p); err != nil {
+			return err
+		}
+		received <- p
+		return nil
+	})
+
+	ctx := eventbus.WithCorrelationID(context.Background(), "corr-1")
+	err := b.Publish(ctx, "test.users", &eventbus.Event{
+		Pattern: "user.created",
+		Data:    payload{ID: 1, Email: "jane@example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case p := <-received:
+		if p != (payload{ID: 1, Email: "jane@example.com"}) {
+			t.Errorf("received %+v", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not delivered")
+	}
+
+	if id := correlationID.Load(); id != "corr-1" {
+		t.Errorf("correlation id = %v, want corr-1", id)
+	}
+}
+
+func TestPublishCreatesStreamOnce(t *testing.T) {
+	b := newTestBus(t, &config.NATS{})
+
+	for range 3 {
+		if err := b.Publish(context.Background(), "test.once", &eventbus.Event{Pattern: "test"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A stream deleted behind the bus's back is not recreated, which shows
+	// that publishes after the first do not declare it again.
+	if err := b.js.DeleteStream(context.Background(), streamName("test.once")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Publish(context.Background(), "test.once", &eventbus.Event{Pattern: "test"}); err == nil {
+		t.Error("publish recreated the deleted stream")
+	}
+}
+
+func TestHandlerErrorIsRedeliveredUntilMaxDeliver(t *testing.T) {
+	b := newTestBus(t, &config.NATS{
+		MaxDeliver: 3,
+		AckWait:    100 * time.Millisecond,
+	})
+
+	var deliveries atomic.Int32
+	subscribe(t, b, "test.retry", func(ctx context.Context, msg eventbus.Message) error {
+		deliveries.Add(1)
+		return errors.New("boom")
+	})
+
+	if err := b.Publish(context.Background(), "test.retry", &eventbus.Event{Pattern: "test"}); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for deliveries.Load() < 3 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// Give a fourth delivery the chance to happen.
+	time.Sleep(500 * time.Millisecond)
+	if n := deliveries.Load(); n != 3 {
+		t.Errorf("delivered %d times, want MaxDeliver 3", n)
+	}
+}
+
+func TestHandlerNackWithoutRequeueIsNotRedelivered(t *testing.T) {
+	b := newTestBus(t, &config.NATS{MaxDeliver: 5, AckWait: 100 * time.Millisecond})
+
+	var deliveries atomic.Int32
+	subscribe(t, b, "test.reject", func(ctx context.Context, msg eventbus.Message) error {
+		deliveries.Add(1)
+		return msg.Nack(false)
+	})
+
+	if err := b.Publish(context.Background(), "test.reject", &eventbus.Event{Pattern: "test"}); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	if n := deliveries.Load(); n != 1 {
+		t.Errorf("delivered %d times, want 1", n)
+	}
+}
+
+func TestPublishRejectsUnsupportedOptions(t *testing.T) {
+	b := newTestBus(t, &config.NATS{})
+
+	err := b.Publish(context.Background(), "test.options", &eventbus.Event{Pattern: "test", Delay: time.Second})
+	if !errors.Is(err, eventbus.ErrOptionUnsupported) {
+		t.Errorf("got %v, want ErrOptionUnsupported", err)
+	}
+}
+
+func TestRedeliveryDelay(t *testing.T) {
+	b := &Bus{config: &config.NATS{AckWait: 5 * time.Second}}
+
+	for delivered, want := range map[uint64]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		3:  4 * time.Second,
+		4:  5 * time.Second,
+		40: 5 * time.Second,
+	} {
+		if got := b.redeliveryDelay(delivered); got != want {
+			t.Errorf("redeliveryDelay(%d) = %v, want %v", delivered, got, want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package pii
+
+import (
+	"context"
+
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/eventbus"
+)
+
+type EncryptingEventBusOpts struct {
+	EventBus eventbus.EventBus
+	KMS      KMS
+}
+
+type encryptingEventBus struct {
+	eventbus.EventBus
+	kms KMS
+}
+
+// NewEncryptingEventBus encrypts the PII fields of published payloads with
+// the data key of the event's subject, and decrypts them when subscribers
+// decode messages.
+func NewEncryptingEventBus(opts *EncryptingEventBusOpts) eventbus.EventBus {
+	return &encryptingEventBus{EventBus: opts.EventBus, kms: opts.KMS}
+}
+
+func (b *encryptingEventBus) Publish(ctx context.Context, topic string, event *eventbus.Event) error {
+	encrypted, err := b.encrypt(ctx, event)
+	if err != nil {
+		return err
+	}
+
+	return b.EventBus.Publish(ctx, topic, encrypted)
+}
+
+func (b *encryptingEventBus) PublishBatch(ctx context.Context, topic string, events []*eventbus.Event) []error {
+	return eventbus.PublishPrepared(ctx, b.EventBus, topic, events, func(event *eventbus.Event) (*eventbus.Event, error) {
+		return b.encrypt(ctx, event)
+	})
+}
+
+// encrypt returns a copy of event with its PII fields encrypted.
+func (b *encryptingEventBus) encrypt(ctx context.Context, event *eventbus.Event) (*eventbus.Event, error) {
+	data, err := Encrypt(ctx, b.kms, event.Subject, event.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted := *event
+	encrypted.Data = data
+
+	return &encrypted, nil
+}
+
+func (b *encryptingEventBus) Subscribe(ctx context.Context, topic string, handler eventbus.Handler) error {
+	return b.EventBus.Subscribe(ctx, topic, func(ctx context.Context, msg eventbus.Message) error {
+		return handler(ctx, &decryptingMessage{Message: msg, ctx: ctx, kms: b.kms})
+	})
+}
+
+type decryptingMessage struct {
+	eventbus.Message
+	ctx context.Context
+	kms KMS
+}
+
+// Decode fails with ErrSubjectForgotten for events about a subject whose key
+// was shredded.
+func (m *decryptingMessage) Decode(v any) error {
+	if err := m.Message.Decode(v); err != nil {
+		return err
+	}
+
+	return Decrypt(m.ctx, m.kms, v)
+}
@@ -0,0 +1,251 @@
+package pii
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ciphertextPrefix marks encrypted values: pii:v1:<subject>:<ciphertext>,
+// with the subject base64url and the ciphertext base64 encoded. Carrying the
+// subject lets consumers decrypt without knowing what the event is about.
+const ciphertextPrefix = "pii:v1:"
+
+var ErrInvalidCiphertext = errors.New("pii ciphertext is malformed")
+
+// Encrypt returns a copy of v, a struct or a pointer to one, with the string
+// fields tagged `pii:"true"` encrypted with the subject's data key. Values
+// without such fields are returned unchanged.
+func Encrypt(ctx context.Context, kms KMS, subject string, v any) (any, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return v, nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct || !hasPII(rv.Type()) {
+		return v, nil
+	}
+	if subject == "" {
+		return nil, errors.New("pii fields need an event subject to be encrypted")
+	}
+
+	key, err := kms.DataKey(ctx, subject)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	copied := reflect.New(rv.Type()).Elem()
+	copied.Set(rv)
+
+	err = walkPII(copied, func(field reflect.Value, name string) error {
+		sealed, err := seal(aead, []byte(field.String()), additionalData(subject, name))
+		if err != nil {
+			return err
+		}
+		field.SetString(ciphertextPrefix + base64.RawURLEncoding.EncodeToString([]byte(subject)) + ":" + base64.StdEncoding.EncodeToString(sealed))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return copied.Interface(), nil
+}
+
+// Decrypt decrypts in place the encrypted values in v, a pointer to a struct
+// with `pii:"true"` fields or to a map decoded from JSON. It fails with
+// ErrSubjectForgotten when the subject's key was shredded.
+func Decrypt(ctx context.Context, kms KMS, v any) error {
+	d := &decrypter{kms: kms, keys: map[string][]byte{}}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return nil
+	}
+
+	return d.value(ctx, rv.Elem(), "")
+}
+
+type decrypter struct {
+	kms  KMS
+	keys map[string][]byte
+}
+
+func (d *decrypter) value(ctx context.Context, rv reflect.Value, name string) error {
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if rv.IsNil() {
+			return nil
+		}
+		if rv.Kind() == reflect.Interface {
+			// Values in maps are not addressable; decrypt a copy and put it
+			// back.
+			inner := reflect.New(rv.Elem().Type()).Elem()
+			inner.Set(rv.Elem())
+			if err := d.value(ctx, inner, name); err != nil {
+				return err
+			}
+			rv.Set(inner)
+			return nil
+		}
+		return d.value(ctx, rv.Elem(), name)
+	case reflect.Struct:
+		return walkPII(rv, func(field reflect.Value, name string) error {
+			return d.field(ctx, field, name)
+		})
+	case reflect.Map:
+		for _, k := range rv.MapKeys() {
+			elem := reflect.New(rv.Type().Elem()).Elem()
+			elem.Set(rv.MapIndex(k))
+			if err := d.value(ctx, elem, fmt.Sprint(k.Interface())); err != nil {
+				return err
+			}
+			rv.SetMapIndex(k, elem)
+		}
+	case reflect.Slice:
+		for i := 0; i < rv.Len(); i++ {
+			if err := d.value(ctx, rv.Index(i), name); err != nil {
+				return err
+			}
+		}
+	case reflect.String:
+		return d.field(ctx, rv, name)
+	}
+
+	return nil
+}
+
+func (d *decrypter) field(ctx context.Context, field reflect.Value, name string) error {
+	value := field.String()
+	if !strings.HasPrefix(value, ciphertextPrefix) {
+		return nil
+	}
+
+	encodedSubject, encoded, ok := strings.Cut(strings.TrimPrefix(value, ciphertextPrefix), ":")
+	if !ok {
+		return ErrInvalidCiphertext
+	}
+	rawSubject, err := base64.RawURLEncoding.DecodeString(encodedSubject)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidCiphertext, err)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidCiphertext, err)
+	}
+	subject := string(rawSubject)
+
+	key, ok := d.keys[subject]
+	if !ok {
+		key, err = d.kms.LookupDataKey(ctx, subject)
+		if err != nil {
+			return err
+		}
+		d.keys[subject] = key
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := open(aead, sealed, additionalData(subject, name))
+	if err != nil {
+		return fmt.Errorf("%w: field %s: %w", ErrInvalidCiphertext, name, err)
+	}
+	field.SetString(string(plaintext))
+
+	return nil
+}
+
+// additionalData binds a ciphertext to its subject and field, so it cannot be
+// moved to another field or another subject's event.
+func additionalData(subject, field string) []byte {
+	return []byte(subject + "\x00" + field)
+}
+
+// walkPII calls fn for every string field tagged `pii:"true"` in the struct
+// rv, descending into nested structs, with the field's JSON name.
+func walkPII(rv reflect.Value, fn func(field reflect.Value, name string) error) error {
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		field := rv.Field(i)
+
+		if sf.Tag.Get("pii") == "true" {
+			if field.Kind() != reflect.String {
+				return fmt.Errorf("pii field %s must be a string", sf.Name)
+			}
+			if err := fn(field, jsonName(sf)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		switch {
+		case field.Kind() == reflect.Struct:
+			if err := walkPII(field, fn); err != nil {
+				return err
+			}
+		case field.Kind() == reflect.Pointer && !field.IsNil() && field.Elem().Kind() == reflect.Struct && hasPII(field.Elem().Type()):
+			// Copy before changing, the pointer is shared with the caller.
+			copied := reflect.New(field.Elem().Type())
+			copied.Elem().Set(field.Elem())
+			if err := walkPII(copied.Elem(), fn); err != nil {
+				return err
+			}
+			field.Set(copied)
+		}
+	}
+
+	return nil
+}
+
+func hasPII(t reflect.Type) bool {
+	return hasPIIFields(t, map[reflect.Type]bool{})
+}
+
+func hasPIIFields(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if seen[t] {
+		return false
+	}
+	seen[t] = true
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Tag.Get("pii") == "true" {
+			return true
+		}
+
+		ft := sf.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Struct && hasPIIFields(ft, seen) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func jsonName(sf reflect.StructField) string {
+	name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return sf.Name
+	}
+
+	return name
+}
@@ -0,0 +1,161 @@
+package pii
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type testPayload struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name" pii:"true"`
+	Email string `json:"email" pii:"true"`
+}
+
+const testSubject = "0d5f8a1e-3c3b-4c41-9a3e-7f1d2b6c9e10"
+
+func newTestKMS(t *testing.T) *LocalKMS {
+	t.Helper()
+
+	master := make([]byte, 32)
+	if _, err := rand.Read(master); err != nil {
+		t.Fatal(err)
+	}
+
+	kms, err := NewLocalKMS(&LocalKMSOpts{MasterKey: base64.StdEncoding.EncodeToString(master), Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return kms
+}
+
+// sharedKeyKMS hands every subject the same key, so that only the additional
+// data tells their ciphertexts apart.
+type sharedKeyKMS struct{ key []byte }
+
+func (k sharedKeyKMS) DataKey(context.Context, string) ([]byte, error)       { return k.key, nil }
+func (k sharedKeyKMS) LookupDataKey(context.Context, string) ([]byte, error) { return k.key, nil }
+func (k sharedKeyKMS) Shred(context.Context, string) error                   { return nil }
+
+func encryptTestPayload(t *testing.T, kms KMS, subject string) testPayload {
+	t.Helper()
+
+	original := testPayload{ID: 1, Name: "Jane", Email: "jane@example.com"}
+	encrypted, err := Encrypt(context.Background(), kms, subject, original)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return encrypted.(testPayload)
+}
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	kms := newTestKMS(t)
+	payload := encryptTestPayload(t, kms, testSubject)
+
+	if payload.ID != 1 {
+		t.Errorf("id = %d, want it left alone", payload.ID)
+	}
+	for _, v := range []string{payload.Name, payload.Email} {
+		if !strings.HasPrefix(v, ciphertextPrefix) || strings.Contains(v, "Jane") || strings.Contains(v, "jane") {
+			t.Errorf("field %q is not encrypted", v)
+		}
+	}
+
+	if err := Decrypt(context.Background(), kms, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if want := (testPayload{ID: 1, Name: "Jane", Email: "jane@example.com"}); payload != want {
+		t.Errorf("got %+v, want %+v", payload, want)
+	}
+}
+
+func TestDecryptDecodedJSON(t *testing.T) {
+	kms := newTestKMS(t)
+
+	body, err := json.Marshal(encryptTestPayload(t, kms, testSubject))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]any
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Decrypt(context.Background(), kms, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded["name"] != "Jane" || decoded["email"] != "jane@example.com" {
+		t.Errorf("got %v, want the plaintext name and email", decoded)
+	}
+}
+
+func TestCiphertextIsBoundToField(t *testing.T) {
+	kms := newTestKMS(t)
+	payload := encryptTestPayload(t, kms, testSubject)
+
+	payload.Name, payload.Email = payload.Email, payload.Name
+
+	if err := Decrypt(context.Background(), kms, &payload); !errors.Is(err, ErrInvalidCiphertext) {
+		t.Fatalf("got error %v, want %v", err, ErrInvalidCiphertext)
+	}
+}
+
+func TestCiphertextIsBoundToSubject(t *testing.T) {
+	kms := sharedKeyKMS{key: make([]byte, 32)}
+	payload := encryptTestPayload(t, kms, testSubject)
+
+	// Relabel the ciphertext as another subject's; the key still fits.
+	other := base64.RawURLEncoding.EncodeToString([]byte("another-subject"))
+	_, rest, _ := strings.Cut(strings.TrimPrefix(payload.Email, ciphertextPrefix), ":")
+	payload.Email = ciphertextPrefix + other + ":" + rest
+
+	if err := Decrypt(context.Background(), kms, &payload); !errors.Is(err, ErrInvalidCiphertext) {
+		t.Fatalf("got error %v, want %v", err, ErrInvalidCiphertext)
+	}
+}
+
+func TestDecryptRejectsTamperedCiphertext(t *testing.T) {
+	kms := newTestKMS(t)
+	payload := encryptTestPayload(t, kms, testSubject)
+
+	encodedSubject, encoded, _ := strings.Cut(strings.TrimPrefix(payload.Name, ciphertextPrefix), ":")
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed[len(sealed)-1] ^= 1
+	payload.Name = ciphertextPrefix + encodedSubject + ":" + base64.StdEncoding.EncodeToString(sealed)
+
+	if err := Decrypt(context.Background(), kms, &payload); !errors.Is(err, ErrInvalidCiphertext) {
+		t.Fatalf("got error %v, want %v", err, ErrInvalidCiphertext)
+	}
+}
+
+func TestDecryptAfterShred(t *testing.T) {
+	kms := newTestKMS(t)
+	payload := encryptTestPayload(t, kms, testSubject)
+	kept := encryptTestPayload(t, kms, "another-subject")
+
+	if err := kms.Shred(context.Background(), testSubject); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Decrypt(context.Background(), kms, &payload); !errors.Is(err, ErrSubjectForgotten) {
+		t.Fatalf("got error %v, want %v", err, ErrSubjectForgotten)
+	}
+	if err := Decrypt(context.Background(), kms, &kept); err != nil {
+		t.Fatalf("other subjects must stay readable: %v", err)
+	}
+}
+
+func TestEncryptNeedsSubject(t *testing.T) {
+	if _, err := Encrypt(context.Background(), newTestKMS(t), "", testPayload{Name: "Jane"}); err == nil {
+		t.Fatal("encrypted pii without a subject")
+	}
+}
@@ -0,0 +1,169 @@
+// Package pii encrypts personal data in event payloads with per-subject data
+// keys, so that deleting a subject's key makes every event about them
+// unreadable, wherever it was copied to.
+package pii
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+var (
+	ErrInvalidMasterKey = errors.New("pii master key must be 32 bytes, base64 encoded")
+	// ErrSubjectForgotten is returned for data of a subject whose key was
+	// deleted. The data can never be decrypted again.
+	ErrSubjectForgotten = errors.New("pii data key of subject was deleted")
+)
+
+// KMS hands out per-subject data keys.
+type KMS interface {
+	// DataKey returns the subject's data key, creating it on first use.
+	DataKey(ctx context.Context, subject string) ([]byte, error)
+	// LookupDataKey returns the subject's existing data key, or
+	// ErrSubjectForgotten.
+	LookupDataKey(ctx context.Context, subject string) ([]byte, error)
+	// Shred deletes the subject's data key.
+	Shred(ctx context.Context, subject string) error
+}
+
+type LocalKMSOpts struct {
+	// MasterKey is the base64 encoded 32 byte key data keys are wrapped with.
+	MasterKey string
+	Dir       string
+}
+
+// LocalKMS is a stand-in for a managed KMS. Data keys are stored in Dir, one
+// file per subject, wrapped with the master key; the master key never leaves
+// the process. Processes sharing Dir and the master key share the keys.
+type LocalKMS struct {
+	master cipher.AEAD
+	dir    string
+}
+
+func NewLocalKMS(opts *LocalKMSOpts) (*LocalKMS, error) {
+	key, err := base64.StdEncoding.DecodeString(opts.MasterKey)
+	if err != nil || len(key) != 32 {
+		return nil, ErrInvalidMasterKey
+	}
+
+	master, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(opts.Dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	return &LocalKMS{master: master, dir: opts.Dir}, nil
+}
+
+func (k *LocalKMS) DataKey(ctx context.Context, subject string) ([]byte, error) {
+	key, err := k.LookupDataKey(ctx, subject)
+	if !errors.Is(err, ErrSubjectForgotten) {
+		return key, err
+	}
+
+	key = make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+
+	wrapped, err := seal(k.master, key, []byte(subject))
+	if err != nil {
+		return nil, err
+	}
+
+	// Another process may create the key at the same time; whichever file
+	// lands first is the key everybody uses.
+	f, err := os.OpenFile(k.path(subject), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if errors.Is(err, os.ErrExist) {
+		return k.LookupDataKey(ctx, subject)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := f.WriteString(base64.StdEncoding.EncodeToString(wrapped)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return key, f.Close()
+}
+
+func (k *LocalKMS) LookupDataKey(ctx context.Context, subject string) ([]byte, error) {
+	raw, err := os.ReadFile(k.path(subject))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrSubjectForgotten, subject)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(string(raw))
+	if err != nil {
+		return nil, fmt.Errorf("data key of subject %s is corrupt: %w", subject, err)
+	}
+
+	return open(k.master, wrapped, []byte(subject))
+}
+
+func (k *LocalKMS) Shred(ctx context.Context, subject string) error {
+	err := os.Remove(k.path(subject))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// path names key files by a hash of the subject, which may contain
+// characters that are not valid in file names.
+func (k *LocalKMS) path(subject string) string {
+	sum := sha256.Sum256([]byte(subject))
+	return filepath.Join(k.dir, hex.EncodeToString(sum[:])+".key")
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext with a random nonce, which it prepends.
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, sealed, additionalData []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("pii ciphertext is too short")
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
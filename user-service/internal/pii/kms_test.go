@@ -0,0 +1,55 @@
+package pii
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestLocalKMSRejectsBadMasterKey(t *testing.T) {
+	for _, key := range []string{"", "not base64!", "c2hvcnQ="} {
+		if _, err := NewLocalKMS(&LocalKMSOpts{MasterKey: key, Dir: t.TempDir()}); !errors.Is(err, ErrInvalidMasterKey) {
+			t.Errorf("master key %q: got error %v, want %v", key, err, ErrInvalidMasterKey)
+		}
+	}
+}
+
+func TestLocalKMSDataKeys(t *testing.T) {
+	kms := newTestKMS(t)
+	ctx := context.Background()
+
+	if _, err := kms.LookupDataKey(ctx, testSubject); !errors.Is(err, ErrSubjectForgotten) {
+		t.Fatalf("got error %v before the key was created, want %v", err, ErrSubjectForgotten)
+	}
+
+	key, err := kms.DataKey(ctx, testSubject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := kms.DataKey(ctx, testSubject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, again) {
+		t.Error("data key changed between calls")
+	}
+
+	other, err := kms.DataKey(ctx, "another-subject")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(key, other) {
+		t.Error("subjects share a data key")
+	}
+
+	if err := kms.Shred(ctx, testSubject); err != nil {
+		t.Fatal(err)
+	}
+	if err := kms.Shred(ctx, testSubject); err != nil {
+		t.Fatalf("shredding twice: %v", err)
+	}
+	if _, err := kms.LookupDataKey(ctx, testSubject); !errors.Is(err, ErrSubjectForgotten) {
+		t.Fatalf("got error %v after shredding, want %v", err, ErrSubjectForgotten)
+	}
+}
@@ -0,0 +1,277 @@
+package rabbitmq
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	amqplib "github.com/rabbitmq/amqp091-go"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/config"
+)
+
+const (
+	HeaderReplayedAt = "x-replayed-at"
+
+	archiveFilePrefix = "archive-"
+	archiveFileExt    = ".ndjson"
+	// archiveTimeFormat sorts lexically in time order.
+	archiveTimeFormat = "20060102T150405.000000000Z"
+)
+
+// ArchiveRecord is one published message as written to the archive, one JSON
+// object per line.
+type ArchiveRecord struct {
+	ArchivedAt time.Time          `json:"archived_at"`
+	Exchange   string             `json:"exchange"`
+	RoutingKey string             `json:"routing_key"`
+	Publishing amqplib.Publishing `json:"publishing"`
+}
+
+// archive tees published messages, exactly as sent and with their headers,
+// into NDJSON files. A new file is started when the current one reaches
+// MaxFileBytes or gets older than RotateInterval, and the oldest files beyond
+// MaxFiles are deleted.
+type archive struct {
+	config *config.AMQPArchive
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+func newArchive(cfg *config.AMQPArchive) (*archive, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	a := &archive{config: cfg}
+	if err := a.rotate(time.Now()); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (a *archive) Append(exchange, key string, msg amqplib.Publishing) error {
+	now := time.Now().UTC()
+
+	line, err := json.Marshal(&ArchiveRecord{
+		ArchivedAt: now,
+		Exchange:   exchange,
+		RoutingKey: key,
+		Publishing: msg,
+	})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file == nil {
+		return os.ErrClosed
+	}
+
+	full := a.config.MaxFileBytes > 0 && a.size > 0 && a.size+int64(len(line)) > a.config.MaxFileBytes
+	stale := a.config.RotateInterval > 0 && now.Sub(a.openedAt) >= a.config.RotateInterval
+	if full || stale {
+		if err := a.rotate(now); err != nil {
+			return err
+		}
+	}
+
+	n, err := a.file.Write(line)
+	a.size += int64(n)
+
+	return err
+}
+
+func (a *archive) rotate(now time.Time) error {
+	if a.file != nil {
+		if err := a.file.Close(); err != nil {
+			return err
+		}
+	}
+
+	name := filepath.Join(a.config.Dir, archiveFilePrefix+now.UTC().Format(archiveTimeFormat)+archiveFileExt)
+
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		a.file = nil
+		return err
+	}
+
+	a.file = f
+	a.size = 0
+	a.openedAt = now
+
+	return a.prune()
+}
+
+func (a *archive) prune() error {
+	if a.config.MaxFiles <= 0 {
+		return nil
+	}
+
+	files, err := ArchiveFiles(a.config.Dir)
+	if err != nil {
+		return err
+	}
+
+	for len(files) > a.config.MaxFiles {
+		if err := os.Remove(files[0]); err != nil {
+			return err
+		}
+		files = files[1:]
+	}
+
+	return nil
+}
+
+func (a *archive) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file == nil {
+		return nil
+	}
+
+	err := a.file.Close()
+	a.file = nil
+
+	return err
+}
+
+// ArchiveFiles returns the archive files in dir, oldest first.
+func ArchiveFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), archiveFilePrefix) && strings.HasSuffix(e.Name(), archiveFileExt) {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// ArchiveFilter selects archived messages; zero fields match every message.
+type ArchiveFilter struct {
+	// From and To bound when messages were archived, From inclusive and To
+	// exclusive.
+	From time.Time
+	To   time.Time
+	// Pattern is a path.Match glob over the event pattern, e.g. user.*. A
+	// malformed one matches nothing.
+	Pattern string
+}
+
+func (f *ArchiveFilter) Match(rec *ArchiveRecord) bool {
+	if !f.From.IsZero() && rec.ArchivedAt.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !rec.ArchivedAt.Before(f.To) {
+		return false
+	}
+	if f.Pattern != "" {
+		ok, _ := path.Match(f.Pattern, rec.Publishing.Type)
+		return ok
+	}
+
+	return true
+}
+
+// ErrStopReading stops ReadArchive early without failing.
+var ErrStopReading = errors.New("stop reading archive")
+
+// ReadArchive calls fn for every record in the archive in dir, oldest first.
+// A line left incomplete by a crash mid-write is skipped.
+func ReadArchive(dir string, fn func(rec *ArchiveRecord) error) error {
+	files, err := ArchiveFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range files {
+		if err := readArchiveFile(name, fn); err != nil {
+			if errors.Is(err, ErrStopReading) {
+				return nil
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readArchiveFile(name string, fn func(rec *ArchiveRecord) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16<<20)
+
+	for line := 1; scanner.Scan(); line++ {
+		var rec ArchiveRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// Only the last line can be torn by a crash mid-write.
+			if !scanner.Scan() {
+				return nil
+			}
+			return fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+		rec.Publishing.Headers = archivedTable(rec.Publishing.Headers)
+
+		if err := fn(&rec); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// archivedTable restores header values JSON cannot tell apart to types AMQP
+// tables accept: whole numbers become integers and objects become tables.
+func archivedTable(t amqplib.Table) amqplib.Table {
+	for k, v := range t {
+		t[k] = archivedValue(v)
+	}
+
+	return t
+}
+
+func archivedValue(v any) any {
+	switch v := v.(type) {
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v)
+		}
+	case map[string]any:
+		return archivedTable(amqplib.Table(v))
+	case []any:
+		for i := range v {
+			v[i] = archivedValue(v[i])
+		}
+	}
+
+	return v
+}
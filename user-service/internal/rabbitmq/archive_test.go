@@ -0,0 +1,139 @@
+package rabbitmq
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	amqplib "github.com/rabbitmq/amqp091-go"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/config"
+)
+
+func TestArchiveFilter(t *testing.T) {
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	rec := &ArchiveRecord{ArchivedAt: at, Publishing: amqplib.Publishing{Type: "user.created"}}
+
+	tests := []struct {
+		name   string
+		filter ArchiveFilter
+		want   bool
+	}{
+		{name: "no filter", want: true},
+		{name: "from is inclusive", filter: ArchiveFilter{From: at}, want: true},
+		{name: "before from", filter: ArchiveFilter{From: at.Add(time.Second)}},
+		{name: "to is exclusive", filter: ArchiveFilter{To: at}},
+		{name: "before to", filter: ArchiveFilter{To: at.Add(time.Second)}, want: true},
+		{name: "within range", filter: ArchiveFilter{From: at.Add(-time.Hour), To: at.Add(time.Hour)}, want: true},
+		{name: "matching glob", filter: ArchiveFilter{Pattern: "user.*"}, want: true},
+		{name: "exact pattern", filter: ArchiveFilter{Pattern: "user.created"}, want: true},
+		{name: "other pattern", filter: ArchiveFilter{Pattern: "order.*"}},
+		{name: "glob does not cross dots", filter: ArchiveFilter{Pattern: "user"}},
+		{name: "malformed glob", filter: ArchiveFilter{Pattern: "user.["}},
+		{name: "pattern outside range", filter: ArchiveFilter{To: at, Pattern: "user.*"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Match(rec); got != tt.want {
+				t.Errorf("Match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+
+	a, err := newArchive(&config.AMQPArchive{Dir: dir, MaxFileBytes: 512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 5 {
+		err := a.Append("", "notification-service", amqplib.Publishing{
+			Headers:   amqplib.Table{HeaderSchemaVersion: 1, "nested": amqplib.Table{"count": int64(i)}},
+			MessageId: fmt.Sprint(i),
+			Type:      "user.created",
+			Body:      []byte(`{"pattern":"user.created","data":{}}`),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := ArchiveFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) < 2 {
+		t.Fatalf("got %d archive files, want the archive rotated by size", len(files))
+	}
+
+	// A crash mid-write leaves the last line incomplete.
+	f, err := os.OpenFile(files[len(files)-1], os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"archived_at":"2026-`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	var ids []string
+	err = ReadArchive(dir, func(rec *ArchiveRecord) error {
+		ids = append(ids, rec.Publishing.MessageId)
+		if rec.RoutingKey != "notification-service" {
+			t.Errorf("routing key %q", rec.RoutingKey)
+		}
+		// JSON numbers are read back as the integers AMQP tables take.
+		if v := rec.Publishing.Headers[HeaderSchemaVersion]; v != int64(1) {
+			t.Errorf("schema version header %#v, want int64 1", v)
+		}
+		if _, ok := rec.Publishing.Headers["nested"].(amqplib.Table); !ok {
+			t.Errorf("nested header %#v, want a table", rec.Publishing.Headers["nested"])
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(ids) != "[0 1 2 3 4]" {
+		t.Errorf("read %v, want every message in order", ids)
+	}
+
+	var read int
+	err = ReadArchive(dir, func(rec *ArchiveRecord) error {
+		read++
+		return ErrStopReading
+	})
+	if err != nil || read != 1 {
+		t.Errorf("stopping early: read %d records, error %v", read, err)
+	}
+}
+
+func TestArchivePrunesOldestFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	a, err := newArchive(&config.AMQPArchive{Dir: dir, MaxFileBytes: 1, MaxFiles: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	for i := range 4 {
+		if err := a.Append("", "orders", amqplib.Publishing{MessageId: fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := ArchiveFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("kept %d files, want 2", len(files))
+	}
+}
@@ -0,0 +1,298 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	amqplib "github.com/rabbitmq/amqp091-go"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PublishBatch publishes messages to queue on one channel without waiting for
+// each confirm before sending the next, then waits for the confirms. It
+// returns the error of each message, nil for those the broker confirmed. The
+// publish timeout bounds the wait for each confirm rather than the whole
+// batch, so a large batch does not time out while confirms keep arriving.
+// opts apply to every message.
+func (b *rabbitmq) PublishBatch(ctx context.Context, queue string, messages []*MessageType, opts ...PublishOption) []error {
+	o := newPublishOptions(opts)
+	errs := make([]error, len(messages))
+
+	if err := b.acquire(); err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+	defer b.release()
+
+	spans := make([]trace.Span, len(messages))
+	publishings := make([]amqplib.Publishing, len(messages))
+	for i, message := range messages {
+		_, spans[i], publishings[i], errs[i] = b.prepare(ctx, queue, message, o)
+	}
+
+	if o.delay > 0 {
+		b.sendDelayedBatch(ctx, queue, o.delay, publishings, errs)
+	} else {
+		b.sendBatch(ctx, "", queue, publishings, errs)
+	}
+
+	failed := 0
+	for i, span := range spans {
+		if errs[i] != nil {
+			failed++
+			span.RecordError(errs[i])
+			span.SetStatus(codes.Error, errs[i].Error())
+		} else {
+			b.archivePublished("", queue, publishings[i])
+		}
+		span.End()
+	}
+
+	b.logger.Info("AMQP message batch sent",
+		logger.Field{Key: "queue", Value: queue},
+		logger.Field{Key: "messages", Value: len(messages)},
+		logger.Field{Key: "failed", Value: failed},
+	)
+
+	return errs
+}
+
+// sendBatch is send for a batch. Messages that already have an error are
+// skipped, and the others get the error of their publish.
+func (b *rabbitmq) sendBatch(ctx context.Context, exchange, key string, msgs []amqplib.Publishing, errs []error) {
+	if b.spool != nil && (b.state.Current() != StateConnected || b.spool.Depth() > 0) {
+		for i := range msgs {
+			if errs[i] == nil {
+				errs[i] = b.enqueue(exchange, key, msgs[i])
+			}
+		}
+		return
+	}
+
+	pending := make([]bool, len(msgs))
+	for i := range msgs {
+		pending[i] = errs[i] == nil
+	}
+
+	b.publishBatch(ctx, exchange, key, msgs, errs)
+
+	if b.spool == nil {
+		return
+	}
+	for i := range msgs {
+		if pending[i] && (errors.Is(errs[i], ErrNotConnected) || errors.Is(errs[i], amqplib.ErrClosed)) {
+			errs[i] = b.enqueue(exchange, key, msgs[i])
+		}
+	}
+}
+
+// sendDelayedBatch is sendDelayed for a batch. The messages share a delay,
+// and so a route.
+func (b *rabbitmq) sendDelayedBatch(ctx context.Context, queue string, delay time.Duration, msgs []amqplib.Publishing, errs []error) {
+	var exchange, key string
+	for i := range msgs {
+		if errs[i] != nil {
+			continue
+		}
+		if key == "" {
+			exchange, key, errs[i] = b.delayRoute(ctx, queue, delay, &msgs[i])
+			if errs[i] != nil {
+				for j := i + 1; j < len(errs); j++ {
+					if errs[j] == nil {
+						errs[j] = errs[i]
+					}
+				}
+				return
+			}
+		} else {
+			holdBack(&msgs[i], delay, b.usePlugin(ctx))
+		}
+	}
+
+	if key != "" {
+		b.publishBatch(ctx, exchange, key, msgs, errs)
+	}
+}
+
+func (b *rabbitmq) publishBatch(ctx context.Context, exchange, key string, msgs []amqplib.Publishing, errs []error) {
+	fail := func(err error) {
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = err
+			}
+		}
+	}
+
+	pool, channel, err := b.checkout(ctx)
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer pool.Release()
+
+	if exchange == "" && !strings.HasPrefix(key, "amq.") {
+		if _, err := b.declareQueue(key, channel.Channel); err != nil {
+			pool.Put(channel)
+			fail(err)
+			return
+		}
+	}
+
+	confirms := make([]*amqplib.DeferredConfirmation, len(msgs))
+	for i := range msgs {
+		if errs[i] == nil {
+			confirms[i], errs[i] = channel.PublishWithDeferredConfirmWithContext(ctx, exchange, key, false, false, msgs[i])
+		}
+	}
+	pool.Put(channel)
+
+	for i, confirm := range confirms {
+		if confirm != nil {
+			errs[i] = b.waitConfirm(ctx, confirm)
+		}
+	}
+}
+
+func (b *rabbitmq) waitConfirm(ctx context.Context, confirm *amqplib.DeferredConfirmation) error {
+	ctx, cancel := context.WithTimeout(ctx, b.config.PublishTimeout)
+	defer cancel()
+
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return ErrPublishNacked
+	}
+
+	return nil
+}
+
+// batcher coalesces the messages published to the same queue within a window
+// into one batch, so that concurrent publishers share a channel checkout and
+// their confirms are pipelined.
+type batcher struct {
+	window  time.Duration
+	maxSize int
+	send    func(key string, msgs []amqplib.Publishing) []error
+
+	mu      sync.Mutex
+	pending map[string]*pendingBatch
+}
+
+type pendingBatch struct {
+	msgs    []amqplib.Publishing
+	results []chan error
+	done    []func()
+	timer   *time.Timer
+}
+
+func newBatcher(window time.Duration, maxSize int, send func(key string, msgs []amqplib.Publishing) []error) *batcher {
+	return &batcher{
+		window:  window,
+		maxSize: maxSize,
+		send:    send,
+		pending: map[string]*pendingBatch{},
+	}
+}
+
+// publish adds msg to the batch for key and waits for its result. The batch
+// is sent once the window has passed since its first message or it is full.
+// If ctx ends before then, msg is withdrawn from the batch and ctx.Err() is
+// returned; once the batch is being sent, its result is waited for. done is
+// called once msg is withdrawn or the batch holding it has been sent.
+func (bt *batcher) publish(ctx context.Context, key string, msg amqplib.Publishing, done func()) error {
+	result := make(chan error, 1)
+
+	bt.mu.Lock()
+	batch, ok := bt.pending[key]
+	if !ok {
+		batch = &pendingBatch{}
+		bt.pending[key] = batch
+		batch.timer = time.AfterFunc(bt.window, func() {
+			if bt.take(key, batch) {
+				bt.run(key, batch)
+			}
+		})
+	}
+	batch.msgs = append(batch.msgs, msg)
+	batch.results = append(batch.results, result)
+	batch.done = append(batch.done, done)
+
+	full := bt.maxSize > 0 && len(batch.msgs) >= bt.maxSize
+	if full {
+		delete(bt.pending, key)
+		batch.timer.Stop()
+	}
+	bt.mu.Unlock()
+
+	if full {
+		go bt.run(key, batch)
+	}
+
+	select {
+	case <-ctx.Done():
+		if bt.withdraw(key, batch, result) {
+			done()
+			return ctx.Err()
+		}
+		return <-result
+	case err := <-result:
+		return err
+	}
+}
+
+// withdraw removes the message waiting on result from batch, unless the batch
+// was already taken to be sent.
+func (bt *batcher) withdraw(key string, batch *pendingBatch, result chan error) bool {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	if bt.pending[key] != batch {
+		return false
+	}
+
+	i := slices.Index(batch.results, result)
+	batch.msgs = slices.Delete(batch.msgs, i, i+1)
+	batch.results = slices.Delete(batch.results, i, i+1)
+	batch.done = slices.Delete(batch.done, i, i+1)
+
+	if len(batch.msgs) == 0 {
+		batch.timer.Stop()
+		delete(bt.pending, key)
+	}
+
+	return true
+}
+
+// take removes batch from the pending ones, unless it was already sent for
+// being full.
+func (bt *batcher) take(key string, batch *pendingBatch) bool {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	if bt.pending[key] != batch {
+		return false
+	}
+	delete(bt.pending, key)
+
+	return true
+}
+
+func (bt *batcher) run(key string, batch *pendingBatch) {
+	publishBatchSize.Observe(float64(len(batch.msgs)))
+
+	errs := bt.send(key, batch.msgs)
+	for i, result := range batch.results {
+		result <- errs[i]
+		batch.done[i]()
+	}
+}
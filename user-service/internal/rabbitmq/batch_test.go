@@ -0,0 +1,78 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	amqplib "github.com/rabbitmq/amqp091-go"
+)
+
+func TestBatcherWithdrawsCancelledMessage(t *testing.T) {
+	sent := make(chan []amqplib.Publishing, 1)
+	bt := newBatcher(50*time.Millisecond, 0, func(key string, msgs []amqplib.Publishing) []error {
+		sent <- msgs
+		return make([]error, len(msgs))
+	})
+
+	var done atomic.Int32
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan error, 1)
+	go func() {
+		cancelled <- bt.publish(ctx, "orders", amqplib.Publishing{MessageId: "cancelled"}, func() { done.Add(1) })
+	}()
+	kept := make(chan error, 1)
+	go func() {
+		kept <- bt.publish(context.Background(), "orders", amqplib.Publishing{MessageId: "kept"}, func() { done.Add(1) })
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	if err := <-cancelled; !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+	if err := <-kept; err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := <-sent
+	if len(msgs) != 1 || msgs[0].MessageId != "kept" {
+		t.Errorf("sent %+v, want only the kept message", msgs)
+	}
+	if done.Load() != 2 {
+		t.Errorf("done called %d times, want 2", done.Load())
+	}
+}
+
+func TestBatcherWaitsForBatchBeingSent(t *testing.T) {
+	sending := make(chan struct{})
+	release := make(chan struct{})
+	bt := newBatcher(time.Millisecond, 0, func(key string, msgs []amqplib.Publishing) []error {
+		close(sending)
+		<-release
+		return make([]error, len(msgs))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		result <- bt.publish(ctx, "orders", amqplib.Publishing{}, func() {})
+	}()
+
+	<-sending
+	cancel()
+
+	select {
+	case err := <-result:
+		t.Fatalf("returned %v while the batch was being sent", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-result; err != nil {
+		t.Errorf("got %v, want the batch result", err)
+	}
+}
@@ -0,0 +1,101 @@
+package rabbitmq
+
+import (
+	"encoding/json"
+	"time"
+
+	amqplib "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	EventFormatNest                  = "nest"
+	EventFormatCloudEventsBinary     = "cloudevents-binary"
+	EventFormatCloudEventsStructured = "cloudevents-structured"
+
+	cloudEventsSpecVersion  = "1.0"
+	cloudEventsContentType  = "application/cloudevents+json"
+	cloudEventsHeaderPrefix = "cloudEvents_"
+)
+
+// cloudEvent is the structured-mode JSON representation of a CloudEvents 1.0
+// event. Envelope fields without a CloudEvents attribute are carried as
+// extension attributes.
+type cloudEvent struct {
+	SpecVersion     string    `json:"specversion"`
+	ID              string    `json:"id"`
+	Source          string    `json:"source"`
+	Type            string    `json:"type"`
+	Subject         string    `json:"subject,omitempty"`
+	Time            time.Time `json:"time"`
+	DataContentType string    `json:"datacontenttype"`
+	Data            any       `json:"data"`
+	CorrelationID   string    `json:"correlationid,omitempty"`
+	CausationID     string    `json:"causationid,omitempty"`
+	SchemaVersion   int       `json:"schemaversion,omitempty"`
+}
+
+func newCloudEvent(message *MessageType) *cloudEvent {
+	return &cloudEvent{
+		SpecVersion:     cloudEventsSpecVersion,
+		ID:              message.MessageID,
+		Source:          message.Producer,
+		Type:            message.Pattern,
+		Subject:         message.Subject,
+		Time:            message.OccurredAt,
+		DataContentType: "application/json",
+		Data:            message.Data,
+		CorrelationID:   message.CorrelationID,
+		CausationID:     message.CausationID,
+		SchemaVersion:   message.SchemaVersion,
+	}
+}
+
+// encodeCloudEventStructured puts the whole event, attributes and data, in
+// the body. Structured mode is always JSON.
+func encodeCloudEventStructured(message *MessageType, publishing *amqplib.Publishing) error {
+	body, err := json.Marshal(newCloudEvent(message))
+	if err != nil {
+		return err
+	}
+
+	publishing.ContentType = cloudEventsContentType
+	publishing.Body = body
+
+	return nil
+}
+
+// encodeCloudEventBinary puts only the data in the body and maps every other
+// attribute to a "cloudEvents_"-prefixed application property, as described
+// by the CloudEvents AMQP protocol binding.
+func encodeCloudEventBinary(message *MessageType, codec Codec, publishing *amqplib.Publishing) error {
+	event := newCloudEvent(message)
+	event.DataContentType = codec.ContentType()
+
+	body, err := codec.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+
+	headers := map[string]any{
+		"specversion":   event.SpecVersion,
+		"id":            event.ID,
+		"source":        event.Source,
+		"type":          event.Type,
+		"time":          event.Time.Format(time.RFC3339Nano),
+		"correlationid": event.CorrelationID,
+		"causationid":   event.CausationID,
+		"subject":       event.Subject,
+		"schemaversion": event.SchemaVersion,
+	}
+	for k, v := range headers {
+		if s, ok := v.(string); ok && s == "" {
+			continue
+		}
+		publishing.Headers[cloudEventsHeaderPrefix+k] = v
+	}
+
+	publishing.ContentType = event.DataContentType
+	publishing.Body = body
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package rabbitmq
+
+import (
+	"testing"
+	"time"
+
+	amqplib "github.com/rabbitmq/amqp091-go"
+)
+
+func TestEncodeCloudEventBinaryHeaders(t *testing.T) {
+	message := &MessageType{
+		Pattern:       "user.created",
+		Data:          map[string]int{"id": 1},
+		MessageID:     "42",
+		Producer:      "user-service",
+		OccurredAt:    time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+		SchemaVersion: 1,
+	}
+	publishing := amqplib.Publishing{Headers: amqplib.Table{}}
+
+	if err := encodeCloudEventBinary(message, JSONCodec{}, &publishing); err != nil {
+		t.Fatal(err)
+	}
+
+	// AMQP application property names take the cloudEvents_ prefix.
+	want := amqplib.Table{
+		"cloudEvents_specversion":   "1.0",
+		"cloudEvents_id":            "42",
+		"cloudEvents_source":        "user-service",
+		"cloudEvents_type":          "user.created",
+		"cloudEvents_time":          "2026-03-01T12:00:00Z",
+		"cloudEvents_schemaversion": 1,
+	}
+	if len(publishing.Headers) != len(want) {
+		t.Errorf("got headers %v, want %v", publishing.Headers, want)
+	}
+	for k, v := range want {
+		if publishing.Headers[k] != v {
+			t.Errorf("header %s = %v, want %v", k, publishing.Headers[k], v)
+		}
+	}
+	if string(publishing.Body) != `{"id":1}` || publishing.ContentType != ContentTypeJSON {
+		t.Errorf("got body %s with content type %q, want only the data", publishing.Body, publishing.ContentType)
+	}
+
+	d := &Delivery{Delivery: amqplib.Delivery{Headers: publishing.Headers, Body: publishing.Body}}
+	if pattern := d.Pattern(); pattern != "user.created" {
+		t.Errorf("delivery pattern %q, want it read from cloudEvents_type", pattern)
+	}
+}
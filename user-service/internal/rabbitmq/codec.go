@@ -0,0 +1,143 @@
+package rabbitmq
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	ContentTypeJSON        = "application/json"
+	ContentTypeProtobuf    = "application/x-protobuf"
+	ContentTypeMessagePack = "application/msgpack"
+
+	CodecJSON        = "json"
+	CodecProtobuf    = "protobuf"
+	CodecMessagePack = "msgpack"
+)
+
+var (
+	ErrUnsupportedContentType = errors.New("amqp content type is not supported")
+	ErrNotProtoMessage        = errors.New("protobuf codec requires a proto.Message")
+)
+
+// Codec encodes message bodies. The content type it reports is set on every
+// message it encodes and is how consumers pick the codec to decode with.
+type Codec interface {
+	Name() string
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// dataOnlyCodec is implemented by codecs that cannot represent the envelope,
+// only a schema-bound payload. Their messages carry the envelope solely as
+// AMQP properties.
+type dataOnlyCodec interface {
+	dataOnly()
+}
+
+type JSONCodec struct{}
+
+func (JSONCodec) Name() string        { return CodecJSON }
+func (JSONCodec) ContentType() string { return ContentTypeJSON }
+
+func (JSONCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) Name() string        { return CodecProtobuf }
+func (ProtobufCodec) ContentType() string { return ContentTypeProtobuf }
+func (ProtobufCodec) dataOnly()           {}
+
+func (ProtobufCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%w, got %T", ErrNotProtoMessage, v)
+	}
+
+	return proto.Marshal(m)
+}
+
+func (ProtobufCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%w, got %T", ErrNotProtoMessage, v)
+	}
+
+	return proto.Unmarshal(data, m)
+}
+
+// MessagePackCodec reuses the json struct tags so both encodings share one
+// field naming.
+type MessagePackCodec struct{}
+
+func (MessagePackCodec) Name() string        { return CodecMessagePack }
+func (MessagePackCodec) ContentType() string { return ContentTypeMessagePack }
+
+func (MessagePackCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.SetOmitEmpty(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (MessagePackCodec) Unmarshal(data []byte, v any) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+
+	return dec.Decode(v)
+}
+
+var codecs = []Codec{JSONCodec{}, ProtobufCodec{}, MessagePackCodec{}}
+
+func codecByName(name string) (Codec, error) {
+	for _, c := range codecs {
+		if c.Name() == name {
+			return c, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown amqp codec %q", name)
+}
+
+// codecByContentType resolves the codec for a received message. Messages
+// without a content type, such as those published by NestJS, are JSON.
+func codecByContentType(contentType string) (Codec, error) {
+	if contentType == "" {
+		return JSONCodec{}, nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedContentType, contentType)
+	}
+
+	switch mediaType {
+	case ContentTypeJSON, cloudEventsContentType:
+		return JSONCodec{}, nil
+	case ContentTypeProtobuf, "application/protobuf":
+		return ProtobufCodec{}, nil
+	case ContentTypeMessagePack, "application/x-msgpack":
+		return MessagePackCodec{}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedContentType, contentType)
+}
@@ -0,0 +1,67 @@
+package rabbitmq
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	EncodingGzip = "gzip"
+	EncodingZstd = "zstd"
+)
+
+var ErrUnsupportedContentEncoding = errors.New("amqp content encoding is not supported")
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// compress encodes body with the given content-encoding. An empty encoding
+// returns the body unchanged.
+func compress(encoding string, body []byte) ([]byte, error) {
+	switch encoding {
+	case "":
+		return body, nil
+	case EncodingZstd:
+		return zstdEncoder.EncodeAll(body, make([]byte, 0, len(body))), nil
+	case EncodingGzip:
+		var buf bytes.Buffer
+
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(body); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+
+		return buf.Bytes(), nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedContentEncoding, encoding)
+}
+
+func decompress(encoding string, body []byte) ([]byte, error) {
+	switch encoding {
+	case "", "identity":
+		return body, nil
+	case EncodingZstd:
+		return zstdDecoder.DecodeAll(body, nil)
+	case EncodingGzip:
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+
+		return io.ReadAll(r)
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedContentEncoding, encoding)
+}
@@ -0,0 +1,258 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"mime"
+
+	amqplib "github.com/rabbitmq/amqp091-go"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/eventbus"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// Handler processes one delivery. Returning nil acknowledges the message; an
+// error rejects it without requeueing. Deliveries the handler settled itself
+// are left as they are.
+type Handler func(ctx context.Context, delivery *Delivery) error
+
+type Delivery struct {
+	amqplib.Delivery
+	settled bool
+	acked   bool
+
+	// holdAck makes Ack only record the acknowledgement, which Deduplicate
+	// sends once the message id is recorded.
+	holdAck     bool
+	ackMultiple bool
+}
+
+// Ack, Nack and Reject record that the handler settled the delivery itself,
+// so it is not acknowledged a second time once the handler returns.
+func (d *Delivery) Ack(multiple bool) error {
+	d.settled = true
+	d.acked = true
+	if d.holdAck {
+		d.ackMultiple = multiple
+		return nil
+	}
+	return d.Delivery.Ack(multiple)
+}
+
+func (d *Delivery) Nack(multiple, requeue bool) error {
+	d.settled = true
+	return d.Delivery.Nack(multiple, requeue)
+}
+
+func (d *Delivery) Reject(requeue bool) error {
+	d.settled = true
+	return d.Delivery.Reject(requeue)
+}
+
+// Pattern returns the event pattern from the message type property, falling
+// back to the CloudEvents type or the envelope for messages published by
+// producers that do not set it.
+func (d *Delivery) Pattern() string {
+	if d.Type != "" {
+		return d.Type
+	}
+
+	if t, ok := d.Headers[cloudEventsHeaderPrefix+"type"].(string); ok {
+		return t
+	}
+
+	var envelope struct {
+		Pattern string `json:"pattern"`
+		Type    string `json:"type"`
+	}
+	if err := d.decodeBody(&envelope); err != nil {
+		return ""
+	}
+	if envelope.Pattern != "" {
+		return envelope.Pattern
+	}
+
+	return envelope.Type
+}
+
+// Decode decompresses the body according to its content-encoding and decodes
+// the event data into v with the codec matching its content-type, unwrapping
+// the envelope or structured CloudEvent when there is one.
+func (d *Delivery) Decode(v any) error {
+	body, err := decompress(d.ContentEncoding, d.Body)
+	if err != nil {
+		return err
+	}
+
+	codec, err := codecByContentType(d.ContentType)
+	if err != nil {
+		return err
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(d.ContentType); mediaType == cloudEventsContentType {
+		return json.Unmarshal(body, &cloudEvent{Data: v})
+	}
+
+	if _, ok := codec.(dataOnlyCodec); ok || d.Headers[cloudEventsHeaderPrefix+"specversion"] != nil {
+		return codec.Unmarshal(body, v)
+	}
+
+	return codec.Unmarshal(body, &MessageType{Data: v})
+}
+
+func (d *Delivery) decodeBody(v any) error {
+	body, err := decompress(d.ContentEncoding, d.Body)
+	if err != nil {
+		return err
+	}
+
+	codec, err := codecByContentType(d.ContentType)
+	if err != nil {
+		return err
+	}
+
+	return codec.Unmarshal(body, v)
+}
+
+// Consume delivers messages from queue to handler until ctx is cancelled,
+// subscribing again on a new channel after every reconnect. When signature
+// verification is enabled, handler only receives validly signed messages, and
+// when delivery attempts are limited, messages it keeps failing on are
+// quarantined.
+func (b *rabbitmq) Consume(ctx context.Context, queue string, handler Handler) error {
+	return b.consumeQueue(ctx, queue, b.verifySignatures(queue, b.retryFailures(queue, handler)))
+}
+
+func (b *rabbitmq) consumeQueue(ctx context.Context, queue string, handler Handler) error {
+	states, unsubscribe := b.SubscribeState()
+	defer unsubscribe()
+
+	for {
+		if err := b.waitConnected(ctx, states); err != nil {
+			return err
+		}
+
+		channel, deliveries, err := b.openConsumer(queue)
+		if err != nil {
+			b.logger.Error("AMQP failed to start consumer", logger.Field{Key: "queue", Value: queue}, logger.Field{Key: "error", Value: err.Error()})
+
+			if !sleepContext(ctx, b.config.ConnectionRetryInterval) {
+				return ctx.Err()
+			}
+			continue
+		}
+
+		b.logger.Info("AMQP consumer started", logger.Field{Key: "queue", Value: queue})
+		b.consume(ctx, deliveries, handler)
+
+		if ctx.Err() != nil {
+			_ = channel.Close()
+			return ctx.Err()
+		}
+
+		b.logger.Warn("AMQP consumer stopped, resubscribing", logger.Field{Key: "queue", Value: queue})
+	}
+}
+
+func (b *rabbitmq) waitConnected(ctx context.Context, states <-chan ConnectionState) error {
+	for {
+		switch b.state.Current() {
+		case StateConnected, StateBlocked:
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-states:
+		}
+	}
+}
+
+func (b *rabbitmq) openConsumer(queue string) (*amqplib.Channel, <-chan amqplib.Delivery, error) {
+	b.mu.RLock()
+	conn := b.conn
+	b.mu.RUnlock()
+
+	if conn == nil {
+		return nil, nil, ErrNotConnected
+	}
+
+	channel, err := conn.Channel()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := channel.Qos(b.config.ConsumerPrefetch, 0, false); err != nil {
+		_ = channel.Close()
+		return nil, nil, err
+	}
+
+	if _, err := b.declareQueue(queue, channel); err != nil {
+		_ = channel.Close()
+		return nil, nil, err
+	}
+
+	deliveries, err := channel.Consume(queue, "", false, false, false, false, nil)
+	if err != nil {
+		_ = channel.Close()
+		return nil, nil, err
+	}
+
+	return channel, deliveries, nil
+}
+
+func (b *rabbitmq) consume(ctx context.Context, deliveries <-chan amqplib.Delivery, handler Handler) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case d, ok := <-deliveries:
+			if !ok {
+				return
+			}
+
+			handleDelivery(ctx, b.logger, &Delivery{Delivery: d}, handler)
+		}
+	}
+}
+
+// handleDelivery runs handler within the publisher's trace, with a context that makes
+// messages it publishes correlate to, and be caused by, the delivery.
+func handleDelivery(ctx context.Context, log logger.Logger, d *Delivery, handler Handler) {
+	ctx, span := startConsumeSpan(ctx, d)
+	defer span.End()
+
+	if d.CorrelationId != "" {
+		ctx = eventbus.WithCorrelationID(ctx, d.CorrelationId)
+	}
+	if d.MessageId != "" {
+		ctx = eventbus.WithCausationID(ctx, d.MessageId)
+	}
+
+	err := handler(ctx, d)
+	if d.settled {
+		return
+	}
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		log.Error("AMQP message handler failed",
+			logger.Field{Key: "event", Value: d.Pattern()},
+			logger.Field{Key: "message_id", Value: d.MessageId},
+			logger.Field{Key: "trace_id", Value: traceID(ctx)},
+			logger.Field{Key: "error", Value: err.Error()},
+		)
+
+		if err := d.Nack(false, false); err != nil {
+			log.Error("AMQP failed to reject message", logger.Field{Key: "error", Value: err.Error()})
+		}
+		return
+	}
+
+	if err := d.Ack(false); err != nil {
+		log.Error("AMQP failed to acknowledge message", logger.Field{Key: "error", Value: err.Error()})
+	}
+}
@@ -0,0 +1,205 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	amqplib "github.com/rabbitmq/amqp091-go"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/config"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+)
+
+const (
+	usernameFile = "username"
+	passwordFile = "password"
+)
+
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// CredentialsProvider supplies the credentials the broker is dialed with.
+// They are read at every connect, and whenever Watch signals a change the
+// connection is replaced with one dialed with the new credentials.
+type CredentialsProvider interface {
+	Credentials(ctx context.Context) (*Credentials, error)
+	// Watch returns a channel signalled when the credentials change, until
+	// ctx ends. A nil channel means they never change.
+	Watch(ctx context.Context) <-chan struct{}
+}
+
+// newCredentialsProvider returns the file provider when a credentials
+// directory is configured, and the configured credentials otherwise, taken
+// from AMQP_URI when it is set.
+func newCredentialsProvider(cfg *config.AMQP, log logger.Logger) CredentialsProvider {
+	if cfg.CredentialsDir == "" {
+		c := Credentials{Username: cfg.Username, Password: cfg.Password}
+		if uri, err := amqplib.ParseURI(cfg.URI); cfg.URI != "" && err == nil {
+			c = Credentials{Username: uri.Username, Password: uri.Password}
+		}
+
+		return &staticCredentials{credentials: c}
+	}
+
+	return NewFileCredentialsProvider(&FileCredentialsProviderOpts{
+		Dir:      cfg.CredentialsDir,
+		Interval: cfg.CredentialsPollInterval,
+		Username: cfg.Username,
+		Logger:   log,
+	})
+}
+
+type staticCredentials struct {
+	credentials Credentials
+}
+
+func (s *staticCredentials) Credentials(ctx context.Context) (*Credentials, error) {
+	c := s.credentials
+	return &c, nil
+}
+
+func (s *staticCredentials) Watch(ctx context.Context) <-chan struct{} {
+	return nil
+}
+
+// FileCredentialsProvider reads the credentials from the username and
+// password files of a directory, such as a mounted Kubernetes secret, and
+// polls them for changes. Without a username file the configured username is
+// used.
+type FileCredentialsProvider struct {
+	dir      string
+	interval time.Duration
+	username string
+	logger   logger.Logger
+}
+
+type FileCredentialsProviderOpts struct {
+	Dir      string
+	Interval time.Duration
+	Username string
+	Logger   logger.Logger
+}
+
+func NewFileCredentialsProvider(opts *FileCredentialsProviderOpts) *FileCredentialsProvider {
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	return &FileCredentialsProvider{
+		dir:      opts.Dir,
+		interval: interval,
+		username: opts.Username,
+		logger:   opts.Logger,
+	}
+}
+
+func (p *FileCredentialsProvider) Credentials(ctx context.Context) (*Credentials, error) {
+	password, err := readSecretFile(filepath.Join(p.dir, passwordFile))
+	if err != nil {
+		return nil, err
+	}
+
+	username, err := readSecretFile(filepath.Join(p.dir, usernameFile))
+	if errors.Is(err, os.ErrNotExist) {
+		username = p.username
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &Credentials{Username: username, Password: password}, nil
+}
+
+// Watch polls the files rather than watching for events, since a mounted
+// secret is updated by swapping a symlink to its directory.
+func (p *FileCredentialsProvider) Watch(ctx context.Context) <-chan struct{} {
+	changed := make(chan struct{}, 1)
+
+	go func() {
+		last, _ := p.Credentials(ctx)
+
+		t := time.NewTicker(p.interval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+
+			c, err := p.Credentials(ctx)
+			if err != nil {
+				p.logger.Warn("AMQP failed to read credentials", logger.Field{Key: "dir", Value: p.dir}, logger.Field{Key: "error", Value: err.Error()})
+				continue
+			}
+			if last != nil && *c == *last {
+				continue
+			}
+			last = c
+
+			p.logger.Info("AMQP credentials changed", logger.Field{Key: "dir", Value: p.dir})
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return changed
+}
+
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// ResolveCredentials returns cfg with the credentials read from its
+// credentials directory, or cfg itself when there is none. It is meant for
+// tools that connect once.
+func ResolveCredentials(ctx context.Context, cfg *config.AMQP) (*config.AMQP, error) {
+	if cfg.CredentialsDir == "" {
+		return cfg, nil
+	}
+
+	c, err := NewFileCredentialsProvider(&FileCredentialsProviderOpts{Dir: cfg.CredentialsDir, Username: cfg.Username}).Credentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return withCredentials(cfg, c)
+}
+
+// withCredentials returns a copy of cfg that dials with c, including when the
+// address is given as a URI.
+func withCredentials(cfg *config.AMQP, c *Credentials) (*config.AMQP, error) {
+	out := *cfg
+	out.Username = c.Username
+	out.Password = c.Password
+
+	if cfg.URI != "" {
+		if _, err := amqplib.ParseURI(cfg.URI); err != nil {
+			return nil, fmt.Errorf("%w: %s", errInvalidDialSettings, redact(err.Error(), cfg))
+		}
+
+		u, err := url.Parse(cfg.URI)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", errInvalidDialSettings, redact(err.Error(), cfg))
+		}
+		u.User = url.UserPassword(c.Username, c.Password)
+		out.URI = u.String()
+	}
+
+	return &out, nil
+}
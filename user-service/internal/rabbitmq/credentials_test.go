@@ -0,0 +1,122 @@
+package rabbitmq
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/config"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+)
+
+// newUnreachableBroker returns a client of a single node that refuses every
+// connection.
+func newUnreachableBroker(t *testing.T) *rabbitmq {
+	t.Helper()
+
+	log := logger.NewZerologLogger("error", nil)
+	cfg := &config.AMQP{
+		Host:           "127.0.0.1",
+		Port:           1,
+		PublishTimeout: time.Second,
+	}
+	base, err := dialURI(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &rabbitmq{
+		config:      cfg,
+		app:         &config.App{},
+		nodes:       newNodeSet(nodeAddresses(nil, base), ""),
+		state:       newStateNotifier(),
+		logger:      log,
+		credentials: newCredentialsProvider(cfg, log),
+		ctx:         context.Background(),
+	}
+}
+
+func writeCredentials(t *testing.T, dir, username, password string) {
+	t.Helper()
+
+	for name, value := range map[string]string{usernameFile: username, passwordFile: password} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(value+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFileCredentialsWatchFiresOnRotation(t *testing.T) {
+	dir := t.TempDir()
+	writeCredentials(t, dir, "user-service", "old")
+
+	p := NewFileCredentialsProvider(&FileCredentialsProviderOpts{
+		Dir:      dir,
+		Interval: 10 * time.Millisecond,
+		Logger:   logger.NewZerologLogger("error", nil),
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := p.Watch(ctx)
+
+	select {
+	case <-changed:
+		t.Fatal("signalled before the credentials changed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	writeCredentials(t, dir, "user-service", "new")
+
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("not signalled after the password file was rewritten")
+	}
+
+	c, err := p.Credentials(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Username != "user-service" || c.Password != "new" {
+		t.Errorf("got credentials %+v, want the rotated ones", c)
+	}
+}
+
+func TestFileCredentialsFallBackToConfiguredUsername(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, passwordFile), []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewFileCredentialsProvider(&FileCredentialsProviderOpts{Dir: dir, Username: "guest"}).Credentials(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Username != "guest" || c.Password != "secret" {
+		t.Errorf("got credentials %+v", c)
+	}
+}
+
+func TestRotatedConnectFailureKeepsNodeHealth(t *testing.T) {
+	b := newUnreachableBroker(t)
+	n := b.nodes.candidates()[0]
+
+	// The broker may not know the new password yet, which says nothing
+	// about the node.
+	if _, err := b.connect(true); err == nil {
+		t.Fatal("connected to an unreachable node")
+	}
+	if n.failures != 0 {
+		t.Errorf("rotated attempt counted %d failures against the node", n.failures)
+	}
+
+	if _, err := b.connect(false); err == nil {
+		t.Fatal("connected to an unreachable node")
+	}
+	if n.failures != 1 {
+		t.Errorf("node has %d failures after a regular attempt, want 1", n.failures)
+	}
+}
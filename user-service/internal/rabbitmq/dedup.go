@@ -0,0 +1,317 @@
+package rabbitmq
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+)
+
+const (
+	DialectPostgres = "postgres"
+	DialectMySQL    = "mysql"
+	DialectSQLite   = "sqlite"
+)
+
+var ErrUnsupportedDialect = errors.New("dedup store sql dialect is not supported")
+
+// errNotAcked fails the processing of a delivery the handler settled without
+// acknowledging it, so that its id is not recorded.
+var errNotAcked = errors.New("amqp delivery was not acknowledged")
+
+// DedupStore records the ids of processed messages.
+type DedupStore interface {
+	// Process runs fn unless id has already been processed, and records id
+	// only if fn succeeds, so that a failed attempt can be retried. It
+	// reports whether fn ran.
+	Process(ctx context.Context, id string, fn func(ctx context.Context) error) (bool, error)
+}
+
+// Deduplicate wraps handler so that a message redelivered after it was
+// processed successfully is acknowledged without running handler again.
+// Processing succeeded if the handler acknowledged the delivery, or returned
+// nil without settling it; a delivery it nacked or rejected is handled again
+// when redelivered. An acknowledgement by the handler is only sent once the
+// id is recorded, so a message whose id failed to commit is redelivered
+// rather than lost. Messages are identified by their message id; those
+// without one are always handled.
+func Deduplicate(store DedupStore, log logger.Logger, handler Handler) Handler {
+	return func(ctx context.Context, d *Delivery) error {
+		id := d.MessageId
+		if id == "" {
+			if ceID, ok := d.Headers[cloudEventsHeaderPrefix+"id"].(string); ok {
+				id = ceID
+			}
+		}
+		if id == "" {
+			return handler(ctx, d)
+		}
+
+		var handlerErr error
+		d.holdAck = true
+		ran, err := store.Process(ctx, id, func(ctx context.Context) error {
+			handlerErr = handler(ctx, d)
+			switch {
+			case d.acked:
+				return nil
+			case d.settled && handlerErr == nil:
+				return errNotAcked
+			}
+			return handlerErr
+		})
+		d.holdAck = false
+
+		if ran && d.acked {
+			if err != nil {
+				// Not recorded, so the delivery is failed like any other.
+				d.settled, d.acked = false, false
+				return err
+			}
+			if err := d.Ack(d.ackMultiple); err != nil {
+				return err
+			}
+			return handlerErr
+		}
+		if !ran && err == nil {
+			dedupDuplicatesTotal.WithLabelValues(d.Pattern()).Inc()
+			log.Info("AMQP duplicate message skipped", logger.Field{Key: "event", Value: d.Pattern()}, logger.Field{Key: "message_id", Value: id})
+		}
+		if ran && (err == nil || errors.Is(err, errNotAcked)) {
+			return handlerErr
+		}
+
+		return err
+	}
+}
+
+type MemoryDedupStoreOpts struct {
+	// Capacity is the number of ids kept; the least recently seen are
+	// evicted first.
+	Capacity int
+	// TTL is how long an id is remembered. Zero keeps ids until evicted.
+	TTL time.Duration
+}
+
+// MemoryDedupStore is an in-process LRU of processed ids. It only catches
+// redeliveries to the same instance and is lost on restart, which suits a
+// single replica or a best-effort guard in front of idempotent handlers.
+type MemoryDedupStore struct {
+	capacity int
+	ttl      time.Duration
+
+	mu       sync.Mutex
+	order    *list.List
+	entries  map[string]*list.Element
+	inflight map[string]chan struct{}
+}
+
+type dedupEntry struct {
+	id        string
+	expiresAt time.Time
+}
+
+func NewMemoryDedupStore(opts *MemoryDedupStoreOpts) *MemoryDedupStore {
+	return &MemoryDedupStore{
+		capacity: opts.Capacity,
+		ttl:      opts.TTL,
+		order:    list.New(),
+		entries:  map[string]*list.Element{},
+		inflight: map[string]chan struct{}{},
+	}
+}
+
+// Process runs fn at most once per id at a time: a concurrent delivery of the
+// same id waits for the first to finish and is then skipped if it succeeded.
+func (s *MemoryDedupStore) Process(ctx context.Context, id string, fn func(ctx context.Context) error) (bool, error) {
+	for {
+		s.mu.Lock()
+		if s.seen(id) {
+			s.mu.Unlock()
+			return false, nil
+		}
+
+		if wait, ok := s.inflight[id]; ok {
+			s.mu.Unlock()
+
+			select {
+			case <-ctx.Done():
+				return false, ctx.Err()
+			case <-wait:
+			}
+			continue
+		}
+
+		done := make(chan struct{})
+		s.inflight[id] = done
+		s.mu.Unlock()
+
+		err := fn(ctx)
+
+		s.mu.Lock()
+		delete(s.inflight, id)
+		close(done)
+		if err == nil {
+			s.add(id)
+		}
+		s.mu.Unlock()
+
+		return true, err
+	}
+}
+
+func (s *MemoryDedupStore) seen(id string) bool {
+	el, ok := s.entries[id]
+	if !ok {
+		return false
+	}
+
+	entry := el.Value.(*dedupEntry)
+	if s.ttl > 0 && time.Now().After(entry.expiresAt) {
+		s.order.Remove(el)
+		delete(s.entries, id)
+		return false
+	}
+
+	s.order.MoveToFront(el)
+
+	return true
+}
+
+func (s *MemoryDedupStore) add(id string) {
+	entry := &dedupEntry{id: id}
+	if s.ttl > 0 {
+		entry.expiresAt = time.Now().Add(s.ttl)
+	}
+	s.entries[id] = s.order.PushFront(entry)
+
+	for s.capacity > 0 && s.order.Len() > s.capacity {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*dedupEntry).id)
+	}
+
+	dedupStoreSize.Set(float64(s.order.Len()))
+}
+
+type SQLDedupStoreOpts struct {
+	DB *sql.DB
+	// Table has a message_id primary key and a processed_at timestamp, see
+	// CreateTable. Defaults to processed_messages.
+	Table   string
+	Dialect string
+}
+
+// SQLDedupStore records processed ids in a table, in the same transaction as
+// the handler's own writes. The handler gets the transaction with
+// TxFromContext; when it commits, the id is recorded together with the side
+// effects, and when it fails neither is. Concurrent deliveries of one id
+// block on the row until the first transaction finishes.
+type SQLDedupStore struct {
+	db      *sql.DB
+	table   string
+	dialect string
+}
+
+func NewSQLDedupStore(opts *SQLDedupStoreOpts) (*SQLDedupStore, error) {
+	switch opts.Dialect {
+	case DialectPostgres, DialectMySQL, DialectSQLite:
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedDialect, opts.Dialect)
+	}
+
+	table := opts.Table
+	if table == "" {
+		table = "processed_messages"
+	}
+
+	return &SQLDedupStore{db: opts.DB, table: table, dialect: opts.Dialect}, nil
+}
+
+// CreateTable creates the dedup table if it does not exist.
+func (s *SQLDedupStore) CreateTable(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s (message_id VARCHAR(255) PRIMARY KEY, processed_at TIMESTAMP NOT NULL)", s.table))
+
+	return err
+}
+
+func (s *SQLDedupStore) Process(ctx context.Context, id string, fn func(ctx context.Context) error) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		dedupStoreErrorsTotal.Inc()
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, s.insertQuery(), id, time.Now().UTC())
+	if err != nil {
+		dedupStoreErrorsTotal.Inc()
+		return false, err
+	}
+
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		dedupStoreErrorsTotal.Inc()
+		return false, err
+	}
+	if inserted == 0 {
+		return false, nil
+	}
+
+	if err := fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		return true, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		dedupStoreErrorsTotal.Inc()
+		return true, err
+	}
+
+	return true, nil
+}
+
+// Purge deletes ids processed before cutoff, once redeliveries of them are no
+// longer expected.
+func (s *SQLDedupStore) Purge(ctx context.Context, cutoff time.Time) (int64, error) {
+	query := fmt.Sprintf("DELETE FROM %s WHERE processed_at < %s", s.table, s.placeholder(1))
+
+	res, err := s.db.ExecContext(ctx, query, cutoff.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+func (s *SQLDedupStore) insertQuery() string {
+	switch s.dialect {
+	case DialectMySQL:
+		return fmt.Sprintf("INSERT IGNORE INTO %s (message_id, processed_at) VALUES (?, ?)", s.table)
+	default:
+		return fmt.Sprintf("INSERT INTO %s (message_id, processed_at) VALUES (%s, %s) ON CONFLICT (message_id) DO NOTHING",
+			s.table, s.placeholder(1), s.placeholder(2))
+	}
+}
+
+func (s *SQLDedupStore) placeholder(n int) string {
+	if s.dialect == DialectPostgres {
+		return fmt.Sprintf("$%d", n)
+	}
+
+	return "?"
+}
+
+type txKey struct{}
+
+// TxFromContext returns the transaction a SQLDedupStore runs the handler in,
+// so the handler can make its writes part of it.
+func TxFromContext(ctx context.Context) (*sql.Tx, bool) {
+	tx, ok := ctx.Value(txKey{}).(*sql.Tx)
+
+	return tx, ok
+}
@@ -0,0 +1,226 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	amqplib "github.com/rabbitmq/amqp091-go"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+)
+
+// nopAcknowledger lets deliveries be settled without a channel.
+type nopAcknowledger struct{}
+
+func (nopAcknowledger) Ack(tag uint64, multiple bool) error           { return nil }
+func (nopAcknowledger) Nack(tag uint64, multiple, requeue bool) error { return nil }
+func (nopAcknowledger) Reject(tag uint64, requeue bool) error         { return nil }
+
+func newTestDelivery(id string) *Delivery {
+	return &Delivery{Delivery: amqplib.Delivery{
+		Acknowledger: nopAcknowledger{},
+		MessageId:    id,
+		Type:         "user.created",
+	}}
+}
+
+func TestDeduplicate(t *testing.T) {
+	errHandler := errors.New("handler failed")
+
+	tests := []struct {
+		name string
+		// first handles the first delivery of the message.
+		first        func(d *Delivery) error
+		wantFirstErr error
+		wantRecorded bool
+	}{
+		{
+			name:         "returned nil",
+			first:        func(d *Delivery) error { return nil },
+			wantRecorded: true,
+		},
+		{
+			name:         "acked",
+			first:        func(d *Delivery) error { return d.Ack(false) },
+			wantRecorded: true,
+		},
+		{
+			name:         "returned an error",
+			first:        func(d *Delivery) error { return errHandler },
+			wantFirstErr: errHandler,
+		},
+		{
+			name:  "nacked for requeue",
+			first: func(d *Delivery) error { return d.Nack(false, true) },
+		},
+		{
+			name:  "rejected",
+			first: func(d *Delivery) error { return d.Reject(false) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryDedupStore(&MemoryDedupStoreOpts{Capacity: 10})
+
+			var calls int
+			handler := Deduplicate(store, logger.NewZerologLogger("error", nil), func(ctx context.Context, d *Delivery) error {
+				calls++
+				if calls == 1 {
+					return tt.first(d)
+				}
+				return nil
+			})
+
+			if err := handler(context.Background(), newTestDelivery("msg-1")); !errors.Is(err, tt.wantFirstErr) {
+				t.Fatalf("first delivery: got %v, want %v", err, tt.wantFirstErr)
+			}
+			if err := handler(context.Background(), newTestDelivery("msg-1")); err != nil {
+				t.Fatalf("redelivery: %v", err)
+			}
+
+			wantCalls := 2
+			if tt.wantRecorded {
+				wantCalls = 1
+			}
+			if calls != wantCalls {
+				t.Errorf("handler ran %d times, want %d", calls, wantCalls)
+			}
+		})
+	}
+}
+
+func TestDeduplicateWithoutMessageID(t *testing.T) {
+	store := NewMemoryDedupStore(&MemoryDedupStoreOpts{Capacity: 10})
+
+	var calls int
+	handler := Deduplicate(store, logger.NewZerologLogger("error", nil), func(ctx context.Context, d *Delivery) error {
+		calls++
+		return nil
+	})
+
+	for range 2 {
+		if err := handler(context.Background(), newTestDelivery("")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("handler ran %d times, want 2", calls)
+	}
+}
+
+func TestMemoryDedupStoreConcurrentDeliveries(t *testing.T) {
+	store := NewMemoryDedupStore(&MemoryDedupStoreOpts{Capacity: 10})
+
+	var calls atomic.Int32
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := store.Process(context.Background(), "msg-1", func(ctx context.Context) error {
+				calls.Add(1)
+				time.Sleep(10 * time.Millisecond)
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("processed %d times, want 1", n)
+	}
+}
+
+func TestMemoryDedupStoreEviction(t *testing.T) {
+	store := NewMemoryDedupStore(&MemoryDedupStoreOpts{Capacity: 2, TTL: 50 * time.Millisecond})
+	process := func(id string) bool {
+		ran, err := store.Process(context.Background(), id, func(ctx context.Context) error { return nil })
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ran
+	}
+
+	process("a")
+	process("b")
+	process("c")
+	if !process("a") {
+		t.Error("least recently seen id was not evicted at capacity")
+	}
+	if process("c") {
+		t.Error("recent id was processed again")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !process("c") {
+		t.Error("expired id was not processed again")
+	}
+}
+
+// recordingAcknowledger counts the acknowledgements sent to the broker.
+type recordingAcknowledger struct {
+	nopAcknowledger
+	acks int
+}
+
+func (a *recordingAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.acks++
+	return nil
+}
+
+// commitFailingStore runs fn and then fails to record the id, like a
+// SQLDedupStore whose commit fails.
+type commitFailingStore struct{ err error }
+
+func (s commitFailingStore) Process(ctx context.Context, id string, fn func(ctx context.Context) error) (bool, error) {
+	if err := fn(ctx); err != nil {
+		return true, err
+	}
+	return true, s.err
+}
+
+func TestDeduplicateAcksOnlyOnceRecorded(t *testing.T) {
+	errCommit := errors.New("commit failed")
+
+	for name, store := range map[string]DedupStore{
+		"recorded":      NewMemoryDedupStore(&MemoryDedupStoreOpts{Capacity: 10}),
+		"commit failed": commitFailingStore{err: errCommit},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ack := &recordingAcknowledger{}
+			d := newTestDelivery("msg-1")
+			d.Acknowledger = ack
+
+			handler := Deduplicate(store, logger.NewZerologLogger("error", nil), func(ctx context.Context, d *Delivery) error {
+				if err := d.Ack(false); err != nil {
+					return err
+				}
+				if ack.acks != 0 {
+					t.Error("ack sent before the id was recorded")
+				}
+				return nil
+			})
+			err := handler(context.Background(), d)
+
+			if name == "recorded" {
+				if err != nil || ack.acks != 1 {
+					t.Errorf("got error %v and %d acks, want the ack sent", err, ack.acks)
+				}
+				return
+			}
+			if !errors.Is(err, errCommit) || ack.acks != 0 {
+				t.Errorf("got error %v and %d acks, want the commit error and no ack", err, ack.acks)
+			}
+			if d.settled {
+				t.Error("delivery left settled, so it would never be nacked")
+			}
+		})
+	}
+}
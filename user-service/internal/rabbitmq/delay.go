@@ -0,0 +1,224 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	amqplib "github.com/rabbitmq/amqp091-go"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+)
+
+const (
+	DelayModeAuto   = "auto"
+	DelayModePlugin = "plugin"
+	DelayModeTTL    = "ttl"
+
+	// ExchangeDelayed is the exchange type of the delayed message exchange
+	// plugin, which holds messages for the milliseconds in their x-delay
+	// header before routing them.
+	ExchangeDelayed = "x-delayed-message"
+
+	HeaderDelay = "x-delay"
+
+	// delayQueueExpiry is how long an unused TTL delay queue is kept after
+	// its delay has passed.
+	delayQueueExpiry = time.Hour
+)
+
+// ErrDelayedTTL is returned for a message with both a TTL and a delay when
+// delays are implemented with TTL queues, since dead-lettering a message out
+// of its delay queue drops its expiration.
+var ErrDelayedTTL = errors.New("amqp message ttl cannot be combined with a delay without the delayed message plugin")
+
+// delayer decides how delayed messages are held back, detecting the plugin
+// through the management API once in auto mode. It remembers the routes
+// declared on the current connection, so they are declared once per queue and
+// delay rather than for every message.
+type delayer struct {
+	once   sync.Once
+	plugin bool
+
+	mu     sync.Mutex
+	routes map[delayRouteKey]*declaredDelayRoute
+}
+
+type delayRouteKey struct {
+	queue  string
+	delay  time.Duration
+	plugin bool
+}
+
+type declaredDelayRoute struct {
+	exchange string
+	key      string
+	// pool identifies the connection the route was declared on.
+	pool       *channelPool
+	declaredAt time.Time
+}
+
+// route returns the route declared for k on the connection of pool. A TTL
+// delay queue expires once unused for its delay plus delayQueueExpiry, and
+// publishes do not count as use, so the route is declared again well before.
+func (d *delayer) route(k delayRouteKey, pool *channelPool) (*declaredDelayRoute, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	route, ok := d.routes[k]
+	if !ok || route.pool != pool || time.Since(route.declaredAt) > delayQueueExpiry/2 {
+		return nil, false
+	}
+
+	return route, true
+}
+
+func (d *delayer) declared(k delayRouteKey, route *declaredDelayRoute) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.routes == nil {
+		d.routes = map[delayRouteKey]*declaredDelayRoute{}
+	}
+	d.routes[k] = route
+}
+
+func (b *rabbitmq) usePlugin(ctx context.Context) bool {
+	switch b.config.Delay.Mode {
+	case DelayModePlugin:
+		return true
+	case DelayModeTTL:
+		return false
+	}
+
+	b.delayer.once.Do(func() {
+		b.delayer.plugin = b.detectDelayPlugin(ctx)
+	})
+
+	return b.delayer.plugin
+}
+
+// detectDelayPlugin reports whether the broker has the delayed message
+// exchange type. Declaring an exchange of an unknown type closes the whole
+// connection, so the management API is asked instead.
+func (b *rabbitmq) detectDelayPlugin(ctx context.Context) bool {
+	var overview struct {
+		ExchangeTypes []struct {
+			Name string `json:"name"`
+		} `json:"exchange_types"`
+	}
+	if err := management(ctx, b.brokerConfig(), http.MethodGet, "/overview", nil, &overview); err != nil {
+		b.logger.Warn("AMQP failed to detect the delayed message plugin, delaying with TTL queues", logger.Field{Key: "error", Value: redact(err.Error(), b.brokerConfig())})
+		return false
+	}
+
+	for _, t := range overview.ExchangeTypes {
+		if t.Name == ExchangeDelayed {
+			b.logger.Info("AMQP delaying messages with the delayed message plugin")
+			return true
+		}
+	}
+	b.logger.Info("AMQP delayed message plugin not found, delaying with TTL queues")
+
+	return false
+}
+
+// delayRoute declares what holds msg back for delay on its way to queue and
+// returns the exchange and routing key to publish it with.
+func (b *rabbitmq) delayRoute(ctx context.Context, queue string, delay time.Duration, msg *amqplib.Publishing) (string, string, error) {
+	plugin := b.usePlugin(ctx)
+	if !plugin && msg.Expiration != "" {
+		return "", "", ErrDelayedTTL
+	}
+
+	route, err := b.declareDelayRoute(ctx, delayRouteKey{queue: queue, delay: delay, plugin: plugin})
+	if err != nil {
+		return "", "", err
+	}
+
+	holdBack(msg, delay, plugin)
+
+	return route.exchange, route.key, nil
+}
+
+// holdBack marks msg for delay. Delayed messages are persistent, since they
+// wait in the broker for the whole delay and a restart in the meantime would
+// lose transient ones.
+func holdBack(msg *amqplib.Publishing, delay time.Duration, plugin bool) {
+	msg.DeliveryMode = amqplib.Persistent
+	if plugin {
+		if msg.Headers == nil {
+			msg.Headers = amqplib.Table{}
+		}
+		msg.Headers[HeaderDelay] = delay.Milliseconds()
+	}
+}
+
+// declareDelayRoute declares the exchange, queue and bindings of the route
+// for k, unless they were already declared on the current connection. A
+// failed declaration closes its pool channel, which the pool then discards.
+func (b *rabbitmq) declareDelayRoute(ctx context.Context, k delayRouteKey) (*declaredDelayRoute, error) {
+	b.mu.RLock()
+	current := b.pool
+	b.mu.RUnlock()
+
+	if route, ok := b.delayer.route(k, current); ok {
+		return route, nil
+	}
+
+	pool, pc, err := b.checkout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer pool.Release()
+	defer pool.Put(pc)
+
+	channel := pc.Channel
+	if _, err := b.declareQueue(k.queue, channel); err != nil {
+		return nil, err
+	}
+
+	route := &declaredDelayRoute{pool: pool, declaredAt: time.Now()}
+	exchange := b.config.Delay.Exchange
+
+	if k.plugin {
+		if err := channel.ExchangeDeclare(exchange, ExchangeDelayed, true, false, false, false, amqplib.Table{"x-delayed-type": ExchangeDirect}); err != nil {
+			return nil, fmt.Errorf("declare delayed exchange %s: %w", exchange, err)
+		}
+		if err := channel.QueueBind(k.queue, k.queue, exchange, false, nil); err != nil {
+			return nil, fmt.Errorf("bind queue %s to %s: %w", k.queue, exchange, err)
+		}
+
+		route.exchange, route.key = exchange, k.queue
+		b.delayer.declared(k, route)
+
+		return route, nil
+	}
+
+	ms := k.delay.Milliseconds()
+	exchange += ".ttl"
+	if err := channel.ExchangeDeclare(exchange, ExchangeDirect, true, false, false, false, nil); err != nil {
+		return nil, fmt.Errorf("declare delay exchange %s: %w", exchange, err)
+	}
+
+	name := fmt.Sprintf("%s.%s.%d", exchange, k.queue, ms)
+	_, err = channel.QueueDeclare(name, true, false, false, false, amqplib.Table{
+		"x-message-ttl":             ms,
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": k.queue,
+		"x-expires":                 (k.delay + delayQueueExpiry).Milliseconds(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("declare delay queue %s: %w", name, err)
+	}
+	if err := channel.QueueBind(name, name, exchange, false, nil); err != nil {
+		return nil, fmt.Errorf("bind queue %s to %s: %w", name, exchange, err)
+	}
+
+	route.exchange, route.key = exchange, name
+	b.delayer.declared(k, route)
+
+	return route, nil
+}
@@ -0,0 +1,49 @@
+package rabbitmq
+
+import (
+	"testing"
+	"time"
+
+	amqplib "github.com/rabbitmq/amqp091-go"
+)
+
+func TestDelayerReusesRoutesOfCurrentConnection(t *testing.T) {
+	var d delayer
+	pool := &channelPool{}
+	k := delayRouteKey{queue: "orders", delay: time.Minute}
+
+	if _, ok := d.route(k, pool); ok {
+		t.Fatal("route found before it was declared")
+	}
+
+	d.declared(k, &declaredDelayRoute{exchange: "delayed.ttl", key: "delayed.ttl.orders.60000", pool: pool, declaredAt: time.Now()})
+
+	if route, ok := d.route(k, pool); !ok || route.key != "delayed.ttl.orders.60000" {
+		t.Errorf("got %+v, want the declared route", route)
+	}
+	if _, ok := d.route(delayRouteKey{queue: "orders", delay: time.Minute, plugin: true}, pool); ok {
+		t.Error("route of the ttl mode reused for the plugin")
+	}
+	if _, ok := d.route(k, &channelPool{}); ok {
+		t.Error("route reused on another connection")
+	}
+
+	d.declared(k, &declaredDelayRoute{pool: pool, declaredAt: time.Now().Add(-delayQueueExpiry)})
+	if _, ok := d.route(k, pool); ok {
+		t.Error("route reused after its delay queue may have expired")
+	}
+}
+
+func TestHoldBackPersistsDelayedMessages(t *testing.T) {
+	for _, plugin := range []bool{false, true} {
+		var msg amqplib.Publishing
+		holdBack(&msg, 90*time.Second, plugin)
+
+		if msg.DeliveryMode != amqplib.Persistent {
+			t.Errorf("plugin %v: delivery mode %d, want persistent", plugin, msg.DeliveryMode)
+		}
+		if delay, ok := msg.Headers[HeaderDelay]; ok != plugin || (plugin && delay != int64(90000)) {
+			t.Errorf("plugin %v: got x-delay %v", plugin, delay)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package rabbitmq
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	amqplib "github.com/rabbitmq/amqp091-go"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/config"
+)
+
+const (
+	AuthMechanismPlain    = "plain"
+	AuthMechanismExternal = "external"
+
+	redactedPassword = "xxxxx"
+)
+
+var (
+	ErrUnsupportedAuthMechanism = errors.New("amqp auth mechanism is not supported")
+
+	errInvalidDialSettings = errors.New("invalid amqp connection settings")
+)
+
+// Dial opens a single connection with the configured address, TLS,
+// authentication and nodes, trying each node in order. It is meant for tools
+// that need one connection; the service keeps its connection with
+// MaintainConnection instead. Credentials are read from the credentials
+// directory when one is configured.
+func Dial(cfg *config.AMQP, app *config.App) (*amqplib.Connection, error) {
+	cfg, err := ResolveCredentials(context.Background(), cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := dialURI(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, address := range nodeAddresses(cfg.Hosts, base) {
+		conn, err := dialNode(cfg, app, withNode(base, address))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// dialNode connects to a single node. Errors are redacted; those wrapping
+// errInvalidDialSettings will fail on every node.
+func dialNode(cfg *config.AMQP, app *config.App, address string) (*amqplib.Connection, error) {
+	uri, err := amqplib.ParseURI(address)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidDialSettings, redact(err.Error(), cfg))
+	}
+
+	dc, err := dialConfig(cfg, app, uri)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidDialSettings, err)
+	}
+
+	conn, err := amqplib.DialConfig(address, dc)
+	if err != nil {
+		return nil, errors.New(redact(err.Error(), cfg))
+	}
+
+	return conn, nil
+}
+
+// dialURI returns the broker address, taken from AMQP_URI when it is set and
+// assembled from the discrete host, port, credential and vhost settings
+// otherwise.
+func dialURI(cfg *config.AMQP) (string, error) {
+	if cfg.URI != "" {
+		if _, err := amqplib.ParseURI(cfg.URI); err != nil {
+			return "", errors.New(redact(err.Error(), cfg))
+		}
+
+		return cfg.URI, nil
+	}
+
+	uri := amqplib.URI{
+		Scheme:   "amqp",
+		Host:     cfg.Host,
+		Port:     cfg.Port,
+		Username: cfg.Username,
+		Password: cfg.Password,
+		Vhost:    cfg.VHost,
+	}
+	if cfg.TLS != nil && cfg.TLS.Enabled {
+		uri.Scheme = "amqps"
+	}
+
+	return uri.String(), nil
+}
+
+// dialConfig maps the connection tuning, authentication and TLS settings onto
+// the client configuration. The connection name is shown in the management UI.
+func dialConfig(cfg *config.AMQP, app *config.App, uri amqplib.URI) (amqplib.Config, error) {
+	name := cfg.ConnectionName
+	if name == "" {
+		name = app.Name
+	}
+
+	properties := amqplib.NewConnectionProperties()
+	properties.SetClientConnectionName(name)
+
+	dc := amqplib.Config{
+		Heartbeat:  cfg.Heartbeat,
+		Locale:     cfg.Locale,
+		FrameSize:  cfg.FrameSize,
+		ChannelMax: uint16(cfg.ChannelMax),
+		Properties: properties,
+	}
+
+	switch strings.ToLower(cfg.AuthMechanism) {
+	case "", AuthMechanismPlain:
+	case AuthMechanismExternal:
+		dc.SASL = []amqplib.Authentication{&amqplib.ExternalAuth{}}
+	default:
+		return dc, fmt.Errorf("%w: %s", ErrUnsupportedAuthMechanism, cfg.AuthMechanism)
+	}
+
+	if uri.Scheme == "amqps" {
+		tlsConfig, err := newTLSConfig(cfg.TLS, uri)
+		if err != nil {
+			return dc, err
+		}
+		dc.TLSClientConfig = tlsConfig
+	}
+
+	return dc, nil
+}
+
+// newTLSConfig builds the client TLS configuration. Files set in AMQP_TLS_*
+// take precedence over the ones given as URI query parameters.
+func newTLSConfig(cfg *config.AMQPTLS, uri amqplib.URI) (*tls.Config, error) {
+	if cfg == nil {
+		cfg = &config.AMQPTLS{}
+	}
+
+	caFile := firstNonEmpty(cfg.CAFile, uri.CACertFile)
+	certFile := firstNonEmpty(cfg.CertFile, uri.CertFile)
+	keyFile := firstNonEmpty(cfg.KeyFile, uri.KeyFile)
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         firstNonEmpty(cfg.ServerName, uri.ServerName, uri.Host),
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read amqp ca certificate: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("amqp ca certificate %s contains no certificates", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load amqp client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// redactURI returns the address with the password masked, for logging.
+func redactURI(address string) string {
+	u, err := url.Parse(address)
+	if err != nil || u.User == nil {
+		return address
+	}
+
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redactedPassword)
+	}
+
+	return u.String()
+}
+
+// redact masks the configured password wherever it appears in s, so that
+// errors echoing the address never leak it into the logs.
+func redact(s string, cfg *config.AMQP) string {
+	passwords := []string{cfg.Password}
+	if u, err := url.Parse(cfg.URI); err == nil && u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			passwords = append(passwords, password)
+		}
+	}
+
+	for _, password := range passwords {
+		if password == "" {
+			continue
+		}
+
+		for _, secret := range []string{password, url.QueryEscape(password), url.PathEscape(password), url.UserPassword("", password).String()[1:]} {
+			s = strings.ReplaceAll(s, secret, redactedPassword)
+		}
+	}
+
+	return s
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
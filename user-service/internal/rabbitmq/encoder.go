@@ -0,0 +1,134 @@
+package rabbitmq
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	amqplib "github.com/rabbitmq/amqp091-go"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/config"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/eventbus"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+)
+
+// encoder turns an envelope into the AMQP message that goes on the wire. It is
+// shared by every driver so that consumers see identical messages whichever
+// broker delivered them.
+type encoder struct {
+	config       *config.AMQP
+	app          *config.App
+	codecs       map[string]Codec
+	defaultCodec Codec
+}
+
+func newEncoder(cfg *config.AMQP, app *config.App, log logger.Logger) *encoder {
+	e := &encoder{
+		config:       cfg,
+		app:          app,
+		codecs:       map[string]Codec{},
+		defaultCodec: resolveCodec(cfg.DefaultCodec, log),
+	}
+
+	for pattern, name := range cfg.EventCodecs {
+		e.codecs[pattern] = resolveCodec(name, log)
+	}
+
+	return e
+}
+
+// stamp fills in the envelope fields the caller left empty. A message without
+// a correlation id starts a new chain and correlates to itself.
+func (e *encoder) stamp(ctx context.Context, message *MessageType) {
+	if message.MessageID == "" {
+		message.MessageID = uuid.NewString()
+	}
+	if message.CorrelationID == "" {
+		message.CorrelationID = eventbus.CorrelationIDFromContext(ctx)
+	}
+	if message.CorrelationID == "" {
+		message.CorrelationID = message.MessageID
+	}
+	if message.CausationID == "" {
+		message.CausationID = eventbus.CausationIDFromContext(ctx)
+	}
+	if message.Producer == "" {
+		message.Producer = e.app.Name
+	}
+	if message.ProducerVersion == "" {
+		message.ProducerVersion = e.app.Version
+	}
+	if message.SchemaVersion == 0 {
+		message.SchemaVersion = defaultSchemaVersion
+	}
+	if message.OccurredAt.IsZero() {
+		message.OccurredAt = time.Now().UTC()
+	}
+}
+
+// encode builds the AMQP message for the configured event format, using the
+// codec selected for the message's pattern, and compresses bodies above the
+// configured size.
+func (e *encoder) encode(message *MessageType) (amqplib.Publishing, error) {
+	publishing := properties(message)
+	codec := e.codecFor(message.Pattern)
+
+	var err error
+	switch e.config.EventFormat {
+	case EventFormatCloudEventsBinary:
+		err = encodeCloudEventBinary(message, codec, &publishing)
+	case EventFormatCloudEventsStructured:
+		err = encodeCloudEventStructured(message, &publishing)
+	default:
+		err = encodeEnvelope(message, codec, &publishing)
+	}
+	if err != nil {
+		return publishing, err
+	}
+
+	if e.config.Compression != "" && len(publishing.Body) >= e.config.CompressionMinBytes {
+		body, err := compress(e.config.Compression, publishing.Body)
+		if err != nil {
+			return publishing, err
+		}
+
+		publishing.Body = body
+		publishing.ContentEncoding = e.config.Compression
+	}
+
+	return publishing, nil
+}
+
+func (e *encoder) codecFor(pattern string) Codec {
+	if c, ok := e.codecs[pattern]; ok {
+		return c
+	}
+
+	return e.defaultCodec
+}
+
+func encodeEnvelope(message *MessageType, codec Codec, publishing *amqplib.Publishing) error {
+	var v any = message
+	if _, ok := codec.(dataOnlyCodec); ok {
+		v = message.Data
+	}
+
+	body, err := codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	publishing.ContentType = codec.ContentType()
+	publishing.Body = body
+
+	return nil
+}
+
+func resolveCodec(name string, log logger.Logger) Codec {
+	codec, err := codecByName(name)
+	if err != nil {
+		log.Error("AMQP falling back to json codec", logger.Field{Key: "error", Value: err.Error()})
+		return JSONCodec{}
+	}
+
+	return codec
+}
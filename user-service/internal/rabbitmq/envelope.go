@@ -0,0 +1,34 @@
+package rabbitmq
+
+import (
+	amqplib "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	HeaderCausationID     = "x-causation-id"
+	HeaderProducerVersion = "x-producer-version"
+	HeaderSchemaVersion   = "x-schema-version"
+
+	defaultSchemaVersion = 1
+)
+
+// properties mirrors the envelope onto AMQP properties so consumers that never
+// parse the body can still route, trace and deduplicate the message.
+func properties(message *MessageType) amqplib.Publishing {
+	headers := amqplib.Table{
+		HeaderProducerVersion: message.ProducerVersion,
+		HeaderSchemaVersion:   message.SchemaVersion,
+	}
+	if message.CausationID != "" {
+		headers[HeaderCausationID] = message.CausationID
+	}
+
+	return amqplib.Publishing{
+		Headers:       headers,
+		MessageId:     message.MessageID,
+		CorrelationId: message.CorrelationID,
+		Timestamp:     message.OccurredAt,
+		Type:          message.Pattern,
+		AppId:         message.Producer,
+	}
+}
@@ -0,0 +1,131 @@
+package rabbitmq
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/eventbus"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+)
+
+// eventBus adapts a RabbitMQ implementation to eventbus.EventBus. Topics are
+// queue names.
+type eventBus struct {
+	rabbitmq RabbitMQ
+	dedup    DedupStore
+	logger   logger.Logger
+}
+
+type EventBusOpts struct {
+	RabbitMQ RabbitMQ
+	// Dedup, when set, skips subscribed messages that were already
+	// processed, see Deduplicate. It is for services that subscribe to
+	// events; user-service only publishes and leaves it unset.
+	Dedup  DedupStore
+	Logger logger.Logger
+}
+
+func NewEventBus(opts *EventBusOpts) eventbus.EventBus {
+	return &eventBus{rabbitmq: opts.RabbitMQ, dedup: opts.Dedup, logger: opts.Logger}
+}
+
+func (e *eventBus) Health() error {
+	return e.rabbitmq.Health()
+}
+
+func (e *eventBus) Publish(ctx context.Context, topic string, event *eventbus.Event) error {
+	return e.rabbitmq.Publish(ctx, topic, eventMessage(event), eventOptions(event)...)
+}
+
+// PublishBatch publishes events to the topic queue with pipelined confirms.
+// Events with different publish options are published one by one.
+func (e *eventBus) PublishBatch(ctx context.Context, topic string, events []*eventbus.Event) []error {
+	messages := make([]*MessageType, len(events))
+	for i, event := range events {
+		if !sameOptions(event, events[0]) {
+			errs := make([]error, len(events))
+			for i, event := range events {
+				errs[i] = e.Publish(ctx, topic, event)
+			}
+			return errs
+		}
+		messages[i] = eventMessage(event)
+	}
+
+	var opts []PublishOption
+	if len(events) > 0 {
+		opts = eventOptions(events[0])
+	}
+
+	return e.rabbitmq.PublishBatch(ctx, topic, messages, opts...)
+}
+
+func eventMessage(event *eventbus.Event) *MessageType {
+	return &MessageType{
+		Pattern:       event.Pattern,
+		Data:          event.Data,
+		Subject:       event.Subject,
+		SchemaVersion: event.SchemaVersion,
+	}
+}
+
+func eventOptions(event *eventbus.Event) []PublishOption {
+	var opts []PublishOption
+	if event.TTL > 0 {
+		opts = append(opts, WithTTL(event.TTL))
+	}
+	if event.Priority > 0 {
+		opts = append(opts, WithPriority(event.Priority))
+	}
+	if len(event.Headers) > 0 {
+		opts = append(opts, WithHeaders(event.Headers))
+	}
+	if event.Delay > 0 {
+		opts = append(opts, WithDelay(event.Delay))
+	}
+
+	return opts
+}
+
+func sameOptions(a, b *eventbus.Event) bool {
+	return a.TTL == b.TTL && a.Priority == b.Priority && a.Delay == b.Delay && reflect.DeepEqual(a.Headers, b.Headers)
+}
+
+func (e *eventBus) Close(ctx context.Context) error {
+	return e.rabbitmq.Close(ctx)
+}
+
+func (e *eventBus) Subscribe(ctx context.Context, topic string, handler eventbus.Handler) error {
+	h := func(ctx context.Context, d *Delivery) error {
+		return handler(ctx, &busMessage{delivery: d})
+	}
+	if e.dedup != nil {
+		h = Deduplicate(e.dedup, e.logger, h)
+	}
+
+	return e.rabbitmq.Consume(ctx, topic, h)
+}
+
+type busMessage struct {
+	delivery *Delivery
+}
+
+func (m *busMessage) ID() string {
+	return m.delivery.MessageId
+}
+
+func (m *busMessage) Pattern() string {
+	return m.delivery.Pattern()
+}
+
+func (m *busMessage) Decode(v any) error {
+	return m.delivery.Decode(v)
+}
+
+func (m *busMessage) Ack() error {
+	return m.delivery.Ack(false)
+}
+
+func (m *busMessage) Nack(requeue bool) error {
+	return m.delivery.Nack(false, requeue)
+}
@@ -0,0 +1,78 @@
+package rabbitmq
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	amqplib "github.com/rabbitmq/amqp091-go"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/config"
+)
+
+var (
+	ErrManagementNotConfigured = errors.New("amqp management api url is not configured")
+	ErrManagementNotFound      = errors.New("amqp management api resource not found")
+)
+
+// management calls the RabbitMQ management HTTP API with the broker
+// credentials from cfg. path is relative to /api; a {vhost} placeholder in it is
+// replaced with the escaped vhost. in is sent as the JSON body and the
+// response decoded into out, when they are not nil.
+func management(ctx context.Context, cfg *config.AMQP, method, path string, in, out any) error {
+	if cfg.ManagementURL == "" {
+		return ErrManagementNotConfigured
+	}
+
+	base, err := dialURI(cfg)
+	if err != nil {
+		return err
+	}
+
+	uri, err := amqplib.ParseURI(base)
+	if err != nil {
+		return err
+	}
+
+	path = strings.ReplaceAll(path, "{vhost}", url.PathEscape(uri.Vhost))
+
+	var body io.Reader
+	if in != nil {
+		raw, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(raw)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(cfg.ManagementURL, "/")+"/api"+path, body)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(uri.Username, uri.Password)
+	req.Header.Set("Content-Type", ContentTypeJSON)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", ErrManagementNotFound, path)
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("management api %s %s responded with %s", method, path, res.Status)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(res.Body).Decode(out)
+}
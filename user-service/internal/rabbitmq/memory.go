@@ -0,0 +1,767 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	amqplib "github.com/rabbitmq/amqp091-go"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/config"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+)
+
+const (
+	DriverAMQP   = "amqp"
+	DriverMemory = "memory"
+
+	ExchangeDirect = "direct"
+	ExchangeFanout = "fanout"
+	ExchangeTopic  = "topic"
+)
+
+var (
+	ErrExchangeNotFound = errors.New("amqp exchange not found")
+	ErrQueueNotFound    = errors.New("amqp queue not found")
+)
+
+// TestingT is the subset of testing.TB used by the broker assertions.
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...any)
+}
+
+type memoryMessage struct {
+	tag         uint64
+	exchange    string
+	routingKey  string
+	publishing  amqplib.Publishing
+	redelivered bool
+}
+
+type memoryQueue struct {
+	ready       []*memoryMessage
+	unacked     map[uint64]*memoryMessage
+	deadLetters []*memoryMessage
+	consumers   int
+	notify      chan struct{}
+}
+
+type memoryBinding struct {
+	queue string
+	key   string
+}
+
+type memoryExchange struct {
+	kind     string
+	bindings []memoryBinding
+}
+
+// MemoryBroker is an in-process implementation of RabbitMQ. It routes
+// messages through exchanges and bindings to queues, tracks unacknowledged
+// deliveries, redelivers them after a simulated outage and dead-letters
+// rejected ones, so the rest of the service can run and be tested without a
+// broker.
+type MemoryBroker struct {
+	config  *config.AMQP
+	encoder *encoder
+	state   *stateNotifier
+	logger  logger.Logger
+
+	mu        sync.Mutex
+	exchanges map[string]*memoryExchange
+	queues    map[string]*memoryQueue
+	published []*memoryMessage
+	nextTag   uint64
+	// delayed holds the timers of delayed messages not routed yet.
+	delayed map[*time.Timer]struct{}
+
+	rpcServers map[string]*rpcServer
+}
+
+func NewMemoryBroker(opts *Opts) *MemoryBroker {
+	if opts.App == nil {
+		opts.App = &config.App{}
+	}
+
+	m := &MemoryBroker{
+		config:     opts.Config,
+		rpcServers: map[string]*rpcServer{},
+		encoder:    newEncoder(opts.Config, opts.App, opts.Logger),
+		state:      newStateNotifier(),
+		logger:     opts.Logger,
+		exchanges:  map[string]*memoryExchange{"": {kind: ExchangeDirect}},
+		queues:     map[string]*memoryQueue{},
+		delayed:    map[*time.Timer]struct{}{},
+	}
+	m.state.Set(StateConnected)
+
+	return m
+}
+
+func (m *MemoryBroker) Health() error {
+	if m.state.Current() != StateConnected {
+		return errors.New("amqp healthcheck failed")
+	}
+
+	return nil
+}
+
+func (m *MemoryBroker) MaintainConnection(ctx context.Context) {
+	<-ctx.Done()
+	m.state.Set(StateClosed)
+}
+
+// Close disconnects the broker. Everything is delivered synchronously, so
+// there is nothing to drain; delayed messages not routed yet are dropped.
+func (m *MemoryBroker) Close(ctx context.Context) error {
+	m.mu.Lock()
+	m.stopDelayed()
+	m.mu.Unlock()
+
+	m.state.Set(StateClosed)
+
+	return nil
+}
+
+func (m *MemoryBroker) SubscribeState() (<-chan ConnectionState, func()) {
+	return m.state.Subscribe()
+}
+
+// Publish applies opts as the AMQP driver would. A delayed message is routed
+// once its delay has passed; an error routing it then is only logged.
+func (m *MemoryBroker) Publish(ctx context.Context, queue string, message *MessageType, opts ...PublishOption) error {
+	m.DeclareQueue(queue)
+
+	return m.publishExchange(ctx, "", queue, message, newPublishOptions(opts))
+}
+
+func (m *MemoryBroker) PublishBatch(ctx context.Context, queue string, messages []*MessageType, opts ...PublishOption) []error {
+	errs := make([]error, len(messages))
+	for i, message := range messages {
+		errs[i] = m.Publish(ctx, queue, message, opts...)
+	}
+
+	return errs
+}
+
+// PublishExchange encodes message exactly as the AMQP driver would and routes
+// it through exchange.
+func (m *MemoryBroker) PublishExchange(ctx context.Context, exchange, key string, message *MessageType) error {
+	return m.publishExchange(ctx, exchange, key, message, &publishOptions{})
+}
+
+func (m *MemoryBroker) publishExchange(ctx context.Context, exchange, key string, message *MessageType, o *publishOptions) error {
+	m.encoder.stamp(ctx, message)
+
+	publishing, err := m.encoder.encode(message)
+	if err != nil {
+		return err
+	}
+	if err := o.apply(&publishing); err != nil {
+		return err
+	}
+	injectTraceContext(ctx, publishing.Headers)
+
+	if o.delay > 0 {
+		if m.state.Current() != StateConnected {
+			return ErrNotConnected
+		}
+
+		// The lock is held until the timer is tracked, which the callback
+		// waits for.
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		publishing.DeliveryMode = amqplib.Persistent
+
+		var timer *time.Timer
+		timer = time.AfterFunc(o.delay, func() {
+			m.mu.Lock()
+			_, pending := m.delayed[timer]
+			delete(m.delayed, timer)
+			m.mu.Unlock()
+
+			if !pending {
+				return
+			}
+			if err := m.route(exchange, key, publishing); err != nil {
+				m.logger.Error("AMQP failed to route delayed message", logger.Field{Key: "routing_key", Value: key}, logger.Field{Key: "error", Value: err.Error()})
+			}
+		})
+		m.delayed[timer] = struct{}{}
+
+		return nil
+	}
+
+	return m.route(exchange, key, publishing)
+}
+
+func (m *MemoryBroker) Consume(ctx context.Context, queue string, handler Handler) error {
+	m.mu.Lock()
+	q := m.declareQueue(queue)
+	q.consumers++
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		q.consumers--
+		m.mu.Unlock()
+	}()
+
+	states, unsubscribe := m.state.Subscribe()
+	defer unsubscribe()
+
+	for {
+		msg, notify := m.next(queue)
+		if msg == nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-notify:
+			case <-states:
+			}
+			continue
+		}
+
+		d := &Delivery{Delivery: amqplib.Delivery{
+			Acknowledger:    &memoryAcknowledger{broker: m, queue: queue},
+			Headers:         msg.publishing.Headers,
+			ContentType:     msg.publishing.ContentType,
+			ContentEncoding: msg.publishing.ContentEncoding,
+			DeliveryMode:    msg.publishing.DeliveryMode,
+			Priority:        msg.publishing.Priority,
+			CorrelationId:   msg.publishing.CorrelationId,
+			ReplyTo:         msg.publishing.ReplyTo,
+			Expiration:      msg.publishing.Expiration,
+			MessageId:       msg.publishing.MessageId,
+			Timestamp:       msg.publishing.Timestamp,
+			Type:            msg.publishing.Type,
+			UserId:          msg.publishing.UserId,
+			AppId:           msg.publishing.AppId,
+			DeliveryTag:     msg.tag,
+			Redelivered:     msg.redelivered,
+			Exchange:        msg.exchange,
+			RoutingKey:      msg.routingKey,
+			Body:            msg.publishing.Body,
+		}}
+
+		handleDelivery(ctx, m.logger, d, handler)
+	}
+}
+
+// Call runs the handler served on queue in-process, passing the request and
+// reply through the same wire encoding as the AMQP driver.
+func (m *MemoryBroker) Call(ctx context.Context, queue, pattern string, payload any) (json.RawMessage, error) {
+	if m.state.Current() != StateConnected {
+		return nil, ErrNotConnected
+	}
+
+	m.mu.Lock()
+	server, ok := m.rpcServers[queue]
+	m.mu.Unlock()
+
+	if !ok {
+		return nil, ErrQueueNotFound
+	}
+
+	publishing, _, err := newRPCRequest(ctx, pattern, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Delivery{Delivery: amqplib.Delivery{
+		Headers:       publishing.Headers,
+		ContentType:   publishing.ContentType,
+		CorrelationId: publishing.CorrelationId,
+		ReplyTo:       publishing.ReplyTo,
+		MessageId:     publishing.MessageId,
+		Timestamp:     publishing.Timestamp,
+		Type:          publishing.Type,
+		RoutingKey:    queue,
+		Body:          publishing.Body,
+	}}
+
+	replies := make(chan *rpcResponse, 1)
+	err = server.handle(ctx, d, func(ctx context.Context, replyTo string, msg amqplib.Publishing) error {
+		var res rpcResponse
+		if err := json.Unmarshal(msg.Body, &res); err != nil {
+			return err
+		}
+		replies <- &res
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return awaitRPCResponse(ctx, replies)
+}
+
+// Serve registers handler for pattern on the configured RPC queue.
+func (m *MemoryBroker) Serve(pattern string, handler RPCHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	server, ok := m.rpcServers[m.config.RPCQueue]
+	if !ok {
+		server = newRPCServer()
+		m.rpcServers[m.config.RPCQueue] = server
+	}
+	server.register(pattern, handler)
+}
+
+// CollectQuarantine moves messages from the configured quarantine and
+// dead-letter queues into store until ctx is cancelled.
+func (m *MemoryBroker) CollectQuarantine(ctx context.Context, store QuarantineStore) error {
+	return collectQueues(ctx, m.config.Quarantine, func(ctx context.Context, queue string) error {
+		return m.Consume(ctx, queue, func(ctx context.Context, d *Delivery) error {
+			return collectQuarantined(ctx, m.logger, store, d, m.config.ConnectionRetryInterval)
+		})
+	})
+}
+
+// Requeue routes a quarantined message back to the queue it failed on.
+func (m *MemoryBroker) Requeue(ctx context.Context, msg *QuarantinedMessage) error {
+	return m.route("", msg.Queue, msg.requeuePublishing())
+}
+
+func (m *MemoryBroker) DeclareExchange(name, kind string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.exchanges[name]; !ok {
+		m.exchanges[name] = &memoryExchange{kind: kind}
+	}
+}
+
+func (m *MemoryBroker) DeclareQueue(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.declareQueue(name)
+}
+
+func (m *MemoryBroker) BindQueue(queue, key, exchange string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ex, ok := m.exchanges[exchange]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrExchangeNotFound, exchange)
+	}
+	if _, ok := m.queues[queue]; !ok {
+		return fmt.Errorf("%w: %s", ErrQueueNotFound, queue)
+	}
+
+	ex.bindings = append(ex.bindings, memoryBinding{queue: queue, key: key})
+
+	return nil
+}
+
+// SimulateOutage drops the simulated connection. Publishing fails, consumers
+// pause and every unacknowledged delivery is requeued as redelivered, in
+// delivery order ahead of the ready messages, the way the broker behaves when
+// a client connection is lost.
+func (m *MemoryBroker) SimulateOutage() {
+	m.mu.Lock()
+	for _, q := range m.queues {
+		requeued := make([]*memoryMessage, 0, len(q.unacked)+len(q.ready))
+		for _, tag := range slices.Sorted(maps.Keys(q.unacked)) {
+			msg := q.unacked[tag]
+			msg.redelivered = true
+			requeued = append(requeued, msg)
+		}
+		q.ready = append(requeued, q.ready...)
+		q.unacked = map[uint64]*memoryMessage{}
+	}
+	m.mu.Unlock()
+
+	m.state.Set(StateDisconnected)
+}
+
+func (m *MemoryBroker) Restore() {
+	m.state.Set(StateConnected)
+}
+
+// QueueDepth returns the number of ready and unacknowledged messages in queue.
+func (m *MemoryBroker) QueueDepth(queue string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q, ok := m.queues[queue]
+	if !ok {
+		return 0
+	}
+
+	return len(q.ready) + len(q.unacked)
+}
+
+// QueueStats reports the ready messages, active consumers and the age of the
+// message at the head of queue, as the AMQP driver would.
+func (m *MemoryBroker) QueueStats(ctx context.Context, queue string) (*QueueStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q, ok := m.queues[queue]
+	if !ok {
+		return nil, ErrQueueNotFound
+	}
+
+	stats := &QueueStats{Messages: len(q.ready), Consumers: q.consumers}
+	if len(q.ready) > 0 {
+		stats.OldestMessageAge = time.Since(q.ready[0].publishing.Timestamp)
+	}
+
+	return stats, nil
+}
+
+// DeadLetters returns the messages consumers rejected without requeueing.
+func (m *MemoryBroker) DeadLetters(queue string) []*Delivery {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q, ok := m.queues[queue]
+	if !ok {
+		return nil
+	}
+
+	return toDeliveries(q.deadLetters)
+}
+
+// Published returns every message accepted by the broker with the given
+// pattern, or all of them when pattern is empty, in publish order.
+func (m *MemoryBroker) Published(pattern string) []*Delivery {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var out []*Delivery
+	for _, d := range toDeliveries(m.published) {
+		if pattern == "" || d.Pattern() == pattern {
+			out = append(out, d)
+		}
+	}
+
+	return out
+}
+
+// ExpectEvent asserts that an event with the given pattern was published
+// whose data contains want: every field set in want must be present with an
+// equal value, other fields are ignored. A nil want matches any data.
+func (m *MemoryBroker) ExpectEvent(t TestingT, pattern string, want any) *Delivery {
+	t.Helper()
+
+	published := m.Published(pattern)
+	for _, d := range published {
+		if want == nil {
+			return d
+		}
+
+		ok, err := dataContains(d, want)
+		if err != nil {
+			t.Errorf("decoding %q event %s: %v", pattern, d.MessageId, err)
+			return nil
+		}
+		if ok {
+			return d
+		}
+	}
+
+	t.Errorf("expected a %q event with data matching %+v, got %d %q events", pattern, want, len(published), pattern)
+
+	return nil
+}
+
+func (m *MemoryBroker) ExpectNoEvent(t TestingT, pattern string) {
+	t.Helper()
+
+	if n := len(m.Published(pattern)); n > 0 {
+		t.Errorf("expected no %q events, got %d", pattern, n)
+	}
+}
+
+// Reset removes every queue, exchange, binding and published message, and
+// drops the delayed messages not routed yet.
+func (m *MemoryBroker) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.stopDelayed()
+	m.exchanges = map[string]*memoryExchange{"": {kind: ExchangeDirect}}
+	m.queues = map[string]*memoryQueue{}
+	m.published = nil
+}
+
+// stopDelayed cancels the delayed messages. The caller holds m.mu.
+func (m *MemoryBroker) stopDelayed() {
+	for timer := range m.delayed {
+		timer.Stop()
+	}
+	m.delayed = map[*time.Timer]struct{}{}
+}
+
+func (m *MemoryBroker) route(exchange, key string, publishing amqplib.Publishing) error {
+	if m.state.Current() != StateConnected {
+		return ErrNotConnected
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ex, ok := m.exchanges[exchange]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrExchangeNotFound, exchange)
+	}
+
+	var queues []string
+	switch {
+	case exchange == "":
+		queues = []string{key}
+	default:
+		for _, b := range ex.bindings {
+			if bindingMatches(ex.kind, b.key, key) {
+				queues = append(queues, b.queue)
+			}
+		}
+	}
+
+	if publishing.Timestamp.IsZero() {
+		publishing.Timestamp = time.Now()
+	}
+	m.published = append(m.published, &memoryMessage{exchange: exchange, routingKey: key, publishing: publishing})
+
+	for _, name := range queues {
+		q, ok := m.queues[name]
+		if !ok {
+			continue
+		}
+
+		m.nextTag++
+		q.ready = append(q.ready, &memoryMessage{
+			tag:        m.nextTag,
+			exchange:   exchange,
+			routingKey: key,
+			publishing: publishing,
+		})
+
+		select {
+		case q.notify <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// next moves the head of queue to the unacknowledged set. When there is
+// nothing to deliver it returns the channel signalled on the next publish.
+func (m *MemoryBroker) next(queue string) (*memoryMessage, <-chan struct{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q := m.declareQueue(queue)
+	if m.state.Current() != StateConnected || len(q.ready) == 0 {
+		return nil, q.notify
+	}
+
+	msg := q.ready[0]
+	q.ready = q.ready[1:]
+	q.unacked[msg.tag] = msg
+
+	return msg, q.notify
+}
+
+func (m *MemoryBroker) declareQueue(name string) *memoryQueue {
+	q, ok := m.queues[name]
+	if !ok {
+		q = &memoryQueue{
+			unacked: map[uint64]*memoryMessage{},
+			notify:  make(chan struct{}, 1),
+		}
+		m.queues[name] = q
+	}
+
+	return q
+}
+
+// settle completes the delivery tag on queue: acked messages are dropped,
+// nacked ones are requeued at the head as redelivered or dead-lettered.
+func (m *MemoryBroker) settle(queue string, tag uint64, ack, requeue bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q, ok := m.queues[queue]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrQueueNotFound, queue)
+	}
+
+	msg, ok := q.unacked[tag]
+	if !ok {
+		return amqplib.ErrClosed
+	}
+	delete(q.unacked, tag)
+
+	switch {
+	case ack:
+	case requeue:
+		msg.redelivered = true
+		q.ready = append([]*memoryMessage{msg}, q.ready...)
+
+		select {
+		case q.notify <- struct{}{}:
+		default:
+		}
+	default:
+		q.deadLetters = append(q.deadLetters, msg)
+	}
+
+	return nil
+}
+
+type memoryAcknowledger struct {
+	broker *MemoryBroker
+	queue  string
+}
+
+func (a *memoryAcknowledger) Ack(tag uint64, multiple bool) error {
+	return a.broker.settle(a.queue, tag, true, false)
+}
+
+func (a *memoryAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	return a.broker.settle(a.queue, tag, false, requeue)
+}
+
+func (a *memoryAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.broker.settle(a.queue, tag, false, requeue)
+}
+
+func toDeliveries(messages []*memoryMessage) []*Delivery {
+	out := make([]*Delivery, 0, len(messages))
+	for _, msg := range messages {
+		out = append(out, &Delivery{Delivery: amqplib.Delivery{
+			Headers:         msg.publishing.Headers,
+			ContentType:     msg.publishing.ContentType,
+			ContentEncoding: msg.publishing.ContentEncoding,
+			DeliveryMode:    msg.publishing.DeliveryMode,
+			Priority:        msg.publishing.Priority,
+			CorrelationId:   msg.publishing.CorrelationId,
+			Expiration:      msg.publishing.Expiration,
+			MessageId:       msg.publishing.MessageId,
+			Timestamp:       msg.publishing.Timestamp,
+			Type:            msg.publishing.Type,
+			AppId:           msg.publishing.AppId,
+			Redelivered:     msg.redelivered,
+			Exchange:        msg.exchange,
+			RoutingKey:      msg.routingKey,
+			Body:            msg.publishing.Body,
+		}})
+	}
+
+	return out
+}
+
+// bindingMatches applies AMQP routing rules: fanout ignores the key, direct
+// compares it and topic matches dot-separated words with * and # wildcards.
+func bindingMatches(kind, pattern, key string) bool {
+	switch kind {
+	case ExchangeFanout:
+		return true
+	case ExchangeTopic:
+		return topicMatches(strings.Split(pattern, "."), strings.Split(key, "."))
+	default:
+		return pattern == key
+	}
+}
+
+func topicMatches(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(words); i++ {
+			if topicMatches(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(words) > 0 && topicMatches(pattern[1:], words[1:])
+	default:
+		return len(words) > 0 && pattern[0] == words[0] && topicMatches(pattern[1:], words[1:])
+	}
+}
+
+func dataContains(d *Delivery, want any) (bool, error) {
+	var got any
+	if err := d.Decode(&got); err != nil {
+		return false, err
+	}
+
+	raw, err := json.Marshal(want)
+	if err != nil {
+		return false, err
+	}
+
+	var expected any
+	if err := json.Unmarshal(raw, &expected); err != nil {
+		return false, err
+	}
+
+	return jsonContains(normalizeJSON(got), expected), nil
+}
+
+// jsonContains reports whether every field of want is present in got with an
+// equal value. Arrays must match element by element.
+func jsonContains(got, want any) bool {
+	switch w := want.(type) {
+	case map[string]any:
+		g, ok := got.(map[string]any)
+		if !ok {
+			return false
+		}
+		for k, v := range w {
+			if !jsonContains(g[k], v) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		g, ok := got.([]any)
+		if !ok || len(g) != len(w) {
+			return false
+		}
+		for i := range w {
+			if !jsonContains(g[i], w[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(got, want)
+	}
+}
+
+// normalizeJSON round-trips a decoded value through JSON so that values
+// decoded by other codecs compare equal to their JSON form.
+func normalizeJSON(v any) any {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+
+	var out any
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return v
+	}
+
+	return out
+}
@@ -0,0 +1,70 @@
+package rabbitmq
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/config"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+)
+
+func newTestMemoryBroker(t *testing.T) *MemoryBroker {
+	t.Helper()
+
+	m := NewMemoryBroker(&Opts{
+		Logger: logger.NewZerologLogger("error", nil),
+		Config: &config.AMQP{DefaultCodec: "json"},
+	})
+	t.Cleanup(func() { _ = m.Close(context.Background()) })
+
+	return m
+}
+
+func TestMemoryBrokerOutageRequeuesInDeliveryOrder(t *testing.T) {
+	m := newTestMemoryBroker(t)
+
+	for range 20 {
+		if err := m.Publish(context.Background(), "orders", &MessageType{Pattern: "order.created"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for range 10 {
+		if msg, _ := m.next("orders"); msg == nil {
+			t.Fatal("queue drained early")
+		}
+	}
+
+	m.SimulateOutage()
+	m.Restore()
+
+	q := m.queues["orders"]
+	if len(q.ready) != 20 || len(q.unacked) != 0 {
+		t.Fatalf("got %d ready and %d unacked, want 20 ready", len(q.ready), len(q.unacked))
+	}
+	for i, msg := range q.ready {
+		if i > 0 && msg.tag < q.ready[i-1].tag {
+			t.Fatalf("message %d has tag %d after tag %d", i, msg.tag, q.ready[i-1].tag)
+		}
+		if want := i < 10; msg.redelivered != want {
+			t.Errorf("message %d redelivered = %v, want %v", i, msg.redelivered, want)
+		}
+	}
+}
+
+func TestMemoryBrokerResetDropsDelayedMessages(t *testing.T) {
+	m := newTestMemoryBroker(t)
+
+	err := m.Publish(context.Background(), "orders", &MessageType{Pattern: "order.created"}, WithDelay(20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.Reset()
+	time.Sleep(50 * time.Millisecond)
+
+	m.ExpectNoEvent(t, "order.created")
+	if len(m.delayed) != 0 {
+		t.Errorf("%d delayed timers left after reset", len(m.delayed))
+	}
+}
@@ -0,0 +1,160 @@
+package rabbitmq
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+)
+
+var (
+	spoolAppendedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "amqp_spool_appended_total",
+		Help: "Messages written to the publish spool while the broker was unavailable.",
+	})
+	spoolDrainedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "amqp_spool_drained_total",
+		Help: "Spooled messages successfully published to the broker.",
+	})
+	spoolRejectedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "amqp_spool_rejected_total",
+		Help: "Messages rejected because the publish spool was full.",
+	})
+	spoolCorruptSegmentsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "amqp_spool_corrupt_segments_total",
+		Help: "Spool segments found corrupt before their end on startup, losing the records after the corruption.",
+	})
+	spooling = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "amqp_spooling",
+		Help: "Whether the broker is unavailable and publishes go to the spool (1) or not (0), as of the last health check.",
+	})
+
+	nodeUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "amqp_node_up",
+		Help: "Whether the client is connected to the broker node (1) or not (0).",
+	}, []string{"node"})
+	nodeConnectFailuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "amqp_node_connect_failures_total",
+		Help: "Failed connection attempts per broker node.",
+	}, []string{"node"})
+	failoversTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "amqp_failovers_total",
+		Help: "Reconnects that landed on a different broker node than the previous connection.",
+	}, []string{"from", "to"})
+
+	credentialRotationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "amqp_credential_rotations_total",
+		Help: "Connections replaced after the credentials changed, by result.",
+	}, []string{"result"})
+
+	queueMessages = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "amqp_queue_messages",
+		Help: "Messages ready for delivery in a monitored queue.",
+	}, []string{"queue"})
+	queueConsumers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "amqp_queue_consumers",
+		Help: "Consumers attached to a monitored queue.",
+	}, []string{"queue"})
+	queueOldestMessageAge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "amqp_queue_oldest_message_age_seconds",
+		Help: "Age of the message at the head of a monitored queue, when known.",
+	}, []string{"queue"})
+	connectionBlocked = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "amqp_connection_blocked",
+		Help: "Whether the broker is blocking the connection for flow control (1) or not (0).",
+	})
+
+	dedupDuplicatesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "amqp_dedup_duplicates_total",
+		Help: "Redelivered messages acknowledged without running the handler again.",
+	}, []string{"event"})
+	dedupStoreErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "amqp_dedup_store_errors_total",
+		Help: "Failures of the deduplication store.",
+	})
+	dedupStoreSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "amqp_dedup_store_size",
+		Help: "Message ids held by the in-memory deduplication store.",
+	})
+
+	signatureFailuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "amqp_signature_failures_total",
+		Help: "Consumed messages that were unsigned or failed signature verification.",
+	}, []string{"reason"})
+	quarantinedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "amqp_quarantined_total",
+		Help: "Messages moved to the quarantine queue instead of being handled.",
+	}, []string{"queue"})
+	retriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "amqp_retries_total",
+		Help: "Failed messages published again to be retried.",
+	}, []string{"queue"})
+	requeuedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "amqp_requeued_total",
+		Help: "Quarantined messages published back to the queue they failed on.",
+	}, []string{"queue"})
+
+	archiveErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "amqp_archive_errors_total",
+		Help: "Published messages that could not be written to the archive.",
+	})
+
+	publishBatchSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "amqp_publish_batch_size",
+		Help:    "Messages per batch sent by the auto-batching publisher.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 10),
+	})
+)
+
+var (
+	spoolDepthDesc = prometheus.NewDesc(
+		"amqp_spool_depth",
+		"Messages waiting in the publish spool.",
+		nil, nil,
+	)
+	spoolBytesDesc = prometheus.NewDesc(
+		"amqp_spool_bytes",
+		"Bytes used on disk by the publish spool.",
+		nil, nil,
+	)
+	spoolOldestAgeDesc = prometheus.NewDesc(
+		"amqp_spool_oldest_message_age_seconds",
+		"Age of the oldest message waiting in the publish spool.",
+		nil, nil,
+	)
+)
+
+// registerSpoolCollector exports the gauges of s, replacing the collector of
+// a spool opened earlier in the process.
+func registerSpoolCollector(s *spool) {
+	c := &spoolCollector{spool: s}
+
+	err := prometheus.Register(c)
+	var registered prometheus.AlreadyRegisteredError
+	if errors.As(err, &registered) {
+		prometheus.Unregister(registered.ExistingCollector)
+		err = prometheus.Register(c)
+	}
+	if err != nil {
+		s.logger.Error("AMQP failed to register spool metrics", logger.Field{Key: "error", Value: err.Error()})
+	}
+}
+
+// spoolCollector reads spool gauges at scrape time so the oldest message age
+// keeps growing while the broker is down, without a ticker updating it.
+type spoolCollector struct {
+	spool *spool
+}
+
+func (c *spoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- spoolDepthDesc
+	ch <- spoolBytesDesc
+	ch <- spoolOldestAgeDesc
+}
+
+func (c *spoolCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(spoolDepthDesc, prometheus.GaugeValue, float64(c.spool.Depth()))
+	ch <- prometheus.MustNewConstMetric(spoolBytesDesc, prometheus.GaugeValue, float64(c.spool.Size()))
+	ch <- prometheus.MustNewConstMetric(spoolOldestAgeDesc, prometheus.GaugeValue, c.spool.OldestAge().Seconds())
+}
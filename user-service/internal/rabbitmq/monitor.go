@@ -0,0 +1,198 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/config"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+)
+
+var ErrConnectionBlocked = errors.New("amqp connection is blocked by broker flow control")
+
+type QueueStats struct {
+	Messages  int
+	Consumers int
+	// OldestMessageAge is zero when the queue is empty or the age is unknown.
+	OldestMessageAge time.Duration
+}
+
+// QueueStats inspects queue with a passive declare, which neither creates
+// the queue nor touches its messages. The oldest message age is only known
+// when the management API is configured, from the timestamp of the message
+// at the head of the queue.
+func (b *rabbitmq) QueueStats(ctx context.Context, queue string) (*QueueStats, error) {
+	b.mu.RLock()
+	conn := b.conn
+	b.mu.RUnlock()
+
+	if conn == nil || conn.IsClosed() {
+		return nil, ErrNotConnected
+	}
+
+	// A passive declare of a missing queue closes the channel, so every
+	// inspection gets its own.
+	channel, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	defer channel.Close()
+
+	q, err := channel.QueueDeclarePassive(queue, false, false, false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &QueueStats{Messages: q.Messages, Consumers: q.Consumers}
+
+	if b.config.ManagementURL != "" && q.Messages > 0 {
+		age, err := b.headMessageAge(ctx, queue)
+		if err != nil {
+			b.logger.Warn("AMQP failed to read queue head from management API",
+				logger.Field{Key: "queue", Value: queue},
+				logger.Field{Key: "error", Value: redact(err.Error(), b.brokerConfig())},
+			)
+		}
+		stats.OldestMessageAge = age
+	}
+
+	return stats, nil
+}
+
+func (b *rabbitmq) headMessageAge(ctx context.Context, queue string) (time.Duration, error) {
+	var body struct {
+		HeadMessageTimestamp *int64 `json:"head_message_timestamp"`
+	}
+
+	path := "/queues/{vhost}/" + url.PathEscape(queue) + "?columns=head_message_timestamp"
+	if err := management(ctx, b.brokerConfig(), http.MethodGet, path, nil, &body); err != nil {
+		return 0, err
+	}
+
+	if body.HeadMessageTimestamp == nil {
+		return 0, nil
+	}
+
+	return time.Since(time.Unix(*body.HeadMessageTimestamp, 0)), nil
+}
+
+type QueueMonitorOpts struct {
+	RabbitMQ RabbitMQ
+	Config   *config.AMQPMonitor
+	Logger   logger.Logger
+}
+
+// QueueMonitor periodically inspects the configured queues and the
+// connection flow-control state. Queues with too large or too old a backlog,
+// or too few consumers, are reported as unhealthy; callers treat that as
+// degraded rather than down, since publishing still works.
+type QueueMonitor struct {
+	rabbitmq RabbitMQ
+	config   *config.AMQPMonitor
+	logger   logger.Logger
+
+	mu      sync.RWMutex
+	results map[string]error
+	blocked bool
+}
+
+func NewQueueMonitor(opts *QueueMonitorOpts) *QueueMonitor {
+	return &QueueMonitor{
+		rabbitmq: opts.RabbitMQ,
+		config:   opts.Config,
+		logger:   opts.Logger,
+		results:  map[string]error{},
+	}
+}
+
+// Run inspects the queues every interval until ctx is cancelled.
+func (m *QueueMonitor) Run(ctx context.Context) {
+	states, unsubscribe := m.rabbitmq.SubscribeState()
+	defer unsubscribe()
+
+	ticker := time.NewTicker(m.config.Interval)
+	defer ticker.Stop()
+
+	m.inspect(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case state := <-states:
+			m.mu.Lock()
+			m.blocked = state == StateBlocked
+			m.mu.Unlock()
+
+			if state == StateBlocked {
+				connectionBlocked.Set(1)
+			} else {
+				connectionBlocked.Set(0)
+			}
+		case <-ticker.C:
+			m.inspect(ctx)
+		}
+	}
+}
+
+// QueueHealth returns why queue breached a threshold at the last inspection,
+// or nil if it did not.
+func (m *QueueMonitor) QueueHealth(queue string) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.results[queue]
+}
+
+// FlowControl returns ErrConnectionBlocked while the broker is throttling
+// publishers because it is low on memory or disk.
+func (m *QueueMonitor) FlowControl() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.blocked {
+		return ErrConnectionBlocked
+	}
+
+	return nil
+}
+
+func (m *QueueMonitor) inspect(ctx context.Context) {
+	for _, queue := range m.config.Queues {
+		err := m.inspectQueue(ctx, queue)
+		if err != nil {
+			m.logger.Warn("AMQP queue check failed", logger.Field{Key: "queue", Value: queue}, logger.Field{Key: "error", Value: err.Error()})
+		}
+
+		m.mu.Lock()
+		m.results[queue] = err
+		m.mu.Unlock()
+	}
+}
+
+func (m *QueueMonitor) inspectQueue(ctx context.Context, queue string) error {
+	stats, err := m.rabbitmq.QueueStats(ctx, queue)
+	if err != nil {
+		return err
+	}
+
+	queueMessages.WithLabelValues(queue).Set(float64(stats.Messages))
+	queueConsumers.WithLabelValues(queue).Set(float64(stats.Consumers))
+	queueOldestMessageAge.WithLabelValues(queue).Set(stats.OldestMessageAge.Seconds())
+
+	switch {
+	case m.config.MaxMessages > 0 && stats.Messages > m.config.MaxMessages:
+		return fmt.Errorf("%d messages waiting, more than %d", stats.Messages, m.config.MaxMessages)
+	case stats.Consumers < m.config.MinConsumers:
+		return fmt.Errorf("%d consumers, fewer than %d", stats.Consumers, m.config.MinConsumers)
+	case m.config.MaxMessageAge > 0 && stats.OldestMessageAge > m.config.MaxMessageAge:
+		return fmt.Errorf("oldest message is %s old, more than %s", stats.OldestMessageAge.Round(time.Second), m.config.MaxMessageAge)
+	}
+
+	return nil
+}
@@ -0,0 +1,154 @@
+package rabbitmq
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+)
+
+const (
+	NodeSelectionRoundRobin = "round-robin"
+	NodeSelectionPriority   = "priority"
+)
+
+// node is one broker endpoint of a cluster. failures counts the consecutive
+// connection attempts to it that failed.
+type node struct {
+	address  string
+	failures int
+}
+
+// nodeSet orders the configured broker endpoints for each connection attempt
+// and tracks their health. Round-robin starts after the node used last, so
+// reconnects spread across the cluster; priority always prefers the first
+// healthy node in configuration order.
+type nodeSet struct {
+	mu       sync.Mutex
+	nodes    []*node
+	policy   string
+	next     int
+	current  *node
+	previous *node
+}
+
+func newNodeSet(addresses []string, policy string) *nodeSet {
+	s := &nodeSet{policy: policy}
+	for _, address := range addresses {
+		s.nodes = append(s.nodes, &node{address: address})
+		nodeUp.WithLabelValues(address).Set(0)
+	}
+
+	return s
+}
+
+// candidates returns every node in the order they should be tried.
+func (s *nodeSet) candidates() []*node {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ordered := make([]*node, 0, len(s.nodes))
+
+	if s.policy == NodeSelectionPriority {
+		// Nodes that failed last time are tried after the healthy ones, still
+		// in configuration order.
+		for _, n := range s.nodes {
+			if n.failures == 0 {
+				ordered = append(ordered, n)
+			}
+		}
+		for _, n := range s.nodes {
+			if n.failures > 0 {
+				ordered = append(ordered, n)
+			}
+		}
+
+		return ordered
+	}
+
+	for i := range s.nodes {
+		ordered = append(ordered, s.nodes[(s.next+i)%len(s.nodes)])
+	}
+
+	return ordered
+}
+
+// connected records n as the node in use and reports the node it replaced
+// when this is a failover to a different node.
+func (s *nodeSet) connected(n *node) (*node, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n.failures = 0
+	nodeUp.WithLabelValues(n.address).Set(1)
+
+	for i, candidate := range s.nodes {
+		if candidate == n {
+			s.next = (i + 1) % len(s.nodes)
+		}
+	}
+
+	previous := s.previous
+	s.current = n
+	s.previous = n
+
+	return previous, previous != nil && previous != n
+}
+
+func (s *nodeSet) failed(n *node) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n.failures++
+	nodeUp.WithLabelValues(n.address).Set(0)
+	nodeConnectFailuresTotal.WithLabelValues(n.address).Inc()
+}
+
+// disconnected marks the node in use as down after its connection closed.
+func (s *nodeSet) disconnected() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.current == nil {
+		return
+	}
+
+	nodeUp.WithLabelValues(s.current.address).Set(0)
+	s.current = nil
+}
+
+// nodeAddresses returns the configured endpoints, or the single endpoint of
+// the base address when none are configured.
+func nodeAddresses(hosts []string, base string) []string {
+	if len(hosts) > 0 {
+		return hosts
+	}
+
+	u, err := url.Parse(base)
+	if err != nil {
+		return []string{base}
+	}
+
+	return []string{u.Host}
+}
+
+// withNode points the base address at the given host:port endpoint, keeping
+// its scheme, credentials, vhost and query. A bare host gets the scheme's
+// default port.
+func withNode(base, address string) string {
+	u, err := url.Parse(base)
+	if err != nil {
+		return base
+	}
+
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		port := 5672
+		if u.Scheme == "amqps" {
+			port = 5671
+		}
+		address = net.JoinHostPort(address, strconv.Itoa(port))
+	}
+	u.Host = address
+
+	return u.String()
+}
@@ -0,0 +1,88 @@
+package rabbitmq
+
+import (
+	"strconv"
+	"time"
+
+	amqplib "github.com/rabbitmq/amqp091-go"
+)
+
+// PublishOption sets an AMQP property or delivery option of a published
+// message.
+type PublishOption func(*publishOptions)
+
+type publishOptions struct {
+	ttl      time.Duration
+	priority uint8
+	headers  amqplib.Table
+	delay    time.Duration
+}
+
+// WithTTL discards the message if it has not been consumed within ttl of
+// reaching its queue.
+func WithTTL(ttl time.Duration) PublishOption {
+	return func(o *publishOptions) {
+		o.ttl = ttl
+	}
+}
+
+// WithPriority sets the message priority. It only changes the delivery order
+// on queues declared with a maximum priority, see QueueSpec.MaxPriority.
+func WithPriority(priority uint8) PublishOption {
+	return func(o *publishOptions) {
+		o.priority = priority
+	}
+}
+
+// WithHeaders adds headers to the message. They cannot replace the trace
+// context and signature headers, which are set afterwards.
+func WithHeaders(headers map[string]any) PublishOption {
+	return func(o *publishOptions) {
+		if o.headers == nil {
+			o.headers = amqplib.Table{}
+		}
+		for k, v := range headers {
+			o.headers[k] = v
+		}
+	}
+}
+
+// WithDelay delivers the message to its queue once delay has passed.
+func WithDelay(delay time.Duration) PublishOption {
+	return func(o *publishOptions) {
+		o.delay = delay
+	}
+}
+
+func newPublishOptions(opts []PublishOption) *publishOptions {
+	o := &publishOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// apply sets the options' properties and headers on msg.
+func (o *publishOptions) apply(msg *amqplib.Publishing) error {
+	if len(o.headers) > 0 {
+		if err := o.headers.Validate(); err != nil {
+			return err
+		}
+		if msg.Headers == nil {
+			msg.Headers = amqplib.Table{}
+		}
+		for k, v := range o.headers {
+			msg.Headers[k] = v
+		}
+	}
+
+	if o.ttl > 0 {
+		msg.Expiration = strconv.FormatInt(o.ttl.Milliseconds(), 10)
+	}
+	if o.priority > 0 {
+		msg.Priority = o.priority
+	}
+
+	return nil
+}
@@ -0,0 +1,202 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	amqplib "github.com/rabbitmq/amqp091-go"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+)
+
+var ErrPoolClosed = errors.New("amqp channel pool is closed")
+
+// channelPool hands out confirm-mode channels of a single connection. Every
+// slot is either nil (not opened yet or discarded) or a channel that was
+// healthy when it was returned, so a checkout never blocks on a dead channel.
+type channelPool struct {
+	conn   *amqplib.Connection
+	slots  chan *pooledChannel
+	done   chan struct{}
+	once   sync.Once
+	logger logger.Logger
+
+	// open and close default to opening a confirm-mode channel on conn and
+	// closing it.
+	open  func() (*pooledChannel, error)
+	close func(c *pooledChannel) error
+
+	// checkouts counts the checkouts not released yet. A publish releases
+	// its checkout once confirmed, after returning the channel, so that
+	// Drain waits for the confirms.
+	mu        sync.RWMutex
+	draining  bool
+	checkouts sync.WaitGroup
+
+	// closed is set under mu by Close, so that Put cannot return a channel
+	// to a slot after Close emptied them.
+	closed bool
+}
+
+type pooledChannel struct {
+	*amqplib.Channel
+	healthy atomic.Bool
+}
+
+// usable reports whether c can be handed out. The health flag is cleared by
+// the NotifyClose listener, which can lag behind the channel closing.
+func (c *pooledChannel) usable() bool {
+	return c.healthy.Load() && !c.IsClosed()
+}
+
+func newChannelPool(conn *amqplib.Connection, size int, log logger.Logger) *channelPool {
+	if size < 1 {
+		size = 1
+	}
+
+	p := &channelPool{
+		conn:   conn,
+		slots:  make(chan *pooledChannel, size),
+		done:   make(chan struct{}),
+		logger: log,
+	}
+	p.open = p.openChannel
+	p.close = func(c *pooledChannel) error { return c.Close() }
+
+	for range size {
+		p.slots <- nil
+	}
+
+	return p
+}
+
+// Get checks out a channel, opening a new one when the slot is empty or the
+// channel it held was closed by the broker. A successful checkout must be
+// released with Release.
+func (p *channelPool) Get(ctx context.Context) (*pooledChannel, error) {
+	p.mu.RLock()
+	if p.draining {
+		p.mu.RUnlock()
+		return nil, ErrPoolClosed
+	}
+	p.checkouts.Add(1)
+	p.mu.RUnlock()
+
+	c, err := p.get(ctx)
+	if err != nil {
+		p.checkouts.Done()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (p *channelPool) get(ctx context.Context) (*pooledChannel, error) {
+	select {
+	case <-p.done:
+		return nil, ErrPoolClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case c := <-p.slots:
+		if c != nil && c.usable() {
+			return c, nil
+		}
+
+		c, err := p.open()
+		if err != nil {
+			p.slots <- nil
+			return nil, err
+		}
+
+		return c, nil
+	}
+}
+
+// Release ends a checkout.
+func (p *channelPool) Release() {
+	p.checkouts.Done()
+}
+
+// Drain stops handing out channels, waits for the checkouts to be released
+// and closes the pool.
+func (p *channelPool) Drain() {
+	p.mu.Lock()
+	p.draining = true
+	p.mu.Unlock()
+
+	p.checkouts.Wait()
+	p.Close()
+}
+
+// Put returns a channel to the pool. Unhealthy channels are discarded and their
+// slot is refilled lazily on the next checkout.
+func (p *channelPool) Put(c *pooledChannel) {
+	if c != nil && !c.usable() {
+		c = nil
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		if c != nil {
+			_ = p.close(c)
+		}
+		return
+	}
+
+	p.slots <- c
+}
+
+// Close closes every idle channel. Channels that are checked out are closed
+// when they are returned.
+func (p *channelPool) Close() {
+	p.once.Do(func() {
+		p.mu.Lock()
+		p.closed = true
+		p.mu.Unlock()
+
+		close(p.done)
+
+		for {
+			select {
+			case c := <-p.slots:
+				if c != nil && c.usable() {
+					if err := p.close(c); err != nil {
+						p.logger.Error("AMQP failed to close channel", logger.Field{Key: "error", Value: err.Error()})
+					}
+				}
+			default:
+				return
+			}
+		}
+	})
+}
+
+func (p *channelPool) openChannel() (*pooledChannel, error) {
+	ch, err := p.conn.Channel()
+	if err != nil {
+		p.logger.Error("AMQP channel error", logger.Field{Key: "error", Value: err.Error()})
+		return nil, err
+	}
+
+	if err := ch.Confirm(false); err != nil {
+		p.logger.Error("AMQP failed to enable publisher confirms", logger.Field{Key: "error", Value: err.Error()})
+		_ = ch.Close()
+		return nil, err
+	}
+
+	c := &pooledChannel{Channel: ch}
+	c.healthy.Store(true)
+
+	closeCh := ch.NotifyClose(make(chan *amqplib.Error, 1))
+	go func() {
+		if err, ok := <-closeCh; ok && err != nil {
+			p.logger.Warn("AMQP pooled channel closed", logger.Field{Key: "error", Value: err.Error()})
+		}
+		c.healthy.Store(false)
+	}()
+
+	return c, nil
+}
@@ -0,0 +1,186 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	amqplib "github.com/rabbitmq/amqp091-go"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+)
+
+// fakeChannels replaces how a pool opens and closes channels, so it can be
+// exercised without a broker.
+type fakeChannels struct {
+	opened atomic.Int64
+	closed atomic.Int64
+	inUse  sync.Map
+}
+
+func newTestPool(t *testing.T, size int) (*channelPool, *fakeChannels) {
+	t.Helper()
+
+	f := &fakeChannels{}
+	p := newChannelPool(nil, size, logger.NewZerologLogger("error", nil))
+	p.open = func() (*pooledChannel, error) {
+		f.opened.Add(1)
+		c := &pooledChannel{Channel: &amqplib.Channel{}}
+		c.healthy.Store(true)
+		f.inUse.Store(c, new(atomic.Bool))
+		return c, nil
+	}
+	p.close = func(c *pooledChannel) error {
+		f.closed.Add(1)
+		return nil
+	}
+
+	return p, f
+}
+
+func TestChannelPoolConcurrentCheckouts(t *testing.T) {
+	p, f := newTestPool(t, 4)
+
+	var wg sync.WaitGroup
+	for range 32 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				c, err := p.Get(context.Background())
+				if errors.Is(err, ErrPoolClosed) {
+					return
+				}
+				if err != nil {
+					t.Errorf("Get: %v", err)
+					return
+				}
+
+				v, _ := f.inUse.Load(c)
+				inUse := v.(*atomic.Bool)
+				if !inUse.CompareAndSwap(false, true) {
+					t.Error("channel checked out twice")
+				}
+				inUse.Store(false)
+
+				p.Put(c)
+				p.Release()
+			}
+		}()
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	p.Drain()
+	wg.Wait()
+
+	if opened := f.opened.Load(); opened > 4 {
+		t.Errorf("opened %d channels, want at most the pool size 4", opened)
+	}
+	if opened, closed := f.opened.Load(), f.closed.Load(); opened != closed {
+		t.Errorf("opened %d channels but closed %d", opened, closed)
+	}
+}
+
+func TestChannelPoolDiscardsUnhealthyChannel(t *testing.T) {
+	p, f := newTestPool(t, 1)
+
+	c, err := p.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.healthy.Store(false)
+	p.Put(c)
+	p.Release()
+
+	next, err := p.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next == c {
+		t.Error("got the unhealthy channel back")
+	}
+	if opened := f.opened.Load(); opened != 2 {
+		t.Errorf("opened %d channels, want 2", opened)
+	}
+}
+
+func TestChannelPoolDrainWaitsForRelease(t *testing.T) {
+	p, _ := newTestPool(t, 1)
+
+	c, err := p.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A publish returns its channel before waiting for the confirm.
+	p.Put(c)
+
+	drained := make(chan struct{})
+	go func() {
+		p.Drain()
+		close(drained)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	if _, err := p.Get(context.Background()); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("Get while draining: got %v, want ErrPoolClosed", err)
+	}
+
+	select {
+	case <-drained:
+		t.Fatal("Drain returned before the checkout was released")
+	default:
+	}
+
+	p.Release()
+
+	select {
+	case <-drained:
+	case <-time.After(time.Second):
+		t.Fatal("Drain did not return after the checkout was released")
+	}
+}
+
+func TestChannelPoolGetHonoursContext(t *testing.T) {
+	p, _ := newTestPool(t, 1)
+
+	if _, err := p.Get(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := p.Get(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Get on an exhausted pool: got %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestChannelPoolPutAfterCloseClosesChannel(t *testing.T) {
+	p, f := newTestPool(t, 2)
+
+	idle, err := p.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	busy, err := p.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Put(idle)
+
+	p.Close()
+	if closed := f.closed.Load(); closed != 1 {
+		t.Errorf("Close closed %d channels, want the idle one", closed)
+	}
+
+	p.Put(busy)
+	if closed := f.closed.Load(); closed != 2 {
+		t.Errorf("closed %d channels, want the one returned after Close too", closed)
+	}
+	if len(p.slots) != 0 {
+		t.Errorf("%d channels left in the closed pool", len(p.slots))
+	}
+}
@@ -0,0 +1,315 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	amqplib "github.com/rabbitmq/amqp091-go"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/config"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+)
+
+const (
+	HeaderQuarantineReason   = "x-quarantine-reason"
+	HeaderQuarantineQueue    = "x-quarantine-queue"
+	HeaderQuarantinedAt      = "x-quarantined-at"
+	HeaderQuarantineAttempts = "x-quarantine-attempts"
+	HeaderQuarantineStack    = "x-quarantine-stack"
+
+	// HeaderAttempts counts the failed attempts at handling a message that
+	// was published again to be retried, and HeaderLastError has the error of
+	// the latest one.
+	HeaderAttempts  = "x-attempts"
+	HeaderLastError = "x-last-error"
+
+	// HeaderDeath and the first death headers are added by the broker to a
+	// dead-lettered message.
+	HeaderDeath              = "x-death"
+	HeaderFirstDeathQueue    = "x-first-death-queue"
+	HeaderFirstDeathReason   = "x-first-death-reason"
+	HeaderFirstDeathExchange = "x-first-death-exchange"
+
+	// maxStackBytes keeps a stack trace well within the broker's frame size.
+	maxStackBytes = 8 << 10
+)
+
+// failure is why a delivery is quarantined.
+type failure struct {
+	err      error
+	stack    string
+	attempts int
+}
+
+// retryFailures wraps handler so that a message it fails on, or panics on, is
+// published again to queue after a delay that doubles with every attempt,
+// until it has been attempted the configured number of times, and is then
+// moved to the quarantine queue with the error, the attempts and, if there is
+// one, the stack trace. Without a limit, failed messages are rejected on the
+// first failure.
+func (b *rabbitmq) retryFailures(queue string, handler Handler) Handler {
+	if b.config.Quarantine == nil || b.config.Quarantine.MaxAttempts <= 0 {
+		return handler
+	}
+	maxAttempts := b.config.Quarantine.MaxAttempts
+
+	return func(ctx context.Context, d *Delivery) error {
+		stack, err := callHandler(ctx, d, handler)
+		if err == nil || d.settled {
+			return err
+		}
+
+		attempts := headerInt(d.Headers[HeaderAttempts]) + 1
+		if attempts < maxAttempts {
+			if rerr := b.retry(ctx, queue, d, attempts, err); rerr != nil {
+				return fmt.Errorf("%w, and retrying it failed: %w", err, rerr)
+			}
+			return nil
+		}
+
+		if qerr := b.quarantine(ctx, queue, d, &failure{err: err, stack: stack, attempts: attempts}); qerr != nil {
+			return fmt.Errorf("%w, and quarantining it failed: %w", err, qerr)
+		}
+
+		return nil
+	}
+}
+
+// callHandler runs handler, turning a panic into an error with its stack
+// trace. Errors that format with a stack trace under %+v keep it too.
+func callHandler(ctx context.Context, d *Delivery, handler Handler) (stack string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			stack = string(debug.Stack())
+			err = fmt.Errorf("handler panicked: %v", r)
+		}
+	}()
+
+	err = handler(ctx, d)
+	if err != nil {
+		if s := fmt.Sprintf("%+v", err); s != err.Error() {
+			stack = s
+		}
+	}
+
+	return stack, err
+}
+
+func (b *rabbitmq) retry(ctx context.Context, queue string, d *Delivery, attempts int, reason error) error {
+	msg := deliveryPublishing(d)
+	msg.Headers[HeaderAttempts] = int64(attempts)
+	msg.Headers[HeaderLastError] = reason.Error()
+
+	ctx, cancel := context.WithTimeout(ctx, b.config.PublishTimeout)
+	defer cancel()
+
+	delay := retryDelay(b.config.Quarantine, attempts)
+
+	var err error
+	if delay > 0 {
+		err = b.sendDelayed(ctx, queue, delay, &msg)
+	} else {
+		err = b.publish(ctx, "", queue, msg)
+	}
+	if err != nil {
+		return err
+	}
+
+	retriesTotal.WithLabelValues(queue).Inc()
+	b.logger.Warn("AMQP message handling failed, retrying",
+		logger.Field{Key: "queue", Value: queue},
+		logger.Field{Key: "event", Value: d.Pattern()},
+		logger.Field{Key: "message_id", Value: d.MessageId},
+		logger.Field{Key: "attempts", Value: attempts},
+		logger.Field{Key: "delay", Value: delay.String()},
+		logger.Field{Key: "error", Value: reason.Error()},
+	)
+
+	return nil
+}
+
+// retryDelay is how long a message waits after its failed attempt number
+// attempts before it is retried.
+func retryDelay(cfg *config.AMQPQuarantine, attempts int) time.Duration {
+	delay := cfg.RetryDelay
+	for i := 1; i < attempts && delay < cfg.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if cfg.MaxRetryDelay > 0 && delay > cfg.MaxRetryDelay {
+		delay = cfg.MaxRetryDelay
+	}
+
+	return delay
+}
+
+// quarantine moves a delivery to the quarantine queue, unchanged apart from
+// headers recording why and where from, so it can be inspected without
+// being handled.
+func (b *rabbitmq) quarantine(ctx context.Context, queue string, d *Delivery, f *failure) error {
+	msg := deliveryPublishing(d)
+	msg.Headers[HeaderQuarantineReason] = f.err.Error()
+	msg.Headers[HeaderQuarantineQueue] = queue
+	msg.Headers[HeaderQuarantinedAt] = time.Now().UTC()
+	msg.Headers[HeaderQuarantineAttempts] = int64(f.attempts)
+	if f.stack != "" {
+		stack := f.stack
+		if len(stack) > maxStackBytes {
+			stack = stack[:maxStackBytes]
+		}
+		msg.Headers[HeaderQuarantineStack] = stack
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, b.config.PublishTimeout)
+	defer cancel()
+
+	if err := b.publish(ctx, "", b.config.Quarantine.Queue, msg); err != nil {
+		return err
+	}
+
+	quarantinedTotal.WithLabelValues(queue).Inc()
+	b.logger.Warn("AMQP message quarantined",
+		logger.Field{Key: "queue", Value: queue},
+		logger.Field{Key: "quarantine_queue", Value: b.config.Quarantine.Queue},
+		logger.Field{Key: "message_id", Value: d.MessageId},
+		logger.Field{Key: "attempts", Value: f.attempts},
+		logger.Field{Key: "reason", Value: f.err.Error()},
+	)
+
+	return nil
+}
+
+// CollectQuarantine moves messages from the quarantine queue and the
+// configured dead-letter queues into store until ctx is cancelled. Messages
+// are not signature checked, since many are there because their signature was
+// invalid.
+func (b *rabbitmq) CollectQuarantine(ctx context.Context, store QuarantineStore) error {
+	return collectQueues(ctx, b.config.Quarantine, func(ctx context.Context, queue string) error {
+		return b.consumeQueue(ctx, queue, func(ctx context.Context, d *Delivery) error {
+			return collectQuarantined(ctx, b.logger, store, d, b.config.ConnectionRetryInterval)
+		})
+	})
+}
+
+// collectQueues runs collect on the quarantine queue and every dead-letter
+// queue, stopping them all once one stops.
+func collectQueues(ctx context.Context, cfg *config.AMQPQuarantine, collect func(ctx context.Context, queue string) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	queues := append([]string{cfg.Queue}, cfg.DeadLetterQueues...)
+	errs := make([]error, len(queues))
+
+	var wg sync.WaitGroup
+	for i, queue := range queues {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer cancel()
+
+			errs[i] = collect(ctx, queue)
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// collectQuarantined saves d to store. A message that cannot be saved is put
+// back in the queue after a pause rather than rejected, since the quarantine
+// queue has nowhere to dead-letter it.
+func collectQuarantined(ctx context.Context, log logger.Logger, store QuarantineStore, d *Delivery, pause time.Duration) error {
+	if err := store.Save(ctx, quarantinedMessage(d)); err != nil {
+		log.Error("AMQP failed to store quarantined message", logger.Field{Key: "message_id", Value: d.MessageId}, logger.Field{Key: "error", Value: err.Error()})
+
+		sleepContext(ctx, pause)
+		return d.Nack(false, true)
+	}
+
+	return nil
+}
+
+// Requeue publishes a quarantined message back to the queue it failed on,
+// without its retry headers, so it gets a fresh set of attempts. An edited
+// message is signed again, since its original signature no longer matches.
+func (b *rabbitmq) Requeue(ctx context.Context, msg *QuarantinedMessage) error {
+	if err := b.acquire(); err != nil {
+		return err
+	}
+	defer b.release()
+
+	publishing := msg.requeuePublishing()
+	if msg.edited {
+		if err := b.keyring.sign(&publishing); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, b.config.PublishTimeout)
+	defer cancel()
+
+	if err := b.send(ctx, "", msg.Queue, publishing); err != nil {
+		return err
+	}
+
+	requeuedTotal.WithLabelValues(msg.Queue).Inc()
+	b.logger.Info("AMQP quarantined message requeued",
+		logger.Field{Key: "queue", Value: msg.Queue},
+		logger.Field{Key: "message_id", Value: publishing.MessageId},
+		logger.Field{Key: "edited", Value: msg.edited},
+	)
+
+	return nil
+}
+
+// deliveryPublishing copies a delivery into a message that can be published
+// again, with its own copy of the headers.
+func deliveryPublishing(d *Delivery) amqplib.Publishing {
+	headers := amqplib.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+
+	return amqplib.Publishing{
+		Headers:         headers,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    d.DeliveryMode,
+		Priority:        d.Priority,
+		CorrelationId:   d.CorrelationId,
+		MessageId:       d.MessageId,
+		Timestamp:       d.Timestamp,
+		Type:            d.Type,
+		AppId:           d.AppId,
+		Body:            d.Body,
+	}
+}
+
+// headerInt reads an integer header, whichever integer type the broker
+// decoded it as.
+func headerInt(v any) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case int8:
+		return int(n)
+	case int16:
+		return int(n)
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	case uint8:
+		return int(n)
+	case uint16:
+		return int(n)
+	case uint32:
+		return int(n)
+	case float64:
+		return int(n)
+	}
+
+	return 0
+}
@@ -0,0 +1,308 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	amqplib "github.com/rabbitmq/amqp091-go"
+)
+
+var ErrQuarantinedMessageNotFound = errors.New("quarantined message not found")
+
+// QuarantinedMessage is a message taken from the quarantine queue or a
+// dead-letter queue, with why it was quarantined split out of its headers.
+type QuarantinedMessage struct {
+	ID string `json:"id"`
+	// Queue is the queue the message failed on, and is requeued to.
+	Queue         string    `json:"queue"`
+	Pattern       string    `json:"pattern"`
+	Reason        string    `json:"reason"`
+	Stack         string    `json:"stack,omitempty"`
+	Attempts      int       `json:"attempts"`
+	QuarantinedAt time.Time `json:"quarantined_at"`
+	// Publishing is the message with its original headers.
+	Publishing amqplib.Publishing `json:"publishing"`
+
+	edited bool
+}
+
+func quarantinedMessage(d *Delivery) *QuarantinedMessage {
+	msg := &QuarantinedMessage{
+		ID:            uuid.NewString(),
+		Pattern:       d.Pattern(),
+		Attempts:      headerInt(d.Headers[HeaderQuarantineAttempts]),
+		QuarantinedAt: time.Now().UTC(),
+		Publishing:    deliveryPublishing(d),
+	}
+
+	msg.Queue, _ = d.Headers[HeaderQuarantineQueue].(string)
+	msg.Reason, _ = d.Headers[HeaderQuarantineReason].(string)
+	msg.Stack, _ = d.Headers[HeaderQuarantineStack].(string)
+	if at, ok := d.Headers[HeaderQuarantinedAt].(time.Time); ok {
+		msg.QuarantinedAt = at.UTC()
+	}
+
+	for _, h := range []string{HeaderQuarantineQueue, HeaderQuarantineReason, HeaderQuarantineStack, HeaderQuarantinedAt, HeaderQuarantineAttempts} {
+		delete(msg.Publishing.Headers, h)
+	}
+
+	if msg.Queue == "" {
+		deadLettered(d, msg)
+	}
+
+	return msg
+}
+
+// deadLettered fills in where and why from the x-death header the broker adds
+// to a message another service rejected. Its latest death comes first.
+func deadLettered(d *Delivery, msg *QuarantinedMessage) {
+	deaths, _ := d.Headers[HeaderDeath].([]any)
+	if len(deaths) == 0 {
+		return
+	}
+	death, ok := deaths[0].(amqplib.Table)
+	if !ok {
+		return
+	}
+
+	msg.Queue, _ = death["queue"].(string)
+	if reason, ok := death["reason"].(string); ok {
+		msg.Reason = "dead-lettered: " + reason
+	}
+	msg.Attempts = headerInt(death["count"])
+	if at, ok := death["time"].(time.Time); ok {
+		msg.QuarantinedAt = at.UTC()
+	}
+}
+
+// Payload returns the body decompressed.
+func (m *QuarantinedMessage) Payload() ([]byte, error) {
+	return decompress(m.Publishing.ContentEncoding, m.Publishing.Body)
+}
+
+// Edit replaces the body, when body is not nil, and sets headers, removing
+// those set to nil, ahead of a requeue. The new body is taken as
+// uncompressed, and as JSON unless the content type already is.
+func (m *QuarantinedMessage) Edit(body []byte, headers map[string]any) {
+	if body != nil {
+		m.Publishing.Body = body
+		m.Publishing.ContentEncoding = ""
+		if mediaType, _, _ := mime.ParseMediaType(m.Publishing.ContentType); !strings.HasSuffix(mediaType, "json") {
+			m.Publishing.ContentType = ContentTypeJSON
+		}
+		m.edited = true
+	}
+
+	if len(headers) > 0 && m.Publishing.Headers == nil {
+		m.Publishing.Headers = amqplib.Table{}
+	}
+	for k, v := range headers {
+		if v == nil {
+			delete(m.Publishing.Headers, k)
+			continue
+		}
+		m.Publishing.Headers[k] = archivedValue(v)
+	}
+}
+
+// requeuePublishing returns the message to publish on requeue. The retry,
+// delay and dead-letter headers are dropped, and so is a signature an edit
+// has invalidated.
+func (m *QuarantinedMessage) requeuePublishing() amqplib.Publishing {
+	p := m.Publishing
+
+	p.Headers = amqplib.Table{}
+	for k, v := range m.Publishing.Headers {
+		p.Headers[k] = v
+	}
+	for _, h := range []string{HeaderAttempts, HeaderLastError, HeaderDelay, HeaderDeath, HeaderFirstDeathQueue, HeaderFirstDeathReason, HeaderFirstDeathExchange} {
+		delete(p.Headers, h)
+	}
+	if m.edited {
+		delete(p.Headers, HeaderSignature)
+		delete(p.Headers, HeaderSignatureKeyID)
+		delete(p.Headers, HeaderSignatureAlgorithm)
+	}
+
+	return p
+}
+
+// QuarantineFilter selects quarantined messages. Empty fields match all.
+type QuarantineFilter struct {
+	// Pattern is a glob matched against the event pattern, e.g. user.*.
+	Pattern string
+	Queue   string
+	Offset  int
+	// Limit is the most messages returned; 0 returns all.
+	Limit int
+}
+
+func (f *QuarantineFilter) matches(m *QuarantinedMessage) bool {
+	if f.Queue != "" && m.Queue != f.Queue {
+		return false
+	}
+	if f.Pattern != "" {
+		ok, _ := path.Match(f.Pattern, m.Pattern)
+		return ok
+	}
+
+	return true
+}
+
+// QuarantineStore keeps quarantined messages for inspection.
+type QuarantineStore interface {
+	Save(ctx context.Context, msg *QuarantinedMessage) error
+	Get(ctx context.Context, id string) (*QuarantinedMessage, error)
+	// List returns the page of messages matching filter, most recently
+	// quarantined first, and how many match in all.
+	List(ctx context.Context, filter *QuarantineFilter) ([]*QuarantinedMessage, int, error)
+	Delete(ctx context.Context, id string) error
+}
+
+type FileQuarantineStoreOpts struct {
+	Dir string
+}
+
+// FileQuarantineStore keeps each quarantined message in a JSON file of its
+// own. Listing reads every file, which suits the handful of messages a
+// quarantine should hold.
+type FileQuarantineStore struct {
+	dir string
+	mu  sync.RWMutex
+}
+
+func NewFileQuarantineStore(opts *FileQuarantineStoreOpts) (*FileQuarantineStore, error) {
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &FileQuarantineStore{dir: opts.Dir}, nil
+}
+
+func (s *FileQuarantineStore) Save(ctx context.Context, msg *QuarantinedMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tmp, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path(msg.ID))
+}
+
+func (s *FileQuarantineStore) Get(ctx context.Context, id string) (*QuarantinedMessage, error) {
+	if uuid.Validate(id) != nil {
+		return nil, ErrQuarantinedMessageNotFound
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.read(s.path(id))
+}
+
+func (s *FileQuarantineStore) List(ctx context.Context, filter *QuarantineFilter) ([]*QuarantinedMessage, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var matched []*QuarantinedMessage
+	for _, name := range names {
+		msg, err := s.read(name)
+		if errors.Is(err, ErrQuarantinedMessageNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if filter.matches(msg) {
+			matched = append(matched, msg)
+		}
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].QuarantinedAt.After(matched[j].QuarantinedAt)
+	})
+
+	total := len(matched)
+	if filter.Offset >= total {
+		return nil, total, nil
+	}
+	matched = matched[filter.Offset:]
+	if filter.Limit > 0 && filter.Limit < len(matched) {
+		matched = matched[:filter.Limit]
+	}
+
+	return matched, total, nil
+}
+
+func (s *FileQuarantineStore) Delete(ctx context.Context, id string) error {
+	if uuid.Validate(id) != nil {
+		return ErrQuarantinedMessageNotFound
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := os.Remove(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrQuarantinedMessageNotFound
+	}
+
+	return err
+}
+
+func (s *FileQuarantineStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+func (s *FileQuarantineStore) read(name string) (*QuarantinedMessage, error) {
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrQuarantinedMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var msg QuarantinedMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+	msg.Publishing.Headers = archivedTable(msg.Publishing.Headers)
+
+	return &msg, nil
+}
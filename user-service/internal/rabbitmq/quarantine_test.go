@@ -0,0 +1,87 @@
+package rabbitmq
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	amqplib "github.com/rabbitmq/amqp091-go"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/config"
+)
+
+func TestRetryDelayBacksOff(t *testing.T) {
+	cfg := &config.AMQPQuarantine{RetryDelay: time.Second, MaxRetryDelay: 5 * time.Second}
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if got := retryDelay(cfg, i+1); got != w {
+			t.Errorf("attempt %d: delay %v, want %v", i+1, got, w)
+		}
+	}
+
+	if got := retryDelay(&config.AMQPQuarantine{}, 3); got != 0 {
+		t.Errorf("unconfigured delay %v, want 0", got)
+	}
+}
+
+func TestCollectQuarantineIncludesDeadLetters(t *testing.T) {
+	m := newTestMemoryBroker(t)
+	m.config.Quarantine = &config.AMQPQuarantine{
+		Queue:            "user-service.quarantine",
+		DeadLetterQueues: []string{"notification-service.dead-letter"},
+	}
+
+	diedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	m.DeclareQueue("notification-service.dead-letter")
+	err := m.route("", "notification-service.dead-letter", amqplib.Publishing{
+		Headers: amqplib.Table{
+			HeaderDeath: []any{amqplib.Table{
+				"queue":  "notification-service",
+				"reason": "rejected",
+				"count":  int64(1),
+				"time":   diedAt,
+			}},
+			HeaderFirstDeathQueue: "notification-service",
+		},
+		Type: "user.created",
+		Body: []byte(`{"pattern":"user.created","data":{}}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewFileQuarantineStore(&FileQuarantineStoreOpts{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- m.CollectQuarantine(ctx, store) }()
+
+	var msgs []*QuarantinedMessage
+	for deadline := time.Now().Add(time.Second); len(msgs) == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		msgs, _, err = store.List(context.Background(), &QuarantineFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	cancel()
+	<-done
+
+	if len(msgs) != 1 {
+		t.Fatalf("collected %d messages, want 1", len(msgs))
+	}
+	msg := msgs[0]
+	if msg.Queue != "notification-service" || msg.Reason != "dead-lettered: rejected" || msg.Attempts != 1 || !msg.QuarantinedAt.Equal(diedAt) {
+		t.Errorf("got %+v, want the death of the message on notification-service", msg)
+	}
+
+	requeued := msg.requeuePublishing()
+	for _, h := range []string{HeaderDeath, HeaderFirstDeathQueue} {
+		if _, ok := requeued.Headers[h]; ok {
+			t.Errorf("requeued message kept header %s", h)
+		}
+	}
+}
@@ -4,203 +4,774 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
-	"math"
+	"math/rand/v2"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	amqplib "github.com/rabbitmq/amqp091-go"
 	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/config"
 	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type RabbitMQ interface {
 	Health() error
 	MaintainConnection(ctx context.Context)
-	Publish(ctx context.Context, queue string, message *MessageType) error
+	SubscribeState() (<-chan ConnectionState, func())
+	Publish(ctx context.Context, queue string, message *MessageType, opts ...PublishOption) error
+	PublishBatch(ctx context.Context, queue string, messages []*MessageType, opts ...PublishOption) []error
+	Consume(ctx context.Context, queue string, handler Handler) error
+	QueueStats(ctx context.Context, queue string) (*QueueStats, error)
+	Call(ctx context.Context, queue, pattern string, payload any) (json.RawMessage, error)
+	Serve(pattern string, handler RPCHandler)
+	CollectQuarantine(ctx context.Context, store QuarantineStore) error
+	Requeue(ctx context.Context, msg *QuarantinedMessage) error
+	Close(ctx context.Context) error
 }
 
 type rabbitmq struct {
-	config        *config.AMQP
-	conn          *amqplib.Connection
-	pubChannel    *amqplib.Channel
-	reconnectLock sync.Mutex
-	logger        logger.Logger
+	config *config.AMQP
+	app    *config.App
+	nodes  *nodeSet
+	// topology is nil when no topology file is configured.
+	topology *Topology
+	mu       sync.RWMutex
+	conn     *amqplib.Connection
+	pool     *channelPool
+	state    *stateNotifier
+	logger   logger.Logger
+
+	spool     *spool
+	spoolWake chan struct{}
+	// spooling is what the last health check reported, so that entering
+	// and leaving spooling are logged once.
+	spooling atomic.Bool
+	// archive is nil unless the archive tap is enabled.
+	archive *archive
+	// batcher is nil unless publish batching is enabled.
+	batcher *batcher
+	delayer delayer
+
+	credentials CredentialsProvider
+	// dialed is the configuration of the connection, with the credentials
+	// it was dialed with.
+	dialed *config.AMQP
+
+	encoder *encoder
+	keyring *keyring
+
+	// ctx bounds the connection and the background work started on demand,
+	// such as the RPC server. It is cancelled by Close, and done is closed
+	// once the connection has been torn down.
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	closeMu  sync.RWMutex
+	closing  bool
+	inflight sync.WaitGroup
+
+	rpc       *rpcClient
+	rpcServer *rpcServer
 }
 
 type Opts struct {
 	Logger logger.Logger
 	Config *config.AMQP
+	App    *config.App
+	// Credentials defaults to the credentials directory or the configured
+	// credentials.
+	Credentials CredentialsProvider
 }
 
+var (
+	ErrNotConnected  = errors.New("amqp connection is not established")
+	ErrPublishNacked = errors.New("amqp message was not confirmed by the broker")
+)
+
+// MessageType is the envelope of every published event. Pattern and data are
+// what NestJS's RMQ transport reads; the remaining fields are extra keys it
+// ignores. There is deliberately no top-level "id" key, since Nest treats a
+// packet carrying one as a request that expects a reply.
 type MessageType struct {
-	Pattern string `json:"pattern"`
-	Data    any    `json:"data"`
+	Pattern         string    `json:"pattern"`
+	Data            any       `json:"data"`
+	MessageID       string    `json:"messageId,omitempty"`
+	CorrelationID   string    `json:"correlationId,omitempty"`
+	CausationID     string    `json:"causationId,omitempty"`
+	Subject         string    `json:"subject,omitempty"`
+	Producer        string    `json:"producer,omitempty"`
+	ProducerVersion string    `json:"producerVersion,omitempty"`
+	SchemaVersion   int       `json:"schemaVersion,omitempty"`
+	OccurredAt      time.Time `json:"occurredAt"`
 }
 
+// New returns the implementation selected by the configured driver: the
+// in-memory broker for "memory", the AMQP client otherwise.
+func New(ctx context.Context, opts *Opts) RabbitMQ {
+	if opts.Config.Driver == DriverMemory {
+		opts.Logger.Warn("AMQP using in-memory broker, messages are not delivered to RabbitMQ")
+
+		m := NewMemoryBroker(opts)
+		go m.MaintainConnection(ctx)
+
+		return m
+	}
+
+	return NewRabbitMQ(ctx, opts)
+}
+
+// NewRabbitMQ starts connecting in the background. The client outlives the
+// cancellation of ctx, which only provides values, so that shutdown can drain
+// pending publishes: it runs until Close.
 func NewRabbitMQ(ctx context.Context, opts *Opts) RabbitMQ {
+	if opts.App == nil {
+		opts.App = &config.App{}
+	}
+
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+
+	// An invalid address is reported by every connection attempt.
+	base, _ := dialURI(opts.Config)
+
 	b := &rabbitmq{
-		reconnectLock: sync.Mutex{},
-		config:        opts.Config,
-		logger:        opts.Logger,
+		config:  opts.Config,
+		app:     opts.App,
+		nodes:   newNodeSet(nodeAddresses(opts.Config.Hosts, base), opts.Config.NodeSelection),
+		state:   newStateNotifier(),
+		logger:  opts.Logger,
+		encoder: newEncoder(opts.Config, opts.App, opts.Logger),
+		keyring: newKeyring(opts.Config.Signing, opts.Logger),
+
+		credentials: opts.Credentials,
+
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+		rpc:       newRPCClient(),
+		rpcServer: newRPCServer(),
+	}
+
+	if b.credentials == nil {
+		b.credentials = newCredentialsProvider(opts.Config, opts.Logger)
+	}
+
+	if opts.Config.TopologyFile != "" {
+		t, err := LoadTopology(opts.Config.TopologyFile)
+		if err != nil {
+			opts.Logger.Error("AMQP failed to load topology, declaring queues on demand only", logger.Field{Key: "error", Value: err.Error()})
+		} else {
+			b.topology = t
+		}
+	}
+
+	if opts.Config.Spool != nil && opts.Config.Spool.Enabled {
+		s, err := newSpool(opts.Config.Spool, opts.Logger)
+		if err != nil {
+			opts.Logger.Error("AMQP failed to open publish spool, spooling disabled", logger.Field{Key: "error", Value: err.Error()})
+		} else {
+			b.spool = s
+			b.spoolWake = make(chan struct{}, 1)
+			registerSpoolCollector(s)
+
+			go b.drainSpool(ctx)
+		}
+	}
+
+	if opts.Config.Archive != nil && opts.Config.Archive.Enabled {
+		a, err := newArchive(opts.Config.Archive)
+		if err != nil {
+			opts.Logger.Error("AMQP failed to open message archive, archiving disabled", logger.Field{Key: "error", Value: err.Error()})
+		} else {
+			b.archive = a
+		}
+	}
+
+	if opts.Config.PublishBatchWindow > 0 {
+		b.batcher = newBatcher(opts.Config.PublishBatchWindow, opts.Config.PublishBatchMaxSize, func(key string, msgs []amqplib.Publishing) []error {
+			errs := make([]error, len(msgs))
+			b.sendBatch(ctx, "", key, msgs, errs)
+			return errs
+		})
 	}
 
-	go b.MaintainConnection(ctx)
+	go func() {
+		defer close(b.done)
+		b.MaintainConnection(ctx)
+	}()
 
 	return b
 }
 
 func (b *rabbitmq) Health() error {
-	if b.conn == nil || b.conn.IsClosed() {
+	b.mu.RLock()
+	conn := b.conn
+	b.mu.RUnlock()
+
+	if conn == nil || conn.IsClosed() {
+		if b.spool != nil && (b.config.Spool.MaxBytes <= 0 || b.spool.Size() < b.config.Spool.MaxBytes) {
+			b.setSpooling(true)
+			return nil
+		}
+
+		b.setSpooling(false)
 		b.logger.Warn("AMQP health check failed!")
 		return errors.New("amqp healthcheck failed")
 	}
 
+	b.setSpooling(false)
+
 	return nil
 }
 
-func (b *rabbitmq) MaintainConnection(ctx context.Context) {
-	if err := b.connect(); err != nil {
-		b.logger.Error("Initial AMQP connection attempt failed", logger.Field{Key: "error", Value: err.Error()})
+// setSpooling records whether publishes go to the spool, logging only the
+// changes since probes run every few seconds.
+func (b *rabbitmq) setSpooling(on bool) {
+	if on {
+		spooling.Set(1)
+	} else {
+		spooling.Set(0)
 	}
 
-	attempts := b.config.ConnectionRetryAttempts
-	interval := b.config.ConnectionRetryInterval
+	if b.spooling.Swap(on) == on {
+		return
+	}
+	if on {
+		b.logger.Warn("AMQP broker unavailable, publishing to spool")
+	} else {
+		b.logger.Info("AMQP no longer publishing to spool")
+	}
+}
 
-	t := time.NewTicker(interval)
-	defer t.Stop()
+// MaintainConnection connects to the broker and reconnects whenever the
+// connection is closed, until ctx is cancelled or the configured number of
+// consecutive attempts is exhausted. A negative attempts setting retries forever.
+func (b *rabbitmq) MaintainConnection(ctx context.Context) {
+	attempt := 0
+	rotated := b.credentials.Watch(ctx)
 
 	for {
-		select {
-		case <-ctx.Done():
-			if b.pubChannel != nil {
-				if err := b.pubChannel.Close(); err != nil {
-					b.logger.Error("AMQP failed to close channel", logger.Field{Key: "error", Value: err.Error()})
-				}
+		b.state.Set(StateConnecting)
+
+		conn, err := b.connect(false)
+		if err != nil {
+			attempt++
+
+			maxAttempts := b.config.ConnectionRetryAttempts
+			if maxAttempts >= 0 && attempt > maxAttempts {
+				b.logger.Error("AMQP reconnection attempts exhausted", logger.Field{Key: "attempts", Value: attempt})
+				b.closeConnection()
+				b.state.Set(StateFailed)
+				return
 			}
 
-			if b.conn != nil {
-				if err := b.conn.Close(); err != nil {
-					b.logger.Error("AMQP failed to close connection", logger.Field{Key: "error", Value: err.Error()})
-				}
+			delay := backoff(attempt, b.config.ConnectionRetryInterval, b.config.ConnectionRetryMaxInterval)
+			b.logger.Info("AMQP attempting reconnection", logger.Field{Key: "attempt", Value: attempt}, logger.Field{Key: "delay", Value: delay.String()})
+
+			b.state.Set(StateDisconnected)
+			if !sleepContext(ctx, delay) {
+				b.closeConnection()
+				b.state.Set(StateClosed)
+				return
 			}
 
+			continue
+		}
+
+		attempt = 0
+		b.state.Set(StateConnected)
+
+		// The connection was just dialed with the current credentials.
+		select {
+		case <-rotated:
+		default:
+		}
+
+		if !b.watchConnection(ctx, conn, rotated) {
+			b.closeConnection()
+			b.state.Set(StateClosed)
 			return
-		case <-t.C:
-			if err := b.tryReconnect(attempts, interval); err != nil {
-				return
+		}
+
+		b.nodes.disconnected()
+		b.state.Set(StateDisconnected)
+	}
+}
+
+func (b *rabbitmq) SubscribeState() (<-chan ConnectionState, func()) {
+	return b.state.Subscribe()
+}
+
+// watchConnection blocks until the connection is closed by the broker or the
+// network, in which case it returns true, or until ctx is cancelled. When the
+// credentials are rotated it replaces the connection and watches the new one.
+func (b *rabbitmq) watchConnection(ctx context.Context, conn *amqplib.Connection, rotated <-chan struct{}) bool {
+	closed := conn.NotifyClose(make(chan *amqplib.Error, 1))
+	blocked := conn.NotifyBlocked(make(chan amqplib.Blocking, 1))
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case err := <-closed:
+			if err != nil {
+				b.logger.Warn("AMQP connection closed", logger.Field{Key: "error", Value: err.Error()})
 			}
+			return true
+		case blocking, ok := <-blocked:
+			// The channel is closed along with the connection, which is
+			// reported on closed.
+			if !ok {
+				blocked = nil
+				continue
+			}
+			if blocking.Active {
+				b.logger.Warn("AMQP connection blocked by broker", logger.Field{Key: "reason", Value: blocking.Reason})
+				b.state.Set(StateBlocked)
+			} else {
+				b.logger.Info("AMQP connection unblocked")
+				b.state.Set(StateConnected)
+			}
+		case <-rotated:
+			next, err := b.connect(true)
+			if err != nil {
+				credentialRotationsTotal.WithLabelValues("failure").Inc()
+				b.logger.Error("AMQP failed to connect with rotated credentials, keeping the current connection", logger.Field{Key: "error", Value: err.Error()})
+				continue
+			}
+			credentialRotationsTotal.WithLabelValues("success").Inc()
+			b.logger.Info("AMQP connection replaced with rotated credentials")
+
+			conn = next
+			closed = conn.NotifyClose(make(chan *amqplib.Error, 1))
+			blocked = conn.NotifyBlocked(make(chan amqplib.Blocking, 1))
+			b.state.Set(StateConnected)
 		}
 	}
 }
 
-func (b *rabbitmq) Publish(ctx context.Context, queue string, message *MessageType) error {
-	q, err := declareQueue(queue, b.pubChannel)
-	if err != nil {
+func (b *rabbitmq) closeConnection() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.pool != nil {
+		b.pool.Close()
+	}
+
+	if b.conn != nil && !b.conn.IsClosed() {
+		if err := b.conn.Close(); err != nil {
+			b.logger.Error("AMQP failed to close connection", logger.Field{Key: "error", Value: err.Error()})
+		}
+	}
+
+	if b.spool != nil {
+		if err := b.spool.Close(); err != nil {
+			b.logger.Error("AMQP failed to close publish spool", logger.Field{Key: "error", Value: err.Error()})
+		}
+	}
+
+	if b.archive != nil {
+		if err := b.archive.Close(); err != nil {
+			b.logger.Error("AMQP failed to close message archive", logger.Field{Key: "error", Value: err.Error()})
+		}
+	}
+}
+
+func (b *rabbitmq) Publish(ctx context.Context, queue string, message *MessageType, opts ...PublishOption) error {
+	o := newPublishOptions(opts)
+
+	if err := b.acquire(); err != nil {
 		return err
 	}
+	defer b.release()
 
 	ctx, cancel := context.WithTimeout(ctx, b.config.PublishTimeout)
 	defer cancel()
 
-	messageData, err := json.Marshal(&message)
+	ctx, span, publishing, err := b.prepare(ctx, queue, message, o)
+	defer span.End()
+
+	if err == nil {
+		switch {
+		case o.delay > 0:
+			err = b.sendDelayed(ctx, queue, o.delay, &publishing)
+		case b.batcher != nil:
+			// The message stays in flight until its batch is sent or it is
+			// withdrawn from the batch.
+			b.inflight.Add(1)
+			err = b.batcher.publish(ctx, queue, publishing, b.release)
+		default:
+			err = b.send(ctx, "", queue, publishing)
+		}
+	}
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		return err
 	}
 
-	err = b.pubChannel.PublishWithContext(
-		ctx,
-		"",
-		q.Name,
-		false,
-		false,
-		amqplib.Publishing{
-			ContentType: "application/json",
-			Body:        messageData,
-		},
+	b.archivePublished("", queue, publishing)
+
+	b.logger.Info("AMQP message sent",
+		logger.Field{Key: "event", Value: message.Pattern},
+		logger.Field{Key: "message_id", Value: message.MessageID},
+		logger.Field{Key: "correlation_id", Value: message.CorrelationID},
+		logger.Field{Key: "trace_id", Value: traceID(ctx)},
 	)
+
+	return nil
+}
+
+// prepare stamps, encodes and signs message within a new publish span, which
+// the caller ends.
+func (b *rabbitmq) prepare(ctx context.Context, queue string, message *MessageType, o *publishOptions) (context.Context, trace.Span, amqplib.Publishing, error) {
+	b.encoder.stamp(ctx, message)
+
+	ctx, span := startPublishSpan(ctx, queue, message)
+
+	publishing, err := b.encoder.encode(message)
+	if err != nil {
+		return ctx, span, publishing, err
+	}
+	if err := o.apply(&publishing); err != nil {
+		return ctx, span, publishing, err
+	}
+	injectTraceContext(ctx, publishing.Headers)
+
+	if err := b.keyring.sign(&publishing); err != nil {
+		return ctx, span, publishing, err
+	}
+
+	return ctx, span, publishing, nil
+}
+
+func (b *rabbitmq) archivePublished(exchange, key string, msg amqplib.Publishing) {
+	if b.archive == nil {
+		return
+	}
+
+	if err := b.archive.Append(exchange, key, msg); err != nil {
+		archiveErrorsTotal.Inc()
+		b.logger.Error("AMQP failed to archive message", logger.Field{Key: "message_id", Value: msg.MessageId}, logger.Field{Key: "error", Value: err.Error()})
+	}
+}
+
+// sendDelayed publishes msg so that it reaches queue once delay has passed.
+// Delayed messages are not spooled, since their route is declared first.
+func (b *rabbitmq) sendDelayed(ctx context.Context, queue string, delay time.Duration, msg *amqplib.Publishing) error {
+	exchange, key, err := b.delayRoute(ctx, queue, delay, msg)
 	if err != nil {
 		return err
 	}
-	b.logger.Info("AMQP message sent", logger.Field{Key: "event", Value: message.Pattern})
 
-	return nil
+	return b.publish(ctx, exchange, key, *msg)
 }
 
-func (b *rabbitmq) connect() error {
-	address := fmt.Sprintf("amqp://%s:%s@%s:%d", b.config.Username, b.config.Password, b.config.Host, b.config.Port)
+// send publishes a message, or spools it when the spool is enabled and the
+// broker is unreachable. While spooled messages are pending, new messages are
+// spooled behind them so that delivery order is preserved.
+func (b *rabbitmq) send(ctx context.Context, exchange, key string, msg amqplib.Publishing) error {
+	if b.spool != nil && (b.state.Current() != StateConnected || b.spool.Depth() > 0) {
+		return b.enqueue(exchange, key, msg)
+	}
 
-	var err error
+	err := b.publish(ctx, exchange, key, msg)
+	if b.spool != nil && (errors.Is(err, ErrNotConnected) || errors.Is(err, amqplib.ErrClosed)) {
+		return b.enqueue(exchange, key, msg)
+	}
 
-	b.conn, err = amqplib.Dial(address)
+	return err
+}
+
+func (b *rabbitmq) publish(ctx context.Context, exchange, key string, msg amqplib.Publishing) error {
+	pool, channel, err := b.checkout(ctx)
 	if err != nil {
-		b.logger.Error("AMQP connection error", logger.Field{Key: "error", Value: err.Error()})
 		return err
 	}
+	defer pool.Release()
+
+	// Queues named amq.* are reserved by the broker, such as direct reply-to
+	// queues, and cannot be declared.
+	if exchange == "" && !strings.HasPrefix(key, "amq.") {
+		if _, err := b.declareQueue(key, channel.Channel); err != nil {
+			pool.Put(channel)
+			return err
+		}
+	}
+
+	confirm, err := channel.PublishWithDeferredConfirmWithContext(ctx, exchange, key, false, false, msg)
+	pool.Put(channel)
+	if err != nil {
+		return err
+	}
+
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return ErrPublishNacked
+	}
+
+	return nil
+}
+
+// checkout gets a channel from the current pool. A pool closed in the
+// meantime because the connection was replaced is retried on the new one.
+func (b *rabbitmq) checkout(ctx context.Context) (*channelPool, *pooledChannel, error) {
+	for {
+		b.mu.RLock()
+		pool := b.pool
+		b.mu.RUnlock()
 
-	b.logger.Info("AMQP connected on " + address)
+		if pool == nil {
+			return nil, nil, ErrNotConnected
+		}
+
+		channel, err := pool.Get(ctx)
+		if errors.Is(err, ErrPoolClosed) {
+			b.mu.RLock()
+			replaced := b.pool != pool
+			b.mu.RUnlock()
 
-	channel, err := b.newChannel()
+			if replaced {
+				continue
+			}
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return pool, channel, nil
+	}
+}
+
+func (b *rabbitmq) enqueue(exchange, key string, msg amqplib.Publishing) error {
+	err := b.spool.Append(&spoolRecord{
+		Exchange:   exchange,
+		RoutingKey: key,
+		Publishing: msg,
+		EnqueuedAt: time.Now(),
+	})
 	if err != nil {
-		b.logger.Error("Unable to create listen channel", logger.Field{Key: "error", Value: err.Error()})
+		if errors.Is(err, ErrSpoolFull) {
+			spoolRejectedTotal.Inc()
+		}
 		return err
 	}
 
-	b.pubChannel = channel
+	spoolAppendedTotal.Inc()
+	b.logger.Warn("AMQP broker unavailable, message spooled", logger.Field{Key: "routing_key", Value: key})
+
+	select {
+	case b.spoolWake <- struct{}{}:
+	default:
+	}
 
 	return nil
 }
 
-func (b *rabbitmq) tryReconnect(attempts int, interval time.Duration) error {
-	b.reconnectLock.Lock()
-	defer b.reconnectLock.Unlock()
+// drainSpool republishes spooled messages in order whenever the connection
+// comes back, a message is spooled, or the retry interval elapses.
+func (b *rabbitmq) drainSpool(ctx context.Context) {
+	states, unsubscribe := b.SubscribeState()
+	defer unsubscribe()
 
-	if b.Health() == nil {
-		return nil
+	t := time.NewTicker(b.config.ConnectionRetryInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-states:
+		case <-b.spoolWake:
+		case <-t.C:
+		}
+
+		for b.state.Current() == StateConnected {
+			rec, err := b.spool.Peek()
+			if err != nil {
+				b.logger.Error("AMQP failed to read spooled message", logger.Field{Key: "error", Value: err.Error()})
+				break
+			}
+			if rec == nil {
+				break
+			}
+
+			pubCtx, cancel := context.WithTimeout(ctx, b.config.PublishTimeout)
+			err = b.publish(pubCtx, rec.Exchange, rec.RoutingKey, rec.Publishing)
+			cancel()
+			if err != nil {
+				b.logger.Warn("AMQP failed to publish spooled message", logger.Field{Key: "error", Value: err.Error()})
+				break
+			}
+
+			if err := b.spool.Ack(); err != nil {
+				b.logger.Error("AMQP failed to acknowledge spooled message", logger.Field{Key: "error", Value: err.Error()})
+				break
+			}
+			spoolDrainedTotal.Inc()
+		}
 	}
+}
 
-	for i := range attempts {
-		b.logger.Info("AMQP attempting reconnection", logger.Field{Key: "attempt", Value: i + 1}, logger.Field{Key: "interval", Value: interval * (1 << i)})
+// connect dials the first node that accepts a connection. Failures to dial
+// with rotated credentials are not held against the nodes, which are still
+// serving the current connection.
+func (b *rabbitmq) connect(rotated bool) (*amqplib.Connection, error) {
+	cfg, err := b.credentialConfig()
+	if err != nil {
+		b.logger.Error("AMQP failed to read credentials", logger.Field{Key: "error", Value: err.Error()})
+		return nil, err
+	}
 
-		if err := b.connect(); err == nil {
-			return nil
+	base, err := dialURI(cfg)
+	if err != nil {
+		b.logger.Error("AMQP invalid connection URI", logger.Field{Key: "error", Value: err.Error()})
+		return nil, err
+	}
+
+	var conn *amqplib.Connection
+	for _, n := range b.nodes.candidates() {
+		address := withNode(base, n.address)
+
+		conn, err = dialNode(cfg, b.app, address)
+		if errors.Is(err, errInvalidDialSettings) {
+			b.logger.Error("AMQP invalid connection settings", logger.Field{Key: "error", Value: err.Error()})
+			return nil, err
 		}
+		if err != nil {
+			if !rotated {
+				b.nodes.failed(n)
+			}
+			b.logger.Error("AMQP connection error", logger.Field{Key: "node", Value: n.address}, logger.Field{Key: "failures", Value: n.failures}, logger.Field{Key: "error", Value: err.Error()})
+			continue
+		}
+
+		if previous, failover := b.nodes.connected(n); failover {
+			failoversTotal.WithLabelValues(previous.address, n.address).Inc()
+			b.logger.Warn("AMQP failed over to another node", logger.Field{Key: "from", Value: previous.address}, logger.Field{Key: "to", Value: n.address})
+		}
+
+		b.logger.Info("AMQP connected on " + redactURI(address))
+		break
+	}
 
-		if i+1 < attempts {
-			//retry with exponential backoff
-			exponent := math.Pow(2, float64(i))
-			delay := time.Duration(float64(interval) * exponent)
-			time.Sleep(delay)
+	if conn == nil {
+		return nil, ErrNotConnected
+	}
+
+	if b.topology != nil {
+		if err := b.topology.Apply(conn, cfg); err != nil {
+			b.logger.Error("AMQP failed to apply topology", logger.Field{Key: "error", Value: err.Error()})
+			_ = conn.Close()
+			return nil, err
+		}
+
+		// Policies only tune queues that already work without them, so a
+		// management API outage does not keep the client from connecting.
+		if len(b.topology.Policies) > 0 {
+			ctx, cancel := context.WithTimeout(context.Background(), b.config.PublishTimeout)
+			if err := b.topology.ApplyPolicies(ctx, cfg); err != nil {
+				b.logger.Warn("AMQP failed to apply topology policies", logger.Field{Key: "error", Value: redact(err.Error(), cfg)})
+			}
+			cancel()
 		}
 	}
 
-	return fmt.Errorf("could not reconnect after %d retries", attempts)
+	pool := newChannelPool(conn, b.config.ChannelPoolSize, b.logger)
+
+	b.mu.Lock()
+	oldConn, oldPool := b.conn, b.pool
+	b.conn = conn
+	b.pool = pool
+	b.dialed = cfg
+	b.mu.Unlock()
+
+	if oldPool != nil {
+		go b.retire(oldConn, oldPool)
+	}
+
+	return conn, nil
 }
 
-func (b *rabbitmq) newChannel() (*amqplib.Channel, error) {
-	c, err := b.conn.Channel()
+// credentialConfig returns the configuration with the current credentials.
+func (b *rabbitmq) credentialConfig() (*config.AMQP, error) {
+	ctx, cancel := context.WithTimeout(b.ctx, b.config.PublishTimeout)
+	defer cancel()
 
+	c, err := b.credentials.Credentials(ctx)
 	if err != nil {
-		b.logger.Error("AMQP channel error", logger.Field{Key: "error", Value: err.Error()})
 		return nil, err
 	}
 
-	return c, nil
+	return withCredentials(b.config, c)
 }
 
-func declareQueue(queue string, channel *amqplib.Channel) (*amqplib.Queue, error) {
-	q, err := channel.QueueDeclare(
-		queue,
-		false,
-		false,
-		false,
-		false,
-		nil,
-	)
+// brokerConfig returns the configuration the connection was dialed with,
+// for the management API and redaction to use the same credentials.
+func (b *rabbitmq) brokerConfig() *config.AMQP {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.dialed == nil {
+		return b.config
+	}
+
+	return b.dialed
+}
+
+// retire closes a replaced connection once the publishes that checked out
+// one of its channels have been confirmed.
+func (b *rabbitmq) retire(conn *amqplib.Connection, pool *channelPool) {
+	pool.Drain()
+
+	if conn != nil && !conn.IsClosed() {
+		if err := conn.Close(); err != nil {
+			b.logger.Error("AMQP failed to close replaced connection", logger.Field{Key: "error", Value: err.Error()})
+		}
+	}
+}
+
+// backoff returns a full-jitter exponential delay: a random duration between
+// zero and base*2^(attempt-1), capped at maxDelay.
+func backoff(attempt int, base, maxDelay time.Duration) time.Duration {
+	ceiling := maxDelay
+	if attempt-1 < 62 && base < maxDelay>>(attempt-1) {
+		ceiling = base << (attempt - 1)
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+
+	return rand.N(ceiling)
+}
+
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
 
+// declareQueue declares queue as the topology describes it, or with the
+// configured queue type when the topology does not mention it. Quorum queues
+// are replicated across the cluster and must be durable, so messages survive
+// the loss of the node that was leading them.
+func (b *rabbitmq) declareQueue(queue string, channel *amqplib.Channel) (*amqplib.Queue, error) {
+	q, err := b.topology.queueSpec(queue).declare(channel, b.config)
 	if err != nil {
 		return nil, err
 	}
 
-	return &q, err
+	return &q, nil
 }
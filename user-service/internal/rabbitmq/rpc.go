@@ -0,0 +1,381 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	amqplib "github.com/rabbitmq/amqp091-go"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// directReplyTo is RabbitMQ's pseudo-queue for direct reply-to. It is also
+// the reply queue NestJS's RMQ client uses by default.
+const directReplyTo = "amq.rabbitmq.reply-to"
+
+// noMessageHandler is the error NestJS replies with when no handler matches
+// the request pattern.
+const noMessageHandler = "There is no matching message handler defined in the remote service."
+
+var ErrRPCChannelClosed = errors.New("amqp rpc reply channel closed before a reply arrived")
+
+// RPCHandler answers a request. The returned value is sent back as the
+// response; an error is sent back as the error message.
+type RPCHandler func(ctx context.Context, request *Delivery) (any, error)
+
+// RPCError is an error returned by the remote handler.
+type RPCError struct {
+	Message string
+}
+
+func (e *RPCError) Error() string {
+	return "rpc call failed: " + e.Message
+}
+
+// rpcRequest and rpcResponse are NestJS's RMQ request and response packets.
+// Unlike events, requests carry an id, which Nest also sets as the
+// correlation id of the message.
+type rpcRequest struct {
+	Pattern string `json:"pattern"`
+	Data    any    `json:"data"`
+	ID      string `json:"id"`
+}
+
+type rpcResponse struct {
+	Err        any    `json:"err"`
+	Response   any    `json:"response"`
+	IsDisposed bool   `json:"isDisposed"`
+	ID         string `json:"id,omitempty"`
+}
+
+// newRPCRequest encodes a request the way Nest's ClientRMQ does. Requests are
+// always uncompressed JSON, whatever the event codec, since that is all a
+// Nest server can read.
+func newRPCRequest(ctx context.Context, pattern string, payload any) (amqplib.Publishing, string, error) {
+	id := uuid.NewString()
+
+	body, err := json.Marshal(&rpcRequest{Pattern: pattern, Data: payload, ID: id})
+	if err != nil {
+		return amqplib.Publishing{}, "", err
+	}
+
+	publishing := amqplib.Publishing{
+		Headers:       amqplib.Table{},
+		ContentType:   ContentTypeJSON,
+		CorrelationId: id,
+		MessageId:     id,
+		ReplyTo:       directReplyTo,
+		Type:          pattern,
+		Timestamp:     time.Now().UTC(),
+		Body:          body,
+	}
+	injectTraceContext(ctx, publishing.Headers)
+
+	return publishing, id, nil
+}
+
+// awaitRPCResponse waits for the final packet of a reply. Nest streams one
+// packet per emitted value and marks the last one disposed; the last value
+// received is returned.
+func awaitRPCResponse(ctx context.Context, replies <-chan *rpcResponse) (json.RawMessage, error) {
+	var result json.RawMessage
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case res, ok := <-replies:
+			if !ok {
+				return nil, ErrRPCChannelClosed
+			}
+
+			if res.Err != nil {
+				return nil, &RPCError{Message: rpcErrorMessage(res.Err)}
+			}
+
+			if res.Response != nil {
+				raw, err := json.Marshal(res.Response)
+				if err != nil {
+					return nil, err
+				}
+				result = raw
+			}
+
+			if res.IsDisposed {
+				return result, nil
+			}
+		}
+	}
+}
+
+// rpcErrorMessage flattens the error of a Nest response, which is a string
+// or an RpcException object with a message.
+func rpcErrorMessage(err any) string {
+	switch e := err.(type) {
+	case string:
+		return e
+	case map[string]any:
+		if message, ok := e["message"].(string); ok {
+			return message
+		}
+	}
+
+	raw, _ := json.Marshal(err)
+
+	return string(raw)
+}
+
+// rpcServer dispatches requests by pattern to the registered handlers.
+type rpcServer struct {
+	mu       sync.RWMutex
+	handlers map[string]RPCHandler
+	once     sync.Once
+}
+
+func newRPCServer() *rpcServer {
+	return &rpcServer{handlers: map[string]RPCHandler{}}
+}
+
+func (s *rpcServer) register(pattern string, handler RPCHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.handlers[pattern] = handler
+}
+
+func (s *rpcServer) handler(pattern string) (RPCHandler, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	h, ok := s.handlers[pattern]
+
+	return h, ok
+}
+
+// handle answers one request through reply. Messages without a request id
+// are events, which the RPC queue does not handle, so they are rejected.
+func (s *rpcServer) handle(ctx context.Context, d *Delivery, reply func(ctx context.Context, replyTo string, msg amqplib.Publishing) error) error {
+	var req struct {
+		Pattern string `json:"pattern"`
+		ID      string `json:"id"`
+	}
+	if err := d.decodeBody(&req); err != nil {
+		return err
+	}
+
+	if req.ID == "" || d.ReplyTo == "" {
+		return fmt.Errorf("no event handler for pattern %q on the rpc queue", req.Pattern)
+	}
+
+	res := &rpcResponse{IsDisposed: true, ID: req.ID}
+
+	if h, ok := s.handler(req.Pattern); !ok {
+		res.Err = noMessageHandler
+	} else if response, err := h(ctx, d); err != nil {
+		res.Err = err.Error()
+	} else {
+		res.Response = response
+	}
+
+	body, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+
+	correlationID := d.CorrelationId
+	if correlationID == "" {
+		correlationID = req.ID
+	}
+
+	return reply(ctx, d.ReplyTo, amqplib.Publishing{
+		ContentType:   ContentTypeJSON,
+		CorrelationId: correlationID,
+		Timestamp:     time.Now().UTC(),
+		Body:          body,
+	})
+}
+
+// rpcClient owns the channel that consumes direct replies. Replies are only
+// delivered to the channel the request was published on, so every call
+// publishes on it too.
+type rpcClient struct {
+	mu      sync.Mutex
+	channel *amqplib.Channel
+	pending map[string]*pendingCall
+}
+
+type pendingCall struct {
+	channel *amqplib.Channel
+	replies chan *rpcResponse
+}
+
+func newRPCClient() *rpcClient {
+	return &rpcClient{pending: map[string]*pendingCall{}}
+}
+
+// Call sends a request to queue and waits for the reply, up to the configured
+// RPC timeout. It interoperates with NestJS @MessagePattern handlers in both
+// directions.
+func (b *rabbitmq) Call(ctx context.Context, queue, pattern string, payload any) (json.RawMessage, error) {
+	if err := b.acquire(); err != nil {
+		return nil, err
+	}
+	defer b.release()
+
+	ctx, cancel := context.WithTimeout(ctx, b.config.RPCTimeout)
+	defer cancel()
+
+	ctx, span := startPublishSpan(ctx, queue, &MessageType{Pattern: pattern})
+	defer span.End()
+
+	result, err := b.call(ctx, queue, pattern, payload)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	return result, err
+}
+
+func (b *rabbitmq) call(ctx context.Context, queue, pattern string, payload any) (json.RawMessage, error) {
+	publishing, id, err := newRPCRequest(ctx, pattern, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// A failed declaration closes its channel, which must not be the shared
+	// reply channel, so a pool channel is used.
+	pool, pc, err := b.checkout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	_, err = b.declareQueue(queue, pc.Channel)
+	pool.Put(pc)
+	pool.Release()
+	if err != nil {
+		return nil, err
+	}
+
+	replies := make(chan *rpcResponse, 8)
+
+	channel, err := b.rpcChannel(id, replies)
+	if err != nil {
+		return nil, err
+	}
+	defer b.rpc.forget(id)
+
+	if err := channel.PublishWithContext(ctx, "", queue, false, false, publishing); err != nil {
+		return nil, err
+	}
+
+	return awaitRPCResponse(ctx, replies)
+}
+
+// rpcChannel registers replies for the request id and returns the reply
+// channel, opening it if there is none yet or the previous one was closed.
+func (b *rabbitmq) rpcChannel(id string, replies chan *rpcResponse) (*amqplib.Channel, error) {
+	b.rpc.mu.Lock()
+	defer b.rpc.mu.Unlock()
+
+	if b.rpc.channel == nil || b.rpc.channel.IsClosed() {
+		b.mu.RLock()
+		conn := b.conn
+		b.mu.RUnlock()
+
+		if conn == nil || conn.IsClosed() {
+			return nil, ErrNotConnected
+		}
+
+		channel, err := conn.Channel()
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries, err := channel.Consume(directReplyTo, "", true, true, false, false, nil)
+		if err != nil {
+			_ = channel.Close()
+			return nil, err
+		}
+
+		b.rpc.channel = channel
+		go b.routeReplies(channel, deliveries)
+	}
+
+	b.rpc.pending[id] = &pendingCall{channel: b.rpc.channel, replies: replies}
+
+	return b.rpc.channel, nil
+}
+
+// routeReplies hands every reply to the call waiting for its correlation id
+// and, once the channel closes, fails the calls still waiting on it.
+func (b *rabbitmq) routeReplies(channel *amqplib.Channel, deliveries <-chan amqplib.Delivery) {
+	for d := range deliveries {
+		var res rpcResponse
+		if err := json.Unmarshal(d.Body, &res); err != nil {
+			b.logger.Error("AMQP failed to decode rpc reply", logger.Field{Key: "correlation_id", Value: d.CorrelationId}, logger.Field{Key: "error", Value: err.Error()})
+			continue
+		}
+
+		b.rpc.mu.Lock()
+		call, ok := b.rpc.pending[d.CorrelationId]
+		b.rpc.mu.Unlock()
+
+		if !ok {
+			b.logger.Warn("AMQP received rpc reply for unknown request", logger.Field{Key: "correlation_id", Value: d.CorrelationId})
+			continue
+		}
+
+		select {
+		case call.replies <- &res:
+		default:
+			b.logger.Warn("AMQP dropped rpc reply, caller is not reading", logger.Field{Key: "correlation_id", Value: d.CorrelationId})
+		}
+	}
+
+	b.rpc.mu.Lock()
+	defer b.rpc.mu.Unlock()
+
+	if b.rpc.channel == channel {
+		b.rpc.channel = nil
+	}
+	for id, call := range b.rpc.pending {
+		if call.channel == channel {
+			close(call.replies)
+			delete(b.rpc.pending, id)
+		}
+	}
+}
+
+func (c *rpcClient) forget(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.pending, id)
+}
+
+// Serve registers handler for requests matching pattern on the configured
+// RPC queue. The queue is consumed from the first registration on. Requests
+// are not signature checked, since NestJS clients cannot sign them.
+func (b *rabbitmq) Serve(pattern string, handler RPCHandler) {
+	b.rpcServer.register(pattern, handler)
+
+	b.rpcServer.once.Do(func() {
+		go func() {
+			err := b.consumeQueue(b.ctx, b.config.RPCQueue, func(ctx context.Context, d *Delivery) error {
+				return b.rpcServer.handle(ctx, d, b.reply)
+			})
+			if err != nil && !errors.Is(err, context.Canceled) {
+				b.logger.Error("AMQP rpc server stopped", logger.Field{Key: "queue", Value: b.config.RPCQueue}, logger.Field{Key: "error", Value: err.Error()})
+			}
+		}()
+	})
+}
+
+func (b *rabbitmq) reply(ctx context.Context, replyTo string, msg amqplib.Publishing) error {
+	return b.publish(ctx, "", replyTo, msg)
+}
@@ -0,0 +1,200 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	amqplib "github.com/rabbitmq/amqp091-go"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/config"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+)
+
+func TestRPCRoundTrip(t *testing.T) {
+	m := NewMemoryBroker(&Opts{
+		Logger: logger.NewZerologLogger("error", nil),
+		Config: &config.AMQP{DefaultCodec: "json", RPCQueue: "user-service.rpc"},
+	})
+	t.Cleanup(func() { _ = m.Close(context.Background()) })
+
+	m.Serve("user.get", func(ctx context.Context, d *Delivery) (any, error) {
+		var req struct {
+			Data struct {
+				ID int `json:"id"`
+			} `json:"data"`
+		}
+		if err := d.decodeBody(&req); err != nil {
+			return nil, err
+		}
+		if req.Data.ID != 1 {
+			return nil, errors.New("user not found")
+		}
+		return map[string]any{"id": 1, "name": "Jane"}, nil
+	})
+
+	res, err := m.Call(context.Background(), "user-service.rpc", "user.get", map[string]int{"id": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res) != `{"id":1,"name":"Jane"}` {
+		t.Errorf("got response %s", res)
+	}
+
+	var rpcErr *RPCError
+	if _, err := m.Call(context.Background(), "user-service.rpc", "user.get", map[string]int{"id": 2}); !errors.As(err, &rpcErr) || rpcErr.Message != "user not found" {
+		t.Errorf("got error %v, want the handler's error", err)
+	}
+	if _, err := m.Call(context.Background(), "user-service.rpc", "user.delete", nil); !errors.As(err, &rpcErr) || rpcErr.Message != noMessageHandler {
+		t.Errorf("got error %v, want Nest's missing handler error", err)
+	}
+	if _, err := m.Call(context.Background(), "other.rpc", "user.get", nil); !errors.Is(err, ErrQueueNotFound) {
+		t.Errorf("got error %v, want %v", err, ErrQueueNotFound)
+	}
+}
+
+func TestRPCRequestIsNestPacket(t *testing.T) {
+	publishing, id, err := newRPCRequest(context.Background(), "user.get", map[string]int{"id": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var packet map[string]any
+	if err := json.Unmarshal(publishing.Body, &packet); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"pattern": "user.get", "data": map[string]any{"id": float64(1)}, "id": id}
+	if !reflect.DeepEqual(packet, want) {
+		t.Errorf("got packet %v, want %v", packet, want)
+	}
+	if publishing.CorrelationId != id || publishing.ReplyTo != directReplyTo || publishing.ContentType != ContentTypeJSON {
+		t.Errorf("got correlation id %q, reply to %q and content type %q", publishing.CorrelationId, publishing.ReplyTo, publishing.ContentType)
+	}
+}
+
+func TestRPCServerRepliesWithNestPacket(t *testing.T) {
+	s := newRPCServer()
+	s.register("user.get", func(ctx context.Context, d *Delivery) (any, error) {
+		return "Jane", nil
+	})
+
+	for name, correlationID := range map[string]string{"correlated": "c-1", "uncorrelated": ""} {
+		t.Run(name, func(t *testing.T) {
+			d := &Delivery{Delivery: amqplib.Delivery{
+				ContentType:   ContentTypeJSON,
+				CorrelationId: correlationID,
+				ReplyTo:       directReplyTo,
+				Body:          []byte(`{"pattern":"user.get","data":{},"id":"r-1"}`),
+			}}
+
+			var replyTo string
+			var reply amqplib.Publishing
+			err := s.handle(context.Background(), d, func(ctx context.Context, to string, msg amqplib.Publishing) error {
+				replyTo, reply = to, msg
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if replyTo != directReplyTo {
+				t.Errorf("replied to %q, want %q", replyTo, directReplyTo)
+			}
+			// Nest matches replies by correlation id, which it sets to the
+			// request id.
+			want := correlationID
+			if want == "" {
+				want = "r-1"
+			}
+			if reply.CorrelationId != want {
+				t.Errorf("reply correlation id %q, want %q", reply.CorrelationId, want)
+			}
+
+			var packet map[string]any
+			if err := json.Unmarshal(reply.Body, &packet); err != nil {
+				t.Fatal(err)
+			}
+			if wantPacket := map[string]any{"err": nil, "response": "Jane", "isDisposed": true, "id": "r-1"}; !reflect.DeepEqual(packet, wantPacket) {
+				t.Errorf("got packet %v, want %v", packet, wantPacket)
+			}
+		})
+	}
+}
+
+func TestRPCServerRejectsEvents(t *testing.T) {
+	d := &Delivery{Delivery: amqplib.Delivery{
+		ContentType: ContentTypeJSON,
+		Body:        []byte(`{"pattern":"user.created","data":{}}`),
+	}}
+
+	err := newRPCServer().handle(context.Background(), d, func(context.Context, string, amqplib.Publishing) error {
+		t.Error("replied to an event")
+		return nil
+	})
+	if err == nil {
+		t.Fatal("event handled as a request")
+	}
+}
+
+func TestRouteRepliesByCorrelationID(t *testing.T) {
+	b := &rabbitmq{logger: logger.NewZerologLogger("error", nil), rpc: newRPCClient()}
+	channel := &amqplib.Channel{}
+	b.rpc.channel = channel
+
+	first, second := make(chan *rpcResponse, 1), make(chan *rpcResponse, 1)
+	b.rpc.pending["first"] = &pendingCall{channel: channel, replies: first}
+	b.rpc.pending["second"] = &pendingCall{channel: channel, replies: second}
+
+	deliveries := make(chan amqplib.Delivery, 3)
+	deliveries <- amqplib.Delivery{CorrelationId: "second", Body: []byte(`{"response":2,"isDisposed":true}`)}
+	deliveries <- amqplib.Delivery{CorrelationId: "unknown", Body: []byte(`{"response":0,"isDisposed":true}`)}
+	deliveries <- amqplib.Delivery{CorrelationId: "first", Body: []byte(`{"response":1,"isDisposed":true}`)}
+	close(deliveries)
+
+	b.routeReplies(channel, deliveries)
+
+	for want, replies := range map[float64]chan *rpcResponse{1: first, 2: second} {
+		res, ok := <-replies
+		if !ok || res.Response != want {
+			t.Errorf("got reply %+v, want response %v", res, want)
+		}
+		// The closed reply channel fails the call if it is still waiting.
+		if _, ok := <-replies; ok {
+			t.Error("reply channel left open after the rpc channel closed")
+		}
+	}
+	if len(b.rpc.pending) != 0 || b.rpc.channel != nil {
+		t.Errorf("pending calls %v and channel %v left after the rpc channel closed", b.rpc.pending, b.rpc.channel)
+	}
+}
+
+func TestAwaitRPCResponse(t *testing.T) {
+	// Nest streams a packet per emitted value; the last one is the result.
+	replies := make(chan *rpcResponse, 2)
+	replies <- &rpcResponse{Response: "partial"}
+	replies <- &rpcResponse{Response: "final", IsDisposed: true}
+	if res, err := awaitRPCResponse(context.Background(), replies); err != nil || string(res) != `"final"` {
+		t.Errorf("got %s, %v, want the final packet", res, err)
+	}
+
+	replies = make(chan *rpcResponse, 1)
+	replies <- &rpcResponse{Err: map[string]any{"status": "error", "message": "boom"}, IsDisposed: true}
+	var rpcErr *RPCError
+	if _, err := awaitRPCResponse(context.Background(), replies); !errors.As(err, &rpcErr) || rpcErr.Message != "boom" {
+		t.Errorf("got error %v, want the RpcException message", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := awaitRPCResponse(ctx, make(chan *rpcResponse)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	closed := make(chan *rpcResponse)
+	close(closed)
+	if _, err := awaitRPCResponse(context.Background(), closed); !errors.Is(err, ErrRPCChannelClosed) {
+		t.Errorf("got error %v, want %v", err, ErrRPCChannelClosed)
+	}
+}
@@ -0,0 +1,105 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+)
+
+var ErrClosed = errors.New("amqp client is closed")
+
+// spoolDrainPollInterval is how often Close checks whether the spool has been
+// flushed.
+const spoolDrainPollInterval = 50 * time.Millisecond
+
+// Close shuts the client down gracefully. New publishes are rejected with
+// ErrClosed, then it waits for in-flight publishes to be confirmed and, while
+// the broker is reachable, for the spool to be flushed, before closing the
+// channels and the connection. Messages still spooled when ctx expires stay
+// on disk for the next start. The returned error is ctx's if the deadline cut
+// the drain short; the connection is closed either way.
+func (b *rabbitmq) Close(ctx context.Context) error {
+	b.closeMu.Lock()
+	alreadyClosing := b.closing
+	b.closing = true
+	b.closeMu.Unlock()
+
+	if alreadyClosing {
+		return ErrClosed
+	}
+
+	b.logger.Info("AMQP closing, draining in-flight publishes")
+
+	err := b.waitInflight(ctx)
+	if err == nil {
+		err = b.waitSpoolDrained(ctx)
+	}
+	if err != nil {
+		b.logger.Warn("AMQP shutdown deadline reached before publishes drained", logger.Field{Key: "error", Value: err.Error()})
+	}
+
+	b.cancel()
+	<-b.done
+
+	b.logger.Info("AMQP connection closed")
+
+	return err
+}
+
+// acquire registers an in-flight publish, unless the client is closing.
+func (b *rabbitmq) acquire() error {
+	b.closeMu.RLock()
+	defer b.closeMu.RUnlock()
+
+	if b.closing {
+		return ErrClosed
+	}
+	b.inflight.Add(1)
+
+	return nil
+}
+
+func (b *rabbitmq) release() {
+	b.inflight.Done()
+}
+
+func (b *rabbitmq) waitInflight(ctx context.Context) error {
+	drained := make(chan struct{})
+	go func() {
+		b.inflight.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *rabbitmq) waitSpoolDrained(ctx context.Context) error {
+	if b.spool == nil {
+		return nil
+	}
+
+	t := time.NewTicker(spoolDrainPollInterval)
+	defer t.Stop()
+
+	for b.spool.Depth() > 0 {
+		if state := b.state.Current(); state != StateConnected && state != StateBlocked {
+			b.logger.Warn("AMQP broker unavailable, leaving messages in spool", logger.Field{Key: "depth", Value: b.spool.Depth()})
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+
+	return nil
+}
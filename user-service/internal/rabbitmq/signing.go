@@ -0,0 +1,288 @@
+package rabbitmq
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	amqplib "github.com/rabbitmq/amqp091-go"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/config"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+)
+
+const (
+	HeaderSignature          = "x-signature"
+	HeaderSignatureKeyID     = "x-signature-key-id"
+	HeaderSignatureAlgorithm = "x-signature-alg"
+
+	SignatureHMACSHA256 = "hmac-sha256"
+	SignatureEd25519    = "ed25519"
+
+	SignatureVerifyOff        = "off"
+	SignatureVerifyReject     = "reject"
+	SignatureVerifyQuarantine = "quarantine"
+)
+
+var (
+	ErrSigningKeyNotFound = errors.New("amqp signing key not found")
+	ErrInvalidSigningKey  = errors.New("amqp signing key is invalid")
+	ErrMessageUnsigned    = errors.New("amqp message is not signed")
+	ErrInvalidSignature   = errors.New("amqp message signature is invalid")
+)
+
+// signingKey is an HMAC secret or an Ed25519 key pair. A key with only the
+// Ed25519 public half can verify but not sign.
+type signingKey struct {
+	algorithm string
+	secret    []byte
+	private   ed25519.PrivateKey
+	public    ed25519.PublicKey
+}
+
+// keyring holds the keys messages are signed and verified with. Keys are
+// looked up by the id carried in each message, so a new key can be rolled
+// out to consumers before publishers switch to it, and the old one removed
+// once no message signed with it is left.
+type keyring struct {
+	active string
+	keys   map[string]*signingKey
+}
+
+// newKeyring parses the configured keys. Invalid keys are left out, so
+// signing with them or verifying messages signed with them fails rather than
+// being skipped.
+func newKeyring(cfg *config.AMQPSigning, log logger.Logger) *keyring {
+	k := &keyring{keys: map[string]*signingKey{}}
+	if cfg == nil {
+		return k
+	}
+
+	k.active = cfg.KeyID
+	for id, spec := range cfg.Keys {
+		key, err := parseSigningKey(spec)
+		if err != nil {
+			log.Error("AMQP ignoring invalid signing key", logger.Field{Key: "key_id", Value: id}, logger.Field{Key: "error", Value: err.Error()})
+			continue
+		}
+		k.keys[id] = key
+	}
+
+	return k
+}
+
+// parseSigningKey reads "hmac-sha256:<secret>", "ed25519:<seed>" or
+// "ed25519-public:<public key>", all base64 encoded.
+func parseSigningKey(spec string) (*signingKey, error) {
+	algorithm, encoded, ok := strings.Cut(spec, ":")
+	if !ok {
+		return nil, fmt.Errorf("%w: want <algorithm>:<base64 key>", ErrInvalidSigningKey)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSigningKey, err)
+	}
+
+	switch algorithm {
+	case SignatureHMACSHA256:
+		if len(raw) < 32 {
+			return nil, fmt.Errorf("%w: hmac secret must be at least 32 bytes", ErrInvalidSigningKey)
+		}
+		return &signingKey{algorithm: SignatureHMACSHA256, secret: raw}, nil
+	case SignatureEd25519:
+		if len(raw) != ed25519.SeedSize {
+			return nil, fmt.Errorf("%w: ed25519 seed must be %d bytes", ErrInvalidSigningKey, ed25519.SeedSize)
+		}
+		private := ed25519.NewKeyFromSeed(raw)
+		return &signingKey{algorithm: SignatureEd25519, private: private, public: private.Public().(ed25519.PublicKey)}, nil
+	case SignatureEd25519 + "-public":
+		if len(raw) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w: ed25519 public key must be %d bytes", ErrInvalidSigningKey, ed25519.PublicKeySize)
+		}
+		return &signingKey{algorithm: SignatureEd25519, public: ed25519.PublicKey(raw)}, nil
+	}
+
+	return nil, fmt.Errorf("%w: unknown algorithm %q", ErrInvalidSigningKey, algorithm)
+}
+
+// signedHeaders are the headers a signature covers, those that change how the
+// body is read or which event it is. The rest are left out because brokers
+// and retries add and rewrite them (x-death, x-delay, x-attempts, trace
+// context) on the way, which would invalidate every redelivered message.
+var signedHeaders = []string{
+	HeaderSchemaVersion,
+	cloudEventsHeaderPrefix + "id",
+	cloudEventsHeaderPrefix + "schemaversion",
+	cloudEventsHeaderPrefix + "source",
+	cloudEventsHeaderPrefix + "specversion",
+	cloudEventsHeaderPrefix + "subject",
+	cloudEventsHeaderPrefix + "type",
+}
+
+// signingInput is what a signature covers: the body as sent, and the
+// properties and headers that change how it is interpreted, so that a signed
+// body cannot be replayed as a different event. Header values are compared
+// as text, since their integer types can change on the wire.
+func signingInput(algorithm, keyID string, p *amqplib.Publishing) []byte {
+	var b bytes.Buffer
+	writeField := func(field string) {
+		b.WriteString(strconv.Itoa(len(field)))
+		b.WriteByte(':')
+		b.WriteString(field)
+	}
+
+	for _, field := range []string{algorithm, keyID, p.Type, p.MessageId, p.ContentType, p.ContentEncoding} {
+		writeField(field)
+	}
+	for _, name := range signedHeaders {
+		writeField(name)
+		if v, ok := p.Headers[name]; ok {
+			writeField(fmt.Sprint(v))
+		} else {
+			// Lengths are digits, so this cannot be taken for a value.
+			b.WriteByte('-')
+		}
+	}
+	b.Write(p.Body)
+
+	return b.Bytes()
+}
+
+// sign adds the signature headers with the active key, if signing is
+// enabled.
+func (k *keyring) sign(p *amqplib.Publishing) error {
+	if k.active == "" {
+		return nil
+	}
+
+	key, ok := k.keys[k.active]
+	if !ok || (key.algorithm == SignatureEd25519 && key.private == nil) {
+		return fmt.Errorf("%w: %s", ErrSigningKeyNotFound, k.active)
+	}
+
+	input := signingInput(key.algorithm, k.active, p)
+
+	var signature []byte
+	switch key.algorithm {
+	case SignatureHMACSHA256:
+		mac := hmac.New(sha256.New, key.secret)
+		mac.Write(input)
+		signature = mac.Sum(nil)
+	case SignatureEd25519:
+		signature = ed25519.Sign(key.private, input)
+	}
+
+	if p.Headers == nil {
+		p.Headers = amqplib.Table{}
+	}
+	p.Headers[HeaderSignature] = base64.StdEncoding.EncodeToString(signature)
+	p.Headers[HeaderSignatureKeyID] = k.active
+	p.Headers[HeaderSignatureAlgorithm] = key.algorithm
+
+	return nil
+}
+
+// verify checks the signature of a delivery. The algorithm must match the
+// key's, so a public key can never be used as an HMAC secret.
+func (k *keyring) verify(d *amqplib.Delivery) error {
+	encoded, _ := d.Headers[HeaderSignature].(string)
+	keyID, _ := d.Headers[HeaderSignatureKeyID].(string)
+	algorithm, _ := d.Headers[HeaderSignatureAlgorithm].(string)
+	if encoded == "" || keyID == "" {
+		return ErrMessageUnsigned
+	}
+
+	key, ok := k.keys[keyID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrSigningKeyNotFound, keyID)
+	}
+	if algorithm != key.algorithm {
+		return fmt.Errorf("%w: key %s is %s, message claims %s", ErrInvalidSignature, keyID, key.algorithm, algorithm)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+
+	input := signingInput(algorithm, keyID, &amqplib.Publishing{
+		Type:            d.Type,
+		MessageId:       d.MessageId,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		Headers:         d.Headers,
+		Body:            d.Body,
+	})
+
+	var valid bool
+	switch key.algorithm {
+	case SignatureHMACSHA256:
+		mac := hmac.New(sha256.New, key.secret)
+		mac.Write(input)
+		valid = hmac.Equal(signature, mac.Sum(nil))
+	case SignatureEd25519:
+		valid = ed25519.Verify(key.public, input, signature)
+	}
+
+	if !valid {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+func signatureFailureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrMessageUnsigned):
+		return "unsigned"
+	case errors.Is(err, ErrSigningKeyNotFound):
+		return "unknown_key"
+	}
+
+	return "invalid"
+}
+
+// verifySignatures wraps handler so that it only sees messages with a valid
+// signature. Others are rejected, and so dead-lettered if the queue has a
+// dead-letter exchange, or moved to the quarantine queue.
+func (b *rabbitmq) verifySignatures(queue string, handler Handler) Handler {
+	mode := SignatureVerifyOff
+	if b.config.Signing != nil {
+		mode = b.config.Signing.Verify
+	}
+	if mode == SignatureVerifyOff || mode == "" {
+		return handler
+	}
+
+	return func(ctx context.Context, d *Delivery) error {
+		err := b.keyring.verify(&d.Delivery)
+		if err == nil {
+			return handler(ctx, d)
+		}
+
+		signatureFailuresTotal.WithLabelValues(signatureFailureReason(err)).Inc()
+		b.logger.Warn("AMQP message failed signature verification",
+			logger.Field{Key: "queue", Value: queue},
+			logger.Field{Key: "event", Value: d.Pattern()},
+			logger.Field{Key: "message_id", Value: d.MessageId},
+			logger.Field{Key: "error", Value: err.Error()},
+		)
+
+		if mode != SignatureVerifyQuarantine {
+			return err
+		}
+
+		if qerr := b.quarantine(ctx, queue, d, &failure{err: err, attempts: 1}); qerr != nil {
+			return fmt.Errorf("%w, and quarantining it failed: %w", err, qerr)
+		}
+
+		return d.Ack(false)
+	}
+}
@@ -0,0 +1,216 @@
+package rabbitmq
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	amqplib "github.com/rabbitmq/amqp091-go"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/config"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+)
+
+var (
+	testHMACKey    = SignatureHMACSHA256 + ":" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	testEd25519Key = SignatureEd25519 + ":" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, ed25519.SeedSize))
+)
+
+func newTestKeyring(t *testing.T, active string, keys map[string]string) *keyring {
+	t.Helper()
+
+	k := newKeyring(&config.AMQPSigning{KeyID: active, Keys: keys}, logger.NewZerologLogger("error", nil))
+	if len(k.keys) != len(keys) {
+		t.Fatalf("parsed %d of %d keys", len(k.keys), len(keys))
+	}
+
+	return k
+}
+
+func testPublishing() amqplib.Publishing {
+	return amqplib.Publishing{
+		Headers:     amqplib.Table{HeaderSchemaVersion: 1},
+		Type:        "user.created",
+		MessageId:   "42",
+		ContentType: "application/json",
+		Body:        []byte(`{"pattern":"user.created","data":{"id":1}}`),
+	}
+}
+
+// signedDelivery signs a publishing with k and returns it as it is delivered.
+func signedDelivery(t *testing.T, k *keyring, change func(*amqplib.Publishing)) *amqplib.Delivery {
+	t.Helper()
+
+	p := testPublishing()
+	if err := k.sign(&p); err != nil {
+		t.Fatal(err)
+	}
+	if change != nil {
+		change(&p)
+	}
+
+	return &amqplib.Delivery{
+		Acknowledger:    nopAcknowledger{},
+		Headers:         p.Headers,
+		Type:            p.Type,
+		MessageId:       p.MessageId,
+		ContentType:     p.ContentType,
+		ContentEncoding: p.ContentEncoding,
+		Body:            p.Body,
+	}
+}
+
+func TestSignThenVerify(t *testing.T) {
+	for name, key := range map[string]string{"hmac": testHMACKey, "ed25519": testEd25519Key} {
+		t.Run(name, func(t *testing.T) {
+			k := newTestKeyring(t, "k1", map[string]string{"k1": key})
+
+			// Headers outside the signed set are rewritten on the way.
+			d := signedDelivery(t, k, func(p *amqplib.Publishing) {
+				p.Headers[HeaderAttempts] = int64(3)
+				p.Headers[HeaderSchemaVersion] = int64(1)
+			})
+			if err := k.verify(d); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsTamperedMessages(t *testing.T) {
+	k := newTestKeyring(t, "k1", map[string]string{"k1": testHMACKey})
+
+	changes := map[string]func(*amqplib.Publishing){
+		"body":           func(p *amqplib.Publishing) { p.Body = []byte(`{"pattern":"user.created","data":{"id":2}}`) },
+		"type":           func(p *amqplib.Publishing) { p.Type = "user.deleted" },
+		"message id":     func(p *amqplib.Publishing) { p.MessageId = "43" },
+		"content type":   func(p *amqplib.Publishing) { p.ContentType = "application/msgpack" },
+		"schema version": func(p *amqplib.Publishing) { p.Headers[HeaderSchemaVersion] = 2 },
+		"removed header": func(p *amqplib.Publishing) { delete(p.Headers, HeaderSchemaVersion) },
+		"added header":   func(p *amqplib.Publishing) { p.Headers[cloudEventsHeaderPrefix+"type"] = "user.deleted" },
+	}
+	for name, change := range changes {
+		t.Run(name, func(t *testing.T) {
+			if err := k.verify(signedDelivery(t, k, change)); !errors.Is(err, ErrInvalidSignature) {
+				t.Fatalf("got error %v, want %v", err, ErrInvalidSignature)
+			}
+		})
+	}
+}
+
+func TestVerifyKeyAndAlgorithmMismatch(t *testing.T) {
+	signer := newTestKeyring(t, "k1", map[string]string{"k1": testHMACKey})
+
+	unsigned := signedDelivery(t, newTestKeyring(t, "", nil), nil)
+	if err := signer.verify(unsigned); !errors.Is(err, ErrMessageUnsigned) {
+		t.Errorf("unsigned: got error %v, want %v", err, ErrMessageUnsigned)
+	}
+
+	unknown := newTestKeyring(t, "", map[string]string{"k2": testHMACKey})
+	if err := unknown.verify(signedDelivery(t, signer, nil)); !errors.Is(err, ErrSigningKeyNotFound) {
+		t.Errorf("unknown key: got error %v, want %v", err, ErrSigningKeyNotFound)
+	}
+
+	claimed := signedDelivery(t, signer, func(p *amqplib.Publishing) {
+		p.Headers[HeaderSignatureAlgorithm] = SignatureEd25519
+	})
+	if err := signer.verify(claimed); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("algorithm mismatch: got error %v, want %v", err, ErrInvalidSignature)
+	}
+
+	// A consumer holding only the public half can verify but not sign.
+	seed := bytes.Repeat([]byte{2}, ed25519.SeedSize)
+	public := ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey)
+	verifier := newTestKeyring(t, "k1", map[string]string{"k1": SignatureEd25519 + "-public:" + base64.StdEncoding.EncodeToString(public)})
+	if err := verifier.verify(signedDelivery(t, newTestKeyring(t, "k1", map[string]string{"k1": testEd25519Key}), nil)); err != nil {
+		t.Errorf("public key: %v", err)
+	}
+	p := testPublishing()
+	if err := verifier.sign(&p); !errors.Is(err, ErrSigningKeyNotFound) {
+		t.Errorf("signing with a public key: got error %v, want %v", err, ErrSigningKeyNotFound)
+	}
+}
+
+func TestParseSigningKeyRejectsBadKeys(t *testing.T) {
+	specs := []string{
+		"no-separator",
+		SignatureHMACSHA256 + ":not base64!",
+		SignatureHMACSHA256 + ":" + base64.StdEncoding.EncodeToString([]byte("too short")),
+		SignatureEd25519 + ":" + base64.StdEncoding.EncodeToString([]byte("too short")),
+		SignatureEd25519 + "-public:" + base64.StdEncoding.EncodeToString([]byte("too short")),
+		"rsa:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)),
+	}
+	for _, spec := range specs {
+		if _, err := parseSigningKey(spec); !errors.Is(err, ErrInvalidSigningKey) {
+			t.Errorf("%q: got error %v, want %v", spec, err, ErrInvalidSigningKey)
+		}
+	}
+
+	k := newKeyring(&config.AMQPSigning{KeyID: "bad", Keys: map[string]string{"bad": "no-separator"}}, logger.NewZerologLogger("error", nil))
+	p := testPublishing()
+	if err := k.sign(&p); !errors.Is(err, ErrSigningKeyNotFound) {
+		t.Errorf("signing with an invalid key: got error %v, want %v", err, ErrSigningKeyNotFound)
+	}
+}
+
+func TestVerifySignaturesModes(t *testing.T) {
+	keys := map[string]string{"k1": testHMACKey}
+	forged := signedDelivery(t, newTestKeyring(t, "k1", keys), func(p *amqplib.Publishing) { p.Body = []byte(`{}`) })
+
+	newBroker := func(mode string) *rabbitmq {
+		return &rabbitmq{
+			config: &config.AMQP{
+				PublishTimeout: time.Second,
+				Signing:        &config.AMQPSigning{Keys: keys, Verify: mode},
+				Quarantine:     &config.AMQPQuarantine{Queue: "user-service.quarantine"},
+			},
+			logger:  logger.NewZerologLogger("error", nil),
+			keyring: newTestKeyring(t, "", keys),
+		}
+	}
+
+	for _, tc := range []struct {
+		mode        string
+		handled     bool
+		quarantined bool
+	}{
+		{mode: SignatureVerifyOff, handled: true},
+		{mode: SignatureVerifyReject},
+		{mode: SignatureVerifyQuarantine, quarantined: true},
+	} {
+		t.Run(tc.mode, func(t *testing.T) {
+			handled := false
+			handler := newBroker(tc.mode).verifySignatures("notification-service", func(ctx context.Context, d *Delivery) error {
+				handled = true
+				return nil
+			})
+
+			d := &Delivery{Delivery: *forged}
+			err := handler(context.Background(), d)
+
+			if handled != tc.handled {
+				t.Errorf("handled = %v, want %v", handled, tc.handled)
+			}
+			if tc.handled {
+				if err != nil {
+					t.Errorf("got error %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("got error %v, want %v", err, ErrInvalidSignature)
+			}
+			// The broker is not connected, so only an attempt to quarantine
+			// fails with ErrNotConnected.
+			if errors.Is(err, ErrNotConnected) != tc.quarantined {
+				t.Errorf("got error %v, quarantine attempted = %v", err, tc.quarantined)
+			}
+			if d.settled {
+				t.Error("a message that was not quarantined must not be acked")
+			}
+		})
+	}
+}
@@ -0,0 +1,485 @@
+package rabbitmq
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	amqplib "github.com/rabbitmq/amqp091-go"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/config"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+)
+
+var ErrSpoolFull = errors.New("amqp publish spool is full")
+
+const (
+	SpoolFsyncAlways   = "always"
+	SpoolFsyncInterval = "interval"
+	SpoolFsyncNever    = "never"
+
+	spoolSegmentExt   = ".seg"
+	spoolFrameHeader  = 8
+	spoolSegmentWidth = 20
+)
+
+// spoolRecord is a message that could not be delivered to the broker, kept
+// exactly as it would have been published.
+type spoolRecord struct {
+	Exchange   string             `json:"exchange"`
+	RoutingKey string             `json:"routing_key"`
+	Publishing amqplib.Publishing `json:"publishing"`
+	EnqueuedAt time.Time          `json:"enqueued_at"`
+}
+
+type spoolSegment struct {
+	seq     uint64
+	path    string
+	size    int64
+	records int
+}
+
+// spool is an append-only on-disk log split into numbered segment files.
+// Records are framed as [length][crc32][json] and consumed strictly in order;
+// a segment is deleted once every record in it has been acknowledged.
+type spool struct {
+	config *config.AMQPSpool
+	logger logger.Logger
+
+	mu         sync.Mutex
+	segments   []*spoolSegment
+	writer     *os.File
+	reader     *os.File
+	readOffset int64
+	readCount  int
+	totalBytes int64
+	depth      int
+	head       *spoolRecord
+	dirty      bool
+	done       chan struct{}
+}
+
+func newSpool(cfg *config.AMQPSpool, log logger.Logger) (*spool, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := &spool{
+		config: cfg,
+		logger: log,
+		done:   make(chan struct{}),
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	if cfg.FsyncPolicy == SpoolFsyncInterval {
+		go s.syncLoop()
+	}
+
+	return s, nil
+}
+
+// Append writes a record to the tail segment, rotating to a new segment when
+// the current one reaches the configured size.
+func (s *spool) Append(rec *spoolRecord) error {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	frame := make([]byte, spoolFrameHeader+len(payload))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(payload))
+	copy(frame[spoolFrameHeader:], payload)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.config.MaxBytes > 0 && s.totalBytes+int64(len(frame)) > s.config.MaxBytes {
+		return ErrSpoolFull
+	}
+
+	tail := s.segments[len(s.segments)-1]
+	if tail.size > 0 && tail.size+int64(len(frame)) > s.config.SegmentBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+		tail = s.segments[len(s.segments)-1]
+	}
+
+	if _, err := s.writer.Write(frame); err != nil {
+		return err
+	}
+
+	switch s.config.FsyncPolicy {
+	case SpoolFsyncAlways:
+		if err := s.writer.Sync(); err != nil {
+			return err
+		}
+	case SpoolFsyncInterval:
+		s.dirty = true
+	}
+
+	tail.size += int64(len(frame))
+	tail.records++
+	s.totalBytes += int64(len(frame))
+	s.depth++
+
+	return nil
+}
+
+// Peek returns the oldest unacknowledged record, or nil when the spool is empty.
+func (s *spool) Peek() (*spoolRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.peek()
+}
+
+// Ack drops the record returned by the last Peek.
+func (s *spool) Ack() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.head == nil {
+		return nil
+	}
+
+	s.head = nil
+	s.readCount++
+	s.depth--
+
+	if s.depth == 0 {
+		return s.reset()
+	}
+
+	return s.advance()
+}
+
+func (s *spool) Depth() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.depth
+}
+
+func (s *spool) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.totalBytes
+}
+
+// OldestAge reports how long the oldest pending record has been waiting.
+func (s *spool) OldestAge() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, err := s.peek()
+	if err != nil || rec == nil {
+		return 0
+	}
+
+	return time.Since(rec.EnqueuedAt)
+}
+
+func (s *spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.done:
+		return nil
+	default:
+		close(s.done)
+	}
+
+	if s.reader != nil {
+		_ = s.reader.Close()
+	}
+
+	if err := s.writer.Sync(); err != nil {
+		return err
+	}
+
+	return s.writer.Close()
+}
+
+func (s *spool) peek() (*spoolRecord, error) {
+	if s.head != nil {
+		return s.head, nil
+	}
+	if s.depth == 0 {
+		return nil, nil
+	}
+
+	if err := s.advance(); err != nil {
+		return nil, err
+	}
+
+	head := s.segments[0]
+	if s.reader == nil {
+		f, err := os.Open(head.path)
+		if err != nil {
+			return nil, err
+		}
+		s.reader = f
+	}
+
+	payload, n, err := readSpoolFrame(s.reader, s.readOffset)
+	if err != nil {
+		return nil, err
+	}
+
+	var rec spoolRecord
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return nil, err
+	}
+
+	s.readOffset += n
+	s.head = &rec
+
+	return s.head, nil
+}
+
+// advance deletes the head segment once all of its records are acknowledged
+// and a newer segment exists to read from.
+func (s *spool) advance() error {
+	for len(s.segments) > 1 && s.readCount >= s.segments[0].records {
+		head := s.segments[0]
+
+		if s.reader != nil {
+			if err := s.reader.Close(); err != nil {
+				return err
+			}
+			s.reader = nil
+		}
+
+		if err := os.Remove(head.path); err != nil {
+			return err
+		}
+
+		s.totalBytes -= head.size
+		s.segments = s.segments[1:]
+		s.readOffset = 0
+		s.readCount = 0
+	}
+
+	return nil
+}
+
+// reset reclaims the disk space of a fully drained spool by truncating the
+// remaining segment instead of waiting for it to rotate.
+func (s *spool) reset() error {
+	if err := s.advance(); err != nil {
+		return err
+	}
+
+	if s.reader != nil {
+		if err := s.reader.Close(); err != nil {
+			return err
+		}
+		s.reader = nil
+	}
+
+	if err := s.writer.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := s.writer.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	tail := s.segments[0]
+	tail.size = 0
+	tail.records = 0
+	s.totalBytes = 0
+	s.readOffset = 0
+	s.readCount = 0
+
+	return nil
+}
+
+func (s *spool) rotate() error {
+	if err := s.writer.Sync(); err != nil {
+		return err
+	}
+	if err := s.writer.Close(); err != nil {
+		return err
+	}
+
+	next := s.segments[len(s.segments)-1].seq + 1
+	seg := &spoolSegment{seq: next, path: s.segmentPath(next)}
+
+	f, err := os.OpenFile(seg.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	s.writer = f
+	s.segments = append(s.segments, seg)
+
+	return nil
+}
+
+// load rebuilds the segment index from disk. A torn frame at the end of the
+// last segment, left by a crash mid-write, is truncated away. Anywhere else a
+// corrupt frame means the records after it in its segment cannot be framed
+// and are lost, which is reported.
+func (s *spool) load() error {
+	entries, err := os.ReadDir(s.config.Dir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, spoolSegmentExt) {
+			continue
+		}
+
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, spoolSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		s.segments = append(s.segments, &spoolSegment{seq: seq, path: filepath.Join(s.config.Dir, name)})
+	}
+
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].seq < s.segments[j].seq })
+
+	for i, seg := range s.segments {
+		size, records, err := scanSpoolSegment(seg.path)
+		if err != nil {
+			return err
+		}
+
+		if i < len(s.segments)-1 {
+			if info, err := os.Stat(seg.path); err == nil && info.Size() > size {
+				spoolCorruptSegmentsTotal.Inc()
+				s.logger.Error("AMQP spool segment is corrupt, dropping the records after the corruption",
+					logger.Field{Key: "segment", Value: seg.path},
+					logger.Field{Key: "offset", Value: size},
+					logger.Field{Key: "dropped_bytes", Value: info.Size() - size},
+					logger.Field{Key: "recovered_records", Value: records},
+				)
+			}
+		}
+
+		seg.size = size
+		seg.records = records
+		s.totalBytes += size
+		s.depth += records
+	}
+
+	if len(s.segments) == 0 {
+		s.segments = append(s.segments, &spoolSegment{seq: 1, path: s.segmentPath(1)})
+	}
+
+	tail := s.segments[len(s.segments)-1]
+	f, err := os.OpenFile(tail.path, os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(tail.size); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if _, err := f.Seek(tail.size, io.SeekStart); err != nil {
+		_ = f.Close()
+		return err
+	}
+	s.writer = f
+
+	if s.depth > 0 {
+		s.logger.Info("AMQP spool recovered pending messages", logger.Field{Key: "depth", Value: s.depth})
+	}
+
+	return nil
+}
+
+func (s *spool) syncLoop() {
+	t := time.NewTicker(s.config.FsyncInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-t.C:
+			s.mu.Lock()
+			if s.dirty {
+				if err := s.writer.Sync(); err != nil {
+					s.logger.Error("AMQP spool fsync failed", logger.Field{Key: "error", Value: err.Error()})
+				}
+				s.dirty = false
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *spool) segmentPath(seq uint64) string {
+	return filepath.Join(s.config.Dir, fmt.Sprintf("%0*d%s", spoolSegmentWidth, seq, spoolSegmentExt))
+}
+
+func readSpoolFrame(r io.ReaderAt, offset int64) ([]byte, int64, error) {
+	header := make([]byte, spoolFrameHeader)
+	if _, err := r.ReadAt(header, offset); err != nil {
+		return nil, 0, err
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint32(header[0:4]))
+	if _, err := r.ReadAt(payload, offset+spoolFrameHeader); err != nil {
+		return nil, 0, err
+	}
+
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, 0, fmt.Errorf("amqp spool record at offset %d is corrupt", offset)
+	}
+
+	return payload, int64(spoolFrameHeader + len(payload)), nil
+}
+
+// scanSpoolSegment returns the length of the valid prefix of a segment and the
+// number of records in it.
+func scanSpoolSegment(path string) (int64, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	header := make([]byte, spoolFrameHeader)
+
+	var size int64
+	records := 0
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return size, records, nil
+		}
+
+		payload := make([]byte, binary.BigEndian.Uint32(header[0:4]))
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return size, records, nil
+		}
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+			return size, records, nil
+		}
+
+		size += int64(spoolFrameHeader + len(payload))
+		records++
+	}
+}
@@ -0,0 +1,152 @@
+package rabbitmq
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	amqplib "github.com/rabbitmq/amqp091-go"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/config"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+)
+
+func newTestSpool(t *testing.T, dir string) *spool {
+	t.Helper()
+
+	s, err := newSpool(&config.AMQPSpool{
+		Enabled:      true,
+		Dir:          dir,
+		SegmentBytes: 2048,
+		FsyncPolicy:  SpoolFsyncNever,
+	}, logger.NewZerologLogger("error", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func appendTestRecords(t *testing.T, s *spool, n int) {
+	t.Helper()
+
+	for i := range n {
+		err := s.Append(&spoolRecord{
+			RoutingKey: "test",
+			Publishing: amqplib.Publishing{MessageId: fmt.Sprint(i), Body: []byte(`{"pattern":"test"}`)},
+			EnqueuedAt: time.Now(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSpoolReportsCorruptSegment(t *testing.T) {
+	dir := t.TempDir()
+
+	s := newTestSpool(t, dir)
+	appendTestRecords(t, s, 20)
+	if len(s.segments) < 2 || s.segments[0].records < 2 {
+		t.Fatalf("got %d segments, want several", len(s.segments))
+	}
+	first := s.segments[0]
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Flip a byte of the second record of the first segment.
+	data, err := os.ReadFile(first.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := int(first.size) / first.records
+	data[frame+spoolFrameHeader+1] ^= 0xff
+	if err := os.WriteFile(first.path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	before := testutil.ToFloat64(spoolCorruptSegmentsTotal)
+
+	s = newTestSpool(t, dir)
+	defer s.Close()
+
+	if got := testutil.ToFloat64(spoolCorruptSegmentsTotal) - before; got != 1 {
+		t.Errorf("corrupt segments counted %v times, want 1", got)
+	}
+	if want := 20 - first.records + 1; s.Depth() != want {
+		t.Errorf("depth %d, want %d", s.Depth(), want)
+	}
+}
+
+func TestSpoolTruncatesTornTail(t *testing.T) {
+	dir := t.TempDir()
+
+	s := newTestSpool(t, dir)
+	appendTestRecords(t, s, 2)
+	tail := s.segments[len(s.segments)-1]
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.OpenFile(tail.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte{0, 0, 1}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	before := testutil.ToFloat64(spoolCorruptSegmentsTotal)
+
+	s = newTestSpool(t, dir)
+	defer s.Close()
+
+	if got := testutil.ToFloat64(spoolCorruptSegmentsTotal) - before; got != 0 {
+		t.Errorf("torn tail counted as corruption %v times", got)
+	}
+	if s.Depth() != 2 {
+		t.Errorf("depth %d, want 2", s.Depth())
+	}
+}
+
+func TestRegisterSpoolCollectorTwice(t *testing.T) {
+	a := newTestSpool(t, t.TempDir())
+	defer a.Close()
+	b := newTestSpool(t, t.TempDir())
+	defer b.Close()
+
+	registerSpoolCollector(a)
+	registerSpoolCollector(b)
+}
+
+func TestHealthLogsSpoolingOnce(t *testing.T) {
+	s := newTestSpool(t, t.TempDir())
+	t.Cleanup(func() { _ = s.Close() })
+
+	var out bytes.Buffer
+	b := &rabbitmq{
+		config: &config.AMQP{Spool: &config.AMQPSpool{Enabled: true}},
+		logger: logger.NewZerologLogger("warn", &out),
+		spool:  s,
+	}
+	// The level is global, so restore the one the other tests log at.
+	t.Cleanup(func() { logger.NewZerologLogger("error", nil) })
+
+	for range 3 {
+		if err := b.Health(); err != nil {
+			t.Fatalf("health while spooling: %v", err)
+		}
+	}
+
+	if n := strings.Count(out.String(), "publishing to spool"); n != 1 {
+		t.Errorf("logged spooling %d times over 3 probes, want once", n)
+	}
+	if got := testutil.ToFloat64(spooling); got != 1 {
+		t.Errorf("amqp_spooling = %v, want 1", got)
+	}
+}
@@ -0,0 +1,108 @@
+package rabbitmq
+
+import (
+	"sync"
+)
+
+type ConnectionState int
+
+const (
+	StateDisconnected ConnectionState = iota
+	StateConnecting
+	StateConnected
+	StateBlocked
+	StateFailed
+	StateClosed
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateDisconnected:
+		return "disconnected"
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateBlocked:
+		return "blocked"
+	case StateFailed:
+		return "failed"
+	case StateClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// stateNotifier fans connection state transitions out to subscribers. Each
+// subscriber channel holds only the latest state, so a slow reader skips
+// intermediate transitions instead of blocking the connection loop.
+type stateNotifier struct {
+	mu          sync.Mutex
+	current     ConnectionState
+	subscribers map[chan ConnectionState]struct{}
+}
+
+func newStateNotifier() *stateNotifier {
+	return &stateNotifier{
+		current:     StateDisconnected,
+		subscribers: map[chan ConnectionState]struct{}{},
+	}
+}
+
+func (n *stateNotifier) Current() ConnectionState {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.current
+}
+
+func (n *stateNotifier) Set(state ConnectionState) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.current == state {
+		return
+	}
+	n.current = state
+
+	for ch := range n.subscribers {
+		deliverLatest(ch, state)
+	}
+}
+
+// Subscribe returns a channel that immediately receives the current state and
+// then every transition, plus a function that stops the subscription.
+func (n *stateNotifier) Subscribe() (<-chan ConnectionState, func()) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	ch := make(chan ConnectionState, 1)
+	ch <- n.current
+	n.subscribers[ch] = struct{}{}
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			n.mu.Lock()
+			defer n.mu.Unlock()
+
+			delete(n.subscribers, ch)
+			close(ch)
+		})
+	}
+}
+
+func deliverLatest(ch chan ConnectionState, state ConnectionState) {
+	for {
+		select {
+		case ch <- state:
+			return
+		default:
+			select {
+			case <-ch:
+			default:
+			}
+		}
+	}
+}
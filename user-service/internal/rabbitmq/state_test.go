@@ -0,0 +1,83 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func receiveState(t *testing.T, ch <-chan ConnectionState) ConnectionState {
+	t.Helper()
+
+	select {
+	case s, ok := <-ch:
+		if !ok {
+			t.Fatal("subscription closed")
+		}
+		return s
+	case <-time.After(time.Second):
+		t.Fatal("no state received")
+	}
+
+	return 0
+}
+
+func TestStateNotifierTransitions(t *testing.T) {
+	n := newStateNotifier()
+
+	ch, stop := n.Subscribe()
+	if s := receiveState(t, ch); s != StateDisconnected {
+		t.Fatalf("initial state %s, want disconnected", s)
+	}
+
+	n.Set(StateConnecting)
+	if s := receiveState(t, ch); s != StateConnecting {
+		t.Errorf("got %s, want connecting", s)
+	}
+
+	// Setting the current state again is not a transition.
+	n.Set(StateConnecting)
+	select {
+	case s := <-ch:
+		t.Errorf("got %s without a transition", s)
+	default:
+	}
+
+	// A slow subscriber only sees the latest state.
+	n.Set(StateConnected)
+	n.Set(StateBlocked)
+	n.Set(StateConnected)
+	if s := receiveState(t, ch); s != StateConnected {
+		t.Errorf("got %s, want connected", s)
+	}
+	if n.Current() != StateConnected {
+		t.Errorf("current state %s, want connected", n.Current())
+	}
+
+	stop()
+	stop()
+	if _, ok := <-ch; ok {
+		t.Error("subscription still open after stopping it")
+	}
+	n.Set(StateClosed)
+}
+
+func TestMaintainConnectionReleasesResourcesWhenRetriesRunOut(t *testing.T) {
+	b := newUnreachableBroker(t)
+	pool, _ := newTestPool(t, 1)
+	b.pool = pool
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// No retries are configured, so the first failed attempt is the last.
+	b.MaintainConnection(ctx)
+
+	if s := b.state.Current(); s != StateFailed {
+		t.Errorf("state %s, want failed", s)
+	}
+	if _, err := pool.Get(context.Background()); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("got error %v from the pool, want it closed", err)
+	}
+}
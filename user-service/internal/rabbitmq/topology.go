@@ -0,0 +1,631 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	amqplib "github.com/rabbitmq/amqp091-go"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/config"
+)
+
+// Topology is the declarative description of the exchanges, queues, bindings
+// and policies the service relies on. It is applied at every connect, and
+// since declarations are idempotent, applying it again changes nothing.
+type Topology struct {
+	Exchanges []ExchangeSpec `yaml:"exchanges"`
+	Queues    []QueueSpec    `yaml:"queues"`
+	Bindings  []BindingSpec  `yaml:"bindings"`
+	Policies  []PolicySpec   `yaml:"policies"`
+}
+
+type ExchangeSpec struct {
+	Name       string         `yaml:"name"`
+	Type       string         `yaml:"type"`
+	Durable    bool           `yaml:"durable"`
+	AutoDelete bool           `yaml:"autoDelete"`
+	Internal   bool           `yaml:"internal"`
+	Arguments  map[string]any `yaml:"arguments"`
+}
+
+// QueueSpec declares a queue. Type defaults to AMQP_QUEUE_TYPE; quorum and
+// stream queues are always durable. The dead-letter, TTL, length and
+// priority settings are shorthands for the matching x- arguments.
+type QueueSpec struct {
+	Name                 string         `yaml:"name"`
+	Type                 string         `yaml:"type"`
+	Durable              bool           `yaml:"durable"`
+	AutoDelete           bool           `yaml:"autoDelete"`
+	Exclusive            bool           `yaml:"exclusive"`
+	DeadLetterExchange   string         `yaml:"deadLetterExchange"`
+	DeadLetterRoutingKey string         `yaml:"deadLetterRoutingKey"`
+	MessageTTL           time.Duration  `yaml:"messageTTL"`
+	MaxLength            int            `yaml:"maxLength"`
+	MaxPriority          uint8          `yaml:"maxPriority"`
+	Arguments            map[string]any `yaml:"arguments"`
+}
+
+type BindingSpec struct {
+	Exchange   string         `yaml:"exchange"`
+	Queue      string         `yaml:"queue"`
+	RoutingKey string         `yaml:"routingKey"`
+	Arguments  map[string]any `yaml:"arguments"`
+}
+
+// PolicySpec is a broker policy. Policies can change queue behaviour, such
+// as dead-lettering, without redeclaring queues other clients also declare,
+// and are applied through the management API.
+type PolicySpec struct {
+	Name       string         `yaml:"name"`
+	Pattern    string         `yaml:"pattern"`
+	ApplyTo    string         `yaml:"applyTo"`
+	Priority   int            `yaml:"priority"`
+	Definition map[string]any `yaml:"definition"`
+}
+
+// managementExchange, managementQueue and managementBinding are the parts of
+// the objects the management API returns that a topology declares.
+type managementExchange struct {
+	Type       string         `json:"type"`
+	Durable    bool           `json:"durable"`
+	AutoDelete bool           `json:"auto_delete"`
+	Internal   bool           `json:"internal"`
+	Arguments  map[string]any `json:"arguments"`
+}
+
+type managementQueue struct {
+	Durable    bool           `json:"durable"`
+	AutoDelete bool           `json:"auto_delete"`
+	Exclusive  bool           `json:"exclusive"`
+	Arguments  map[string]any `json:"arguments"`
+}
+
+type managementBinding struct {
+	RoutingKey string         `json:"routing_key"`
+	Arguments  map[string]any `json:"arguments"`
+}
+
+// managementPolicy is a policy as the management API reads and writes it.
+type managementPolicy struct {
+	Pattern    string         `json:"pattern"`
+	ApplyTo    string         `json:"apply-to"`
+	Priority   int            `json:"priority"`
+	Definition map[string]any `json:"definition"`
+}
+
+var ErrInvalidTopology = errors.New("amqp topology is invalid")
+
+// LoadTopology reads and validates a YAML topology file.
+func LoadTopology(path string) (*Topology, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var t Topology
+	if err := yaml.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidTopology, err)
+	}
+
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+func (t *Topology) validate() error {
+	exchanges := map[string]bool{"": true}
+	for _, e := range t.Exchanges {
+		if e.Name == "" {
+			return fmt.Errorf("%w: exchange without a name", ErrInvalidTopology)
+		}
+		switch e.Type {
+		case ExchangeDirect, ExchangeFanout, ExchangeTopic, amqplib.ExchangeHeaders, ExchangeDelayed:
+		default:
+			return fmt.Errorf("%w: exchange %s has unknown type %q", ErrInvalidTopology, e.Name, e.Type)
+		}
+		if err := toTable(e.Arguments).Validate(); err != nil {
+			return fmt.Errorf("%w: exchange %s arguments: %s", ErrInvalidTopology, e.Name, err)
+		}
+		exchanges[e.Name] = true
+	}
+
+	queues := map[string]bool{}
+	for _, q := range t.Queues {
+		if q.Name == "" {
+			return fmt.Errorf("%w: queue without a name", ErrInvalidTopology)
+		}
+		if err := toTable(q.Arguments).Validate(); err != nil {
+			return fmt.Errorf("%w: queue %s arguments: %s", ErrInvalidTopology, q.Name, err)
+		}
+		queues[q.Name] = true
+	}
+
+	for _, b := range t.Bindings {
+		if !exchanges[b.Exchange] || !queues[b.Queue] {
+			return fmt.Errorf("%w: binding of %s to %s refers to an undeclared exchange or queue", ErrInvalidTopology, b.Queue, b.Exchange)
+		}
+		if err := toTable(b.Arguments).Validate(); err != nil {
+			return fmt.Errorf("%w: binding of %s to %s arguments: %s", ErrInvalidTopology, b.Queue, b.Exchange, err)
+		}
+	}
+
+	for _, p := range t.Policies {
+		if p.Name == "" || p.Pattern == "" {
+			return fmt.Errorf("%w: policy without a name or pattern", ErrInvalidTopology)
+		}
+	}
+
+	return nil
+}
+
+// queueSpec returns the declared spec for queue, or a default one of the
+// configured queue type for queues the topology does not mention.
+func (t *Topology) queueSpec(queue string) QueueSpec {
+	if t != nil {
+		for _, q := range t.Queues {
+			if q.Name == queue {
+				return q
+			}
+		}
+	}
+
+	return QueueSpec{Name: queue}
+}
+
+func (q QueueSpec) declare(channel *amqplib.Channel, cfg *config.AMQP) (amqplib.Queue, error) {
+	durable, args := q.arguments(cfg)
+
+	return channel.QueueDeclare(q.Name, durable, q.AutoDelete, q.Exclusive, false, args)
+}
+
+func (q QueueSpec) arguments(cfg *config.AMQP) (bool, amqplib.Table) {
+	args := toTable(q.Arguments)
+
+	queueType := q.Type
+	if queueType == "" {
+		queueType = cfg.QueueType
+	}
+
+	durable := q.Durable
+	if queueType == amqplib.QueueTypeQuorum || queueType == amqplib.QueueTypeStream {
+		durable = true
+		args[amqplib.QueueTypeArg] = queueType
+	}
+
+	if q.DeadLetterExchange != "" {
+		args["x-dead-letter-exchange"] = q.DeadLetterExchange
+	}
+	if q.DeadLetterRoutingKey != "" {
+		args["x-dead-letter-routing-key"] = q.DeadLetterRoutingKey
+	}
+	if q.MessageTTL > 0 {
+		args["x-message-ttl"] = q.MessageTTL.Milliseconds()
+	}
+	if q.MaxLength > 0 {
+		args["x-max-length"] = q.MaxLength
+	}
+	if q.MaxPriority > 0 {
+		args["x-max-priority"] = q.MaxPriority
+	}
+
+	if len(args) == 0 {
+		return durable, nil
+	}
+
+	return durable, args
+}
+
+// Apply declares every exchange, queue and binding on conn.
+func (t *Topology) Apply(conn *amqplib.Connection, cfg *config.AMQP) error {
+	channel, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+
+	for _, e := range t.Exchanges {
+		if err := channel.ExchangeDeclare(e.Name, e.Type, e.Durable, e.AutoDelete, e.Internal, false, toTable(e.Arguments)); err != nil {
+			return fmt.Errorf("declare exchange %s: %w", e.Name, err)
+		}
+	}
+
+	for _, q := range t.Queues {
+		if _, err := q.declare(channel, cfg); err != nil {
+			return fmt.Errorf("declare queue %s: %w", q.Name, err)
+		}
+	}
+
+	for _, b := range t.Bindings {
+		if err := channel.QueueBind(b.Queue, b.RoutingKey, b.Exchange, false, toTable(b.Arguments)); err != nil {
+			return fmt.Errorf("bind queue %s to %s: %w", b.Queue, b.Exchange, err)
+		}
+	}
+
+	return nil
+}
+
+// toTable converts arguments decoded from YAML into the types AMQP tables
+// accept: the decoder yields unsigned integers and plain maps, which the
+// client rejects.
+func toTable(args map[string]any) amqplib.Table {
+	table := amqplib.Table{}
+	for k, v := range args {
+		table[k] = normalizeArgument(v)
+	}
+
+	return table
+}
+
+func normalizeArgument(v any) any {
+	switch v := v.(type) {
+	case uint:
+		return unsignedArgument(uint64(v))
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint64:
+		return unsignedArgument(v)
+	case map[string]any:
+		return toTable(v)
+	case []any:
+		values := make([]any, len(v))
+		for i, elem := range v {
+			values[i] = normalizeArgument(elem)
+		}
+		return values
+	}
+
+	return v
+}
+
+// unsignedArgument keeps values beyond int64, which Validate then reports.
+func unsignedArgument(v uint64) any {
+	if v > math.MaxInt64 {
+		return v
+	}
+
+	return int64(v)
+}
+
+// ApplyPolicies creates or updates the policies through the management API.
+func (t *Topology) ApplyPolicies(ctx context.Context, cfg *config.AMQP) error {
+	for _, p := range t.Policies {
+		if err := management(ctx, cfg, http.MethodPut, policyPath(p.Name), p.managementPolicy(), nil); err != nil {
+			return fmt.Errorf("apply policy %s: %w", p.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (p PolicySpec) managementPolicy() *managementPolicy {
+	applyTo := p.ApplyTo
+	if applyTo == "" {
+		applyTo = "queues"
+	}
+
+	return &managementPolicy{
+		Pattern:    p.Pattern,
+		ApplyTo:    applyTo,
+		Priority:   p.Priority,
+		Definition: p.Definition,
+	}
+}
+
+func policyPath(name string) string {
+	return "/policies/{vhost}/" + url.PathEscape(name)
+}
+
+// TopologyChange is one line of a dry run: what applying the topology would
+// do to an object.
+type TopologyChange struct {
+	Action string
+	Kind   string
+	Name   string
+	Detail string
+}
+
+const (
+	TopologyCreate    = "+"
+	TopologyUpdate    = "~"
+	TopologyUnchanged = "="
+	TopologyUnknown   = "?"
+)
+
+func (c TopologyChange) String() string {
+	line := fmt.Sprintf("%s %s %s", c.Action, c.Kind, c.Name)
+	if c.Detail != "" {
+		line += " (" + c.Detail + ")"
+	}
+
+	return line
+}
+
+// Diff compares the topology with the live broker without changing it. With
+// the management API configured, the type, flags and arguments of exchanges
+// and queues, and the bindings, are compared with the live definitions;
+// exchanges and queues that drifted cannot be redeclared and have to be
+// deleted first. Without it, exchanges and queues are checked with passive
+// declares, which only tell whether they exist, and bindings cannot be
+// inspected at all.
+func (t *Topology) Diff(ctx context.Context, conn *amqplib.Connection, cfg *config.AMQP) ([]TopologyChange, error) {
+	if cfg.ManagementURL == "" {
+		changes, err := t.diffPassive(conn, cfg)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range t.Policies {
+			changes = append(changes, t.diffPolicy(ctx, cfg, p))
+		}
+		return changes, nil
+	}
+
+	var changes []TopologyChange
+
+	for _, e := range t.Exchanges {
+		changes = append(changes, diffExchange(ctx, cfg, e))
+	}
+	for _, q := range t.Queues {
+		changes = append(changes, diffQueue(ctx, cfg, q))
+	}
+	for _, b := range t.Bindings {
+		changes = append(changes, diffBinding(ctx, cfg, b))
+	}
+	for _, p := range t.Policies {
+		changes = append(changes, t.diffPolicy(ctx, cfg, p))
+	}
+
+	return changes, nil
+}
+
+func (t *Topology) diffPassive(conn *amqplib.Connection, cfg *config.AMQP) ([]TopologyChange, error) {
+	var changes []TopologyChange
+
+	for _, e := range t.Exchanges {
+		exists, err := passiveDeclare(conn, func(ch *amqplib.Channel) error {
+			return ch.ExchangeDeclarePassive(e.Name, e.Type, e.Durable, e.AutoDelete, e.Internal, false, nil)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, existenceChange(exists, "exchange", e.Name, e.Type))
+	}
+
+	for _, q := range t.Queues {
+		exists, err := passiveDeclare(conn, func(ch *amqplib.Channel) error {
+			_, err := ch.QueueDeclarePassive(q.Name, false, false, false, false, nil)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		durable, args := q.arguments(cfg)
+		changes = append(changes, existenceChange(exists, "queue", q.Name, fmt.Sprintf("durable=%t arguments=%v", durable, args)))
+	}
+
+	for _, b := range t.Bindings {
+		changes = append(changes, TopologyChange{
+			Action: TopologyUnknown,
+			Kind:   "binding",
+			Name:   bindingName(b),
+			Detail: ErrManagementNotConfigured.Error(),
+		})
+	}
+
+	return changes, nil
+}
+
+func diffExchange(ctx context.Context, cfg *config.AMQP, e ExchangeSpec) TopologyChange {
+	change := TopologyChange{Kind: "exchange", Name: e.Name}
+
+	var live managementExchange
+	err := management(ctx, cfg, http.MethodGet, "/exchanges/{vhost}/"+url.PathEscape(e.Name), nil, &live)
+	switch {
+	case errors.Is(err, ErrManagementNotFound):
+		change.Action = TopologyCreate
+		change.Detail = e.Type
+	case err != nil:
+		change.Action = TopologyUnknown
+		change.Detail = err.Error()
+	default:
+		change.drift(
+			fieldDrift("type", live.Type, e.Type),
+			fieldDrift("durable", live.Durable, e.Durable),
+			fieldDrift("autoDelete", live.AutoDelete, e.AutoDelete),
+			fieldDrift("internal", live.Internal, e.Internal),
+			fieldDrift("arguments", live.Arguments, toTable(e.Arguments)),
+		)
+	}
+
+	return change
+}
+
+func diffQueue(ctx context.Context, cfg *config.AMQP, q QueueSpec) TopologyChange {
+	change := TopologyChange{Kind: "queue", Name: q.Name}
+	durable, args := q.arguments(cfg)
+
+	var live managementQueue
+	err := management(ctx, cfg, http.MethodGet, "/queues/{vhost}/"+url.PathEscape(q.Name), nil, &live)
+	switch {
+	case errors.Is(err, ErrManagementNotFound):
+		change.Action = TopologyCreate
+		change.Detail = fmt.Sprintf("durable=%t arguments=%v", durable, args)
+	case err != nil:
+		change.Action = TopologyUnknown
+		change.Detail = err.Error()
+	default:
+		change.drift(
+			fieldDrift("durable", live.Durable, durable),
+			fieldDrift("autoDelete", live.AutoDelete, q.AutoDelete),
+			fieldDrift("exclusive", live.Exclusive, q.Exclusive),
+			fieldDrift("arguments", live.Arguments, args),
+		)
+	}
+
+	return change
+}
+
+func diffBinding(ctx context.Context, cfg *config.AMQP, b BindingSpec) TopologyChange {
+	change := TopologyChange{Kind: "binding", Name: bindingName(b)}
+
+	// The default exchange is called amq.default in the management API.
+	exchange := b.Exchange
+	if exchange == "" {
+		exchange = "amq.default"
+	}
+
+	var live []managementBinding
+	err := management(ctx, cfg, http.MethodGet, "/bindings/{vhost}/e/"+url.PathEscape(exchange)+"/q/"+url.PathEscape(b.Queue), nil, &live)
+	switch {
+	case errors.Is(err, ErrManagementNotFound):
+		change.Action = TopologyCreate
+		change.Detail = fmt.Sprintf("routing key %q", b.RoutingKey)
+		return change
+	case err != nil:
+		change.Action = TopologyUnknown
+		change.Detail = err.Error()
+		return change
+	}
+
+	for _, l := range live {
+		if l.RoutingKey == b.RoutingKey && fieldDrift("arguments", l.Arguments, toTable(b.Arguments)) == "" {
+			change.Action = TopologyUnchanged
+			return change
+		}
+	}
+
+	change.Action = TopologyCreate
+	change.Detail = fmt.Sprintf("routing key %q", b.RoutingKey)
+
+	return change
+}
+
+func bindingName(b BindingSpec) string {
+	return fmt.Sprintf("%s -> %s", b.Exchange, b.Queue)
+}
+
+// drift marks the change as an update listing the differences, or as
+// unchanged when there are none.
+func (c *TopologyChange) drift(diffs ...string) {
+	var details []string
+	for _, d := range diffs {
+		if d != "" {
+			details = append(details, d)
+		}
+	}
+
+	if len(details) == 0 {
+		c.Action = TopologyUnchanged
+		return
+	}
+
+	c.Action = TopologyUpdate
+	c.Detail = strings.Join(details, ", ")
+}
+
+// fieldDrift describes how a live value differs from the wanted one, or
+// returns "" when they are equal. Empty and missing argument tables are
+// equal.
+func fieldDrift(name string, live, want any) string {
+	if isEmptyTable(live) && isEmptyTable(want) {
+		return ""
+	}
+	if sameJSON(live, want) {
+		return ""
+	}
+
+	return fmt.Sprintf("%s %v -> %v", name, live, want)
+}
+
+func isEmptyTable(v any) bool {
+	switch v := v.(type) {
+	case map[string]any:
+		return len(v) == 0
+	case amqplib.Table:
+		return len(v) == 0
+	}
+
+	return false
+}
+
+func (t *Topology) diffPolicy(ctx context.Context, cfg *config.AMQP, p PolicySpec) TopologyChange {
+	change := TopologyChange{Kind: "policy", Name: p.Name}
+	want := p.managementPolicy()
+
+	var live managementPolicy
+	err := management(ctx, cfg, http.MethodGet, policyPath(p.Name), nil, &live)
+	switch {
+	case errors.Is(err, ErrManagementNotFound):
+		change.Action = TopologyCreate
+		change.Detail = "pattern " + p.Pattern
+	case err != nil:
+		change.Action = TopologyUnknown
+		change.Detail = err.Error()
+	case live.Pattern == want.Pattern && live.ApplyTo == want.ApplyTo && live.Priority == want.Priority && sameJSON(live.Definition, want.Definition):
+		change.Action = TopologyUnchanged
+	default:
+		change.Action = TopologyUpdate
+		change.Detail = fmt.Sprintf("definition %v -> %v", live.Definition, want.Definition)
+	}
+
+	return change
+}
+
+// passiveDeclare runs a passive declaration on a fresh channel, since a
+// failed one closes the channel, and reports whether the object exists.
+func passiveDeclare(conn *amqplib.Connection, declare func(*amqplib.Channel) error) (bool, error) {
+	channel, err := conn.Channel()
+	if err != nil {
+		return false, err
+	}
+	defer channel.Close()
+
+	err = declare(channel)
+
+	var amqpErr *amqplib.Error
+	if errors.As(err, &amqpErr) && amqpErr.Code == amqplib.NotFound {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func existenceChange(exists bool, kind, name, detail string) TopologyChange {
+	if exists {
+		return TopologyChange{Action: TopologyUnchanged, Kind: kind, Name: name}
+	}
+
+	return TopologyChange{Action: TopologyCreate, Kind: kind, Name: name, Detail: detail}
+}
+
+// sameJSON compares values after a JSON round trip, so numbers decoded from
+// the API compare equal to the ones written in YAML.
+func sameJSON(a, b any) bool {
+	var na, nb any
+
+	ra, errA := json.Marshal(a)
+	rb, errB := json.Marshal(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+
+	if json.Unmarshal(ra, &na) != nil || json.Unmarshal(rb, &nb) != nil {
+		return false
+	}
+
+	return reflect.DeepEqual(na, nb)
+}
@@ -0,0 +1,78 @@
+package rabbitmq
+
+import (
+	"context"
+
+	amqplib "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/rabbitmq"
+
+// headerCarrier adapts AMQP headers to the OpenTelemetry propagation API so
+// traceparent, tracestate and baggage travel as message headers.
+type headerCarrier amqplib.Table
+
+func (c headerCarrier) Get(key string) string {
+	v, _ := c[key].(string)
+	return v
+}
+
+func (c headerCarrier) Set(key string, value string) {
+	c[key] = value
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+
+	return keys
+}
+
+var _ propagation.TextMapCarrier = headerCarrier{}
+
+func startPublishSpan(ctx context.Context, queue string, message *MessageType) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, message.Pattern+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "rabbitmq"),
+			attribute.String("messaging.destination.name", queue),
+			attribute.String("messaging.message.id", message.MessageID),
+			attribute.String("messaging.message.conversation_id", message.CorrelationID),
+		),
+	)
+}
+
+// startConsumeSpan continues the trace of the publisher that sent d.
+func startConsumeSpan(ctx context.Context, d *Delivery) (context.Context, trace.Span) {
+	if d.Headers != nil {
+		ctx = otel.GetTextMapPropagator().Extract(ctx, headerCarrier(d.Headers))
+	}
+
+	return otel.Tracer(tracerName).Start(ctx, d.Pattern()+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "rabbitmq"),
+			attribute.String("messaging.source.name", d.RoutingKey),
+			attribute.String("messaging.message.id", d.MessageId),
+			attribute.String("messaging.message.conversation_id", d.CorrelationId),
+		),
+	)
+}
+
+func injectTraceContext(ctx context.Context, headers amqplib.Table) {
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(headers))
+}
+
+func traceID(ctx context.Context) string {
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		return sc.TraceID().String()
+	}
+
+	return ""
+}
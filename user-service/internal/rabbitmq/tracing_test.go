@@ -0,0 +1,61 @@
+package rabbitmq
+
+import (
+	"context"
+	"testing"
+
+	amqplib "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTraceContextCrossesAMQPHeaders(t *testing.T) {
+	tp := sdktrace.NewTracerProvider()
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+		_ = tp.Shutdown(context.Background())
+	})
+
+	member, err := baggage.NewMember("tenant", "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bag, err := baggage.New(member)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := baggage.ContextWithBaggage(context.Background(), bag)
+	ctx, publish := startPublishSpan(ctx, "notification-service", &MessageType{Pattern: "user.created", MessageID: "42"})
+	defer publish.End()
+
+	headers := amqplib.Table{}
+	injectTraceContext(ctx, headers)
+	if _, ok := headers["traceparent"].(string); !ok {
+		t.Fatalf("headers %v carry no traceparent", headers)
+	}
+
+	d := &Delivery{Delivery: amqplib.Delivery{Type: "user.created", Headers: headers}}
+	consumeCtx, consume := startConsumeSpan(context.Background(), d)
+	defer consume.End()
+
+	if got, want := traceID(consumeCtx), publish.SpanContext().TraceID().String(); got != want {
+		t.Errorf("consumer trace id %q, want the publisher's %q", got, want)
+	}
+	if parent := consume.(sdktrace.ReadOnlySpan).Parent(); parent.SpanID() != publish.SpanContext().SpanID() {
+		t.Errorf("consumer span parent %s, want the publish span %s", parent.SpanID(), publish.SpanContext().SpanID())
+	}
+	if kind := consume.(sdktrace.ReadOnlySpan).SpanKind(); kind != trace.SpanKindConsumer {
+		t.Errorf("consumer span kind %s", kind)
+	}
+	if got := baggage.FromContext(consumeCtx).Member("tenant").Value(); got != "acme" {
+		t.Errorf("baggage tenant %q, want acme", got)
+	}
+}
@@ -2,9 +2,21 @@ package service
 
 import (
 	"context"
+	"errors"
 	"sync/atomic"
 )
 
+const (
+	HealthStatusReady    = "ready"
+	HealthStatusDegraded = "degraded"
+	HealthStatusUnready  = "unready"
+)
+
+// ErrDegraded marks a failed dependency check that impairs the service
+// without stopping it from serving. The service is reported as degraded but
+// stays ready.
+var ErrDegraded = errors.New("degraded")
+
 type HealthService interface {
 	Check(ctx context.Context) HealthStatus
 	SetReady(ready bool)
@@ -41,16 +53,20 @@ func (h *healthService) SetReady(ready bool) {
 func (h *healthService) Check(ctx context.Context) HealthStatus {
 	if !h.ready.Load() {
 		return HealthStatus{
-			Status:  "unready",
+			Status:  HealthStatusUnready,
 			Details: map[string]string{"service": "shutting down"},
 		}
 	}
 
-	status := HealthStatus{Status: "ready", Details: map[string]string{}}
+	status := HealthStatus{Status: HealthStatusReady, Details: map[string]string{}}
 
 	for name, fn := range h.checks {
 		if err := fn(ctx); err != nil {
-			status.Status = "unready"
+			if !errors.Is(err, ErrDegraded) {
+				status.Status = HealthStatusUnready
+			} else if status.Status == HealthStatusReady {
+				status.Status = HealthStatusDegraded
+			}
 			status.Details[name] = err.Error()
 		} else {
 			status.Details[name] = "ok"
@@ -0,0 +1,188 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/rabbitmq"
+)
+
+const (
+	DefaultQuarantinePerPage = 20
+	MaxQuarantinePerPage     = 100
+)
+
+var ErrInvalidQuarantineQuery = errors.New("invalid quarantine query")
+
+type QuarantineService interface {
+	List(ctx context.Context, query *QuarantineQuery) (*QuarantinePage, error)
+	Get(ctx context.Context, id string) (*rabbitmq.QuarantinedMessage, error)
+	Requeue(ctx context.Context, id string, edit *QuarantineEdit) error
+	RequeueAll(ctx context.Context, query *QuarantineQuery) (int, error)
+	Delete(ctx context.Context, id string) error
+	Purge(ctx context.Context, query *QuarantineQuery) (int, error)
+}
+
+type quarantineService struct {
+	store    rabbitmq.QuarantineStore
+	rabbitmq rabbitmq.RabbitMQ
+	logger   logger.Logger
+}
+
+type QuarantineServiceOpts struct {
+	Store    rabbitmq.QuarantineStore
+	RabbitMQ rabbitmq.RabbitMQ
+	Logger   logger.Logger
+}
+
+// QuarantineQuery selects quarantined messages by event pattern glob and
+// queue. Page and PerPage only apply to List.
+type QuarantineQuery struct {
+	Pattern string
+	Queue   string
+	Page    int
+	PerPage int
+}
+
+type QuarantinePage struct {
+	Items   []*rabbitmq.QuarantinedMessage
+	Total   int
+	Page    int
+	PerPage int
+}
+
+// QuarantineEdit changes a message before it is requeued. Headers set to null
+// are removed.
+type QuarantineEdit struct {
+	Body    json.RawMessage `json:"body"`
+	Headers map[string]any  `json:"headers"`
+}
+
+func NewQuarantineService(opts *QuarantineServiceOpts) *quarantineService {
+	return &quarantineService{
+		store:    opts.Store,
+		rabbitmq: opts.RabbitMQ,
+		logger:   opts.Logger,
+	}
+}
+
+func (q *quarantineService) List(ctx context.Context, query *QuarantineQuery) (*QuarantinePage, error) {
+	filter, err := quarantineFilter(query)
+	if err != nil {
+		return nil, err
+	}
+
+	page, perPage := query.Page, query.PerPage
+	if page < 1 {
+		page = 1
+	}
+	if perPage < 1 {
+		perPage = DefaultQuarantinePerPage
+	}
+	perPage = min(perPage, MaxQuarantinePerPage)
+
+	filter.Offset = (page - 1) * perPage
+	filter.Limit = perPage
+
+	items, total, err := q.store.List(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	if items == nil {
+		items = []*rabbitmq.QuarantinedMessage{}
+	}
+
+	return &QuarantinePage{Items: items, Total: total, Page: page, PerPage: perPage}, nil
+}
+
+func (q *quarantineService) Get(ctx context.Context, id string) (*rabbitmq.QuarantinedMessage, error) {
+	return q.store.Get(ctx, id)
+}
+
+// Requeue publishes the message back to the queue it failed on, edited if
+// edit is not nil, and removes it from the quarantine.
+func (q *quarantineService) Requeue(ctx context.Context, id string, edit *QuarantineEdit) error {
+	msg, err := q.store.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if edit != nil {
+		var body []byte
+		if len(edit.Body) > 0 && string(edit.Body) != "null" {
+			body = edit.Body
+		}
+		msg.Edit(body, edit.Headers)
+	}
+
+	return q.requeue(ctx, msg)
+}
+
+// RequeueAll requeues every message matching query and returns how many were
+// requeued before the first failure, if any.
+func (q *quarantineService) RequeueAll(ctx context.Context, query *QuarantineQuery) (int, error) {
+	return q.each(ctx, query, q.requeue)
+}
+
+func (q *quarantineService) Delete(ctx context.Context, id string) error {
+	return q.store.Delete(ctx, id)
+}
+
+// Purge deletes every message matching query.
+func (q *quarantineService) Purge(ctx context.Context, query *QuarantineQuery) (int, error) {
+	n, err := q.each(ctx, query, func(ctx context.Context, msg *rabbitmq.QuarantinedMessage) error {
+		return q.store.Delete(ctx, msg.ID)
+	})
+	if n > 0 {
+		q.logger.Info("Quarantined messages purged", logger.Field{Key: "count", Value: n}, logger.Field{Key: "pattern", Value: query.Pattern})
+	}
+
+	return n, err
+}
+
+// requeue publishes before deleting, so a failure leaves the message
+// quarantined rather than lost, at the risk of requeueing it twice.
+func (q *quarantineService) requeue(ctx context.Context, msg *rabbitmq.QuarantinedMessage) error {
+	if err := q.rabbitmq.Requeue(ctx, msg); err != nil {
+		return err
+	}
+
+	if err := q.store.Delete(ctx, msg.ID); err != nil && !errors.Is(err, rabbitmq.ErrQuarantinedMessageNotFound) {
+		q.logger.Error("Failed to remove requeued message from quarantine", logger.Field{Key: "id", Value: msg.ID}, logger.Field{Key: "error", Value: err.Error()})
+		return err
+	}
+
+	return nil
+}
+
+func (q *quarantineService) each(ctx context.Context, query *QuarantineQuery, fn func(ctx context.Context, msg *rabbitmq.QuarantinedMessage) error) (int, error) {
+	filter, err := quarantineFilter(query)
+	if err != nil {
+		return 0, err
+	}
+
+	msgs, _, err := q.store.List(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, msg := range msgs {
+		if err := fn(ctx, msg); err != nil {
+			return i, err
+		}
+	}
+
+	return len(msgs), nil
+}
+
+func quarantineFilter(query *QuarantineQuery) (*rabbitmq.QuarantineFilter, error) {
+	if _, err := path.Match(query.Pattern, ""); err != nil {
+		return nil, fmt.Errorf("%w: pattern: %w", ErrInvalidQuarantineQuery, err)
+	}
+
+	return &rabbitmq.QuarantineFilter{Pattern: query.Pattern, Queue: query.Queue}, nil
+}
@@ -2,30 +2,35 @@ package service
 
 import (
 	"context"
+	"sync"
 	"time"
 
-	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/constant"
+	"github.com/google/uuid"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/eventbus"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/events"
 	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
-	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/rabbitmq"
 )
 
 type UserService interface {
 	Create(ctx context.Context, user *User) (*User, error)
+	CreateBatch(ctx context.Context, users []*User) ([]*User, []error)
 }
 
 type userService struct {
-	rabbitmq rabbitmq.RabbitMQ
-	logger   logger.Logger
-	users    []*User
+	events *events.Publisher
+	logger logger.Logger
+	mu     sync.Mutex
+	users  []*User
 }
 
 type UserServiceOpts struct {
-	Rabbitmq rabbitmq.RabbitMQ
+	EventBus eventbus.EventBus
 	Logger   logger.Logger
 }
 
 type User struct {
 	ID       int    `json:"id"`
+	UUID     string `json:"uuid"`
 	Name     string `json:"name"`
 	Email    string `json:"email"`
 	Password string `json:"password,omitempty"`
@@ -33,9 +38,9 @@ type User struct {
 
 func NewUserService(opts *UserServiceOpts) *userService {
 	return &userService{
-		rabbitmq: opts.Rabbitmq,
-		logger:   opts.Logger,
-		users:    []*User{},
+		events: events.NewPublisher(opts.EventBus),
+		logger: opts.Logger,
+		users:  []*User{},
 	}
 }
 
@@ -43,17 +48,22 @@ func (u *userService) Create(ctx context.Context, user *User) (*User, error) {
 	//Pretend DB query
 	time.Sleep(100 * time.Millisecond)
 
+	u.mu.Lock()
 	user.ID = 1
 	if len(u.users) > 0 {
 		user.ID = u.users[len(u.users)-1].ID + 1
 	}
+	user.UUID = uuid.NewString()
 	user.Password = ""
 
 	u.users = append(u.users, user)
+	u.mu.Unlock()
 
-	err := u.rabbitmq.Publish(ctx, constant.QUEUE_NOTIFICATION_SERVICE, &rabbitmq.MessageType{
-		Pattern: constant.EVENT_USER_CREATED,
-		Data:    user,
+	err := u.events.PublishUserCreated(ctx, events.UserCreated{
+		ID:    user.ID,
+		UUID:  user.UUID,
+		Name:  user.Name,
+		Email: user.Email,
 	})
 	if err != nil {
 		return nil, err
@@ -61,3 +71,38 @@ func (u *userService) Create(ctx context.Context, user *User) (*User, error) {
 
 	return user, nil
 }
+
+// CreateBatch creates users and publishes their events as one batch. It
+// returns the error publishing the event of each user, nil for those
+// published.
+func (u *userService) CreateBatch(ctx context.Context, users []*User) ([]*User, []error) {
+	//Pretend DB query
+	time.Sleep(100 * time.Millisecond)
+
+	u.mu.Lock()
+	nextID := 1
+	if len(u.users) > 0 {
+		nextID = u.users[len(u.users)-1].ID + 1
+	}
+	for _, user := range users {
+		user.ID = nextID
+		user.UUID = uuid.NewString()
+		user.Password = ""
+		nextID++
+	}
+
+	u.users = append(u.users, users...)
+	u.mu.Unlock()
+
+	payloads := make([]events.UserCreated, len(users))
+	for i, user := range users {
+		payloads[i] = events.UserCreated{
+			ID:    user.ID,
+			UUID:  user.UUID,
+			Name:  user.Name,
+			Email: user.Email,
+		}
+	}
+
+	return users, u.events.PublishUserCreatedBatch(ctx, payloads)
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/config"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/events"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/rabbitmq"
+)
+
+func newTestUserService(t *testing.T) (*userService, *rabbitmq.MemoryBroker) {
+	t.Helper()
+
+	log := logger.NewZerologLogger("error", nil)
+	broker := rabbitmq.NewMemoryBroker(&rabbitmq.Opts{
+		Logger: log,
+		Config: &config.AMQP{DefaultCodec: "json"},
+	})
+	t.Cleanup(func() { _ = broker.Close(context.Background()) })
+
+	svc := NewUserService(&UserServiceOpts{
+		EventBus: rabbitmq.NewEventBus(&rabbitmq.EventBusOpts{RabbitMQ: broker, Logger: log}),
+		Logger:   log,
+	})
+
+	return svc, broker
+}
+
+func TestCreatePublishesUserCreated(t *testing.T) {
+	svc, broker := newTestUserService(t)
+
+	user, err := svc.Create(context.Background(), &User{Name: "Jane", Email: "jane@example.com", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != 1 || user.UUID == "" || user.Password != "" {
+		t.Errorf("got user %+v, want id 1 and a uuid without a password", user)
+	}
+
+	broker.ExpectEvent(t, "user.created", events.UserCreated{ID: 1, UUID: user.UUID, Name: "Jane", Email: "jane@example.com"})
+}
+
+func TestCreateBatchPublishesEveryUser(t *testing.T) {
+	svc, broker := newTestUserService(t)
+
+	users, errs := svc.CreateBatch(context.Background(), []*User{
+		{Name: "Jane", Email: "jane@example.com"},
+		{Name: "John", Email: "john@example.com"},
+	})
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("user %d: %v", i, err)
+		}
+	}
+
+	if users[0].UUID == users[1].UUID {
+		t.Errorf("users share the uuid %s", users[0].UUID)
+	}
+	for _, user := range users {
+		broker.ExpectEvent(t, "user.created", map[string]any{"id": user.ID, "uuid": user.UUID, "email": user.Email})
+	}
+	if n := len(broker.Published("user.created")); n != 2 {
+		t.Errorf("got %d user.created events, want 2", n)
+	}
+}
+
+func TestCreateFailsWhileBrokerIsDown(t *testing.T) {
+	svc, broker := newTestUserService(t)
+
+	broker.SimulateOutage()
+
+	_, err := svc.Create(context.Background(), &User{Name: "Jane", Email: "jane@example.com"})
+	if !errors.Is(err, rabbitmq.ErrNotConnected) {
+		t.Fatalf("got error %v, want %v", err, rabbitmq.ErrNotConnected)
+	}
+	broker.ExpectNoEvent(t, "user.created")
+
+	broker.Restore()
+
+	if _, err := svc.Create(context.Background(), &User{Name: "John", Email: "john@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	broker.ExpectEvent(t, "user.created", map[string]any{"email": "john@example.com"})
+}
@@ -0,0 +1,51 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+type TracerProviderOpts struct {
+	ServiceName    string
+	ServiceVersion string
+	// OTLPEndpoint is the base URL of the OTLP/HTTP collector spans are
+	// exported to; empty exports nothing.
+	OTLPEndpoint string
+}
+
+// NewTracerProvider installs a global tracer provider together with the W3C
+// trace context and baggage propagators, so trace ids are generated here and
+// carried across HTTP and AMQP hops.
+func NewTracerProvider(ctx context.Context, opts *TracerProviderOpts) (*sdktrace.TracerProvider, error) {
+	options := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", opts.ServiceName),
+			attribute.String("service.version", opts.ServiceVersion),
+		)),
+	}
+
+	if opts.OTLPEndpoint != "" {
+		exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(strings.TrimSuffix(opts.OTLPEndpoint, "/")+"/v1/traces"))
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, sdktrace.WithBatcher(exporter))
+	}
+
+	tp := sdktrace.NewTracerProvider(options...)
+
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	return tp, nil
+}
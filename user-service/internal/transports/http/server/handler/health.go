@@ -29,7 +29,7 @@ func (h *HealthHandler) Livez(c *gin.Context) {
 func (h *HealthHandler) Readyz(c *gin.Context) {
 	status := h.healthService.Check(c)
 
-	if status.Status == "ready" {
+	if status.Status != service.HealthStatusUnready {
 		c.JSON(http.StatusOK, status)
 	} else {
 		c.JSON(http.StatusServiceUnavailable, status)
@@ -0,0 +1,204 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/rabbitmq"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/service"
+)
+
+type QuarantineHandlerOpts struct {
+	QuarantineService service.QuarantineService
+	Logger            logger.Logger
+}
+
+type QuarantineHandler struct {
+	quarantineService service.QuarantineService
+	logger            logger.Logger
+}
+
+// quarantinedMessage is how a quarantined message is shown. The body is
+// decompressed, and shown as JSON when it is, base64 encoded otherwise.
+type quarantinedMessage struct {
+	ID              string          `json:"id"`
+	Queue           string          `json:"queue"`
+	Pattern         string          `json:"pattern"`
+	Reason          string          `json:"reason"`
+	Attempts        int             `json:"attempts"`
+	QuarantinedAt   time.Time       `json:"quarantined_at"`
+	MessageID       string          `json:"message_id,omitempty"`
+	CorrelationID   string          `json:"correlation_id,omitempty"`
+	Stack           string          `json:"stack,omitempty"`
+	ContentType     string          `json:"content_type,omitempty"`
+	ContentEncoding string          `json:"content_encoding,omitempty"`
+	Headers         map[string]any  `json:"headers,omitempty"`
+	Body            json.RawMessage `json:"body,omitempty"`
+	BodyBase64      string          `json:"body_base64,omitempty"`
+}
+
+type quarantineQuery struct {
+	Pattern string `form:"pattern" json:"pattern"`
+	Queue   string `form:"queue" json:"queue"`
+	Page    int    `form:"page"`
+	PerPage int    `form:"per_page"`
+}
+
+func NewQuarantineHandler(opts *QuarantineHandlerOpts) *QuarantineHandler {
+	return &QuarantineHandler{quarantineService: opts.QuarantineService, logger: opts.Logger}
+}
+
+// List returns a page of quarantined messages, filtered by the pattern glob
+// and queue query parameters, without their stacks and bodies.
+func (h *QuarantineHandler) List(c *gin.Context) {
+	var in quarantineQuery
+	if err := c.ShouldBindQuery(&in); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := h.quarantineService.List(c.Request.Context(), &service.QuarantineQuery{
+		Pattern: in.Pattern,
+		Queue:   in.Queue,
+		Page:    in.Page,
+		PerPage: in.PerPage,
+	})
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+
+	items := make([]*quarantinedMessage, len(page.Items))
+	for i, msg := range page.Items {
+		items[i] = summarize(msg)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items":    items,
+		"total":    page.Total,
+		"page":     page.Page,
+		"per_page": page.PerPage,
+	})
+}
+
+func (h *QuarantineHandler) Show(c *gin.Context) {
+	msg, err := h.quarantineService.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+
+	out := summarize(msg)
+	out.Stack = msg.Stack
+	out.ContentType = msg.Publishing.ContentType
+	out.ContentEncoding = msg.Publishing.ContentEncoding
+	out.Headers = msg.Publishing.Headers
+
+	payload, err := msg.Payload()
+	if err != nil {
+		payload = msg.Publishing.Body
+	}
+	if json.Valid(payload) {
+		out.Body = payload
+	} else {
+		out.BodyBase64 = base64.StdEncoding.EncodeToString(payload)
+	}
+
+	c.JSON(http.StatusOK, out)
+}
+
+// Requeue publishes a message back to the queue it failed on. An optional
+// JSON body with "body" and "headers" edits it first.
+func (h *QuarantineHandler) Requeue(c *gin.Context) {
+	var edit *service.QuarantineEdit
+	if c.Request.ContentLength != 0 {
+		edit = &service.QuarantineEdit{}
+		if err := c.ShouldBindJSON(edit); err != nil && !errors.Is(err, io.EOF) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if err := h.quarantineService.Requeue(c.Request.Context(), c.Param("id"), edit); err != nil {
+		h.error(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"requeued": 1})
+}
+
+// RequeueAll requeues every message matching the pattern and queue in the
+// JSON body.
+func (h *QuarantineHandler) RequeueAll(c *gin.Context) {
+	var in quarantineQuery
+	if err := c.ShouldBindJSON(&in); err != nil && !errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	n, err := h.quarantineService.RequeueAll(c.Request.Context(), &service.QuarantineQuery{Pattern: in.Pattern, Queue: in.Queue})
+	if err != nil {
+		h.error(c, err, logger.Field{Key: "requeued", Value: n})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"requeued": n})
+}
+
+func (h *QuarantineHandler) Delete(c *gin.Context) {
+	if err := h.quarantineService.Delete(c.Request.Context(), c.Param("id")); err != nil {
+		h.error(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// Purge deletes every message matching the pattern and queue query
+// parameters, or all of them when there are none.
+func (h *QuarantineHandler) Purge(c *gin.Context) {
+	var in quarantineQuery
+	if err := c.ShouldBindQuery(&in); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	n, err := h.quarantineService.Purge(c.Request.Context(), &service.QuarantineQuery{Pattern: in.Pattern, Queue: in.Queue})
+	if err != nil {
+		h.error(c, err, logger.Field{Key: "purged", Value: n})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"purged": n})
+}
+
+func (h *QuarantineHandler) error(c *gin.Context, err error, fields ...logger.Field) {
+	switch {
+	case errors.Is(err, rabbitmq.ErrQuarantinedMessageNotFound):
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidQuarantineQuery):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		h.logger.Error("Quarantine request failed", append(fields, logger.Field{Key: "error", Value: err.Error()})...)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+func summarize(msg *rabbitmq.QuarantinedMessage) *quarantinedMessage {
+	return &quarantinedMessage{
+		ID:            msg.ID,
+		Queue:         msg.Queue,
+		Pattern:       msg.Pattern,
+		Reason:        msg.Reason,
+		Attempts:      msg.Attempts,
+		QuarantinedAt: msg.QuarantinedAt,
+		MessageID:     msg.Publishing.MessageId,
+		CorrelationID: msg.Publishing.CorrelationId,
+	}
+}
@@ -45,3 +45,39 @@ func (u *userHandler) CreateUser(c *gin.Context) {
 
 	c.JSON(http.StatusOK, user)
 }
+
+type createUserResult struct {
+	*service.User
+	Error string `json:"error,omitempty"`
+}
+
+// CreateUsers creates the users in the request body at once. Each user in the
+// response has an error if its event could not be published.
+func (u *userHandler) CreateUsers(c *gin.Context) {
+	var in []CreateUserInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	users := make([]*service.User, len(in))
+	for i, user := range in {
+		users[i] = &service.User{
+			Name:     user.Name,
+			Email:    user.Email,
+			Password: user.Password,
+		}
+	}
+
+	created, errs := u.userService.CreateBatch(c.Request.Context(), users)
+
+	out := make([]createUserResult, len(created))
+	for i, user := range created {
+		out[i] = createUserResult{User: user}
+		if errs[i] != nil {
+			out[i].Error = errs[i].Error()
+		}
+	}
+
+	c.JSON(http.StatusOK, out)
+}
@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/eventbus"
+)
+
+const HeaderCorrelationID = "X-Correlation-ID"
+
+// CorrelationIDMiddleware propagates the caller's correlation id, or starts a
+// new one, so that events published while handling the request share it.
+func CorrelationIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(HeaderCorrelationID)
+		if id == "" {
+			id = uuid.NewString()
+		}
+
+		c.Header(HeaderCorrelationID, id)
+		c.Request = c.Request.WithContext(eventbus.WithCorrelationID(c.Request.Context(), id))
+
+		c.Next()
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/config"
 	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/logger"
 	"github.com/sagarmaheshwary/kind-microservices-demo/user-service/internal/service"
@@ -17,6 +18,8 @@ type Opts struct {
 	Logger        logger.Logger
 	HealthService service.HealthService
 	UserService   service.UserService
+	// QuarantineService is nil when quarantined messages are not collected.
+	QuarantineService service.QuarantineService
 }
 
 type HTTPServer struct {
@@ -32,6 +35,8 @@ func NewServer(opts *Opts) *HTTPServer {
 	r.Use(
 		gin.Recovery(),
 		middleware.ZerologMiddleware(),
+		middleware.TracingMiddleware(),
+		middleware.CorrelationIDMiddleware(),
 	)
 
 	healthHandler := handler.NewHealthHandler(&handler.HealthHandlerOpts{
@@ -44,7 +49,28 @@ func NewServer(opts *Opts) *HTTPServer {
 
 	r.GET("/livez", healthHandler.Livez)
 	r.GET("/readyz", healthHandler.Readyz)
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
 	r.POST("/users", userHandler.CreateUser)
+	r.POST("/users/batch", userHandler.CreateUsers)
+
+	if opts.QuarantineService != nil {
+		if opts.Config.AdminToken == "" {
+			opts.Logger.Warn("ADMIN_API_TOKEN is not set, quarantine admin API disabled")
+		} else {
+			quarantineHandler := handler.NewQuarantineHandler(&handler.QuarantineHandlerOpts{
+				QuarantineService: opts.QuarantineService,
+				Logger:            opts.Logger,
+			})
+
+			admin := r.Group("/admin", middleware.AdminTokenMiddleware(opts.Config.AdminToken))
+			admin.GET("/quarantine", quarantineHandler.List)
+			admin.DELETE("/quarantine", quarantineHandler.Purge)
+			admin.POST("/quarantine/requeue", quarantineHandler.RequeueAll)
+			admin.GET("/quarantine/:id", quarantineHandler.Show)
+			admin.DELETE("/quarantine/:id", quarantineHandler.Delete)
+			admin.POST("/quarantine/:id/requeue", quarantineHandler.Requeue)
+		}
+	}
 
 	return &HTTPServer{
 		Config: opts.Config,